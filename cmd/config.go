@@ -0,0 +1,206 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/apex/log"
+	"github.com/spf13/cast"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/springload/ecs-tool/lib"
+)
+
+var (
+	// configFiles are the files the config was merged from, in order
+	configFiles []string
+	// configSettings is the merged config, without flags and environment variables
+	configSettings map[string]interface{}
+	// configSources has the file every config value came from
+	configSources map[string]string
+)
+
+// configCmd represents the config command
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Shows the config",
+}
+
+var configShowCmd = &cobra.Command{
+	Use:   "show",
+	Short: "Prints the effective config and where each value came from",
+	Long: `Prints the config merged from infra/ecs.toml, the environment config and their includes,
+with the variables like ${git.sha} resolved and the file each value came from. Values overridden by flags or ECS_* environment variables
+are printed as they are used.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		keys := make(map[string]bool)
+		for key := range configSources {
+			keys[key] = true
+		}
+		// settings nobody put in a file, but set by flags or the environment
+		for _, key := range lib.ConfigKeys {
+			if _, inFile := configSources[key.Key]; !inFile && viper.IsSet(key.Key) && !isZero(viper.Get(key.Key)) {
+				keys[key.Key] = true
+			}
+		}
+		sorted := make([]string, 0, len(keys))
+		for key := range keys {
+			sorted = append(sorted, key)
+		}
+		sort.Strings(sorted)
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		for _, key := range sorted {
+			value := viper.Get(key)
+			source, inFile := configSources[key]
+			if !inFile || !sameSetting(value, lookupSetting(configSettings, key)) {
+				source = "flag, environment or default"
+			}
+			out, err := json.Marshal(value)
+			if err != nil {
+				out = []byte(fmt.Sprint(value))
+			}
+			fmt.Fprintf(w, "%s = %s\t# %s\n", key, out, source)
+		}
+		w.Flush()
+	},
+}
+
+func isZero(value interface{}) bool {
+	return value == nil || reflect.ValueOf(value).IsZero() ||
+		(reflect.ValueOf(value).Kind() == reflect.Slice && reflect.ValueOf(value).Len() == 0)
+}
+
+// sameSetting compares the values the way commands read them
+func sameSetting(a, b interface{}) bool {
+	if reflect.ValueOf(a).Kind() == reflect.Slice || reflect.ValueOf(b).Kind() == reflect.Slice {
+		return reflect.DeepEqual(cast.ToStringSlice(a), cast.ToStringSlice(b))
+	}
+	return cast.ToString(a) == cast.ToString(b)
+}
+
+// lookupSetting finds the dotted key in the nested settings
+func lookupSetting(settings map[string]interface{}, key string) interface{} {
+	var value interface{} = settings
+	for _, name := range strings.Split(key, ".") {
+		table, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = table[name]
+	}
+	return value
+}
+
+// readConfigLayer reads the file after the files it includes. Includes are relative to the file
+func readConfigLayer(file string, seen map[string]bool) ([]lib.ConfigLayer, error) {
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		return nil, err
+	}
+	if seen[abs] {
+		return nil, fmt.Errorf("%s is included more than once", file)
+	}
+	seen[abs] = true
+
+	config := viper.New()
+	config.SetConfigFile(file)
+	if err := config.ReadInConfig(); err != nil {
+		return nil, fmt.Errorf("can't read %s: %w", file, err)
+	}
+	settings := config.AllSettings()
+
+	var layers []lib.ConfigLayer
+	if include, ok := settings["include"]; ok {
+		delete(settings, "include")
+		includes, err := cast.ToStringSliceE(include)
+		if err != nil {
+			return nil, fmt.Errorf("include in %s should be a list of files: %w", file, err)
+		}
+		for _, included := range includes {
+			if !filepath.IsAbs(included) {
+				included = filepath.Join(filepath.Dir(file), included)
+			}
+			includedLayers, err := readConfigLayer(included, seen)
+			if err != nil {
+				return nil, err
+			}
+			layers = append(layers, includedLayers...)
+		}
+	}
+	return append(layers, lib.ConfigLayer{File: file, Settings: settings}), nil
+}
+
+// configLayers returns the layers of the config file. Environment configs, named like ecs-$environment.toml,
+// are merged over the shared config in the same directory, ecs.toml, if there's one
+func configLayers(file string) ([]lib.ConfigLayer, error) {
+	seen := make(map[string]bool)
+	var layers []lib.ConfigLayer
+	if _, pattern, ok := lib.MatchConfigPattern(configPatterns(), file); ok {
+		base := filepath.Join(filepath.Dir(file), pattern.Base())
+		if _, err := os.Stat(base); err == nil {
+			baseLayers, err := readConfigLayer(base, seen)
+			if err != nil {
+				return nil, err
+			}
+			layers = append(layers, baseLayers...)
+		}
+	}
+	fileLayers, err := readConfigLayer(file, seen)
+	if err != nil {
+		return nil, err
+	}
+	return append(layers, fileLayers...), nil
+}
+
+// loadConfig merges the config layers and resolves the variables
+func loadConfig(file, environment string) (settings map[string]interface{}, sources map[string]string, files []string, err error) {
+	layers, err := configLayers(file)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	for _, layer := range layers {
+		files = append(files, layer.File)
+	}
+	log.WithField("files", files).Debug("Merging the config")
+	settings, sources = lib.MergeConfigLayers(layers)
+
+	if name, _, ok := lib.MatchConfigPattern(configPatterns(), file); environment == "" && ok {
+		environment = name
+	}
+	if err := lib.InterpolateConfig(settings, lib.ConfigVariables(environment)); err != nil {
+		return nil, nil, nil, err
+	}
+	return settings, sources, files, nil
+}
+
+// readConfig loads the config into viper
+func readConfig(file string) error {
+	var err error
+	configSettings, configSources, configFiles, err = loadConfig(file, environment)
+	if err != nil {
+		return err
+	}
+
+	// viper can't take a map as the config, so it's passed as JSON
+	merged, err := json.Marshal(configSettings)
+	if err != nil {
+		return err
+	}
+	viper.SetConfigFile(file)
+	viper.SetConfigType("json")
+	return viper.ReadConfig(bytes.NewReader(merged))
+}
+
+func init() {
+	rootCmd.AddCommand(configCmd)
+	configCmd.AddCommand(configShowCmd)
+}
@@ -16,6 +16,7 @@ package cmd
 
 import (
 	"os"
+	"time"
 
 	"github.com/apex/log"
 	"github.com/spf13/cobra"
@@ -29,27 +30,94 @@ var deployCmd = &cobra.Command{
 	Short: "Creates a new ECS Deployment",
 	Long: `Creates a new ECS Deployment and checks the result.
 
-If deployment failed, then rolls back to the previous stack definition.`,
+If deployment failed, then rolls back to the previous stack definition.
+
+Scheduled tasks selected in [deploy.scheduled] are pointed at the new task definition
+once the service is stable, and are rolled back together with the service.`,
 	Args: cobra.NoArgs,
 	Run: func(cmd *cobra.Command, args []string) {
-		if len(viper.GetStringSlice("deploy.services")) == 0 {
-			log.Error("Can't deploy anything if no service is set")
-			os.Exit(1)
-		}
+		runDeploy("")
+	},
+}
+
+// runDeploy deploys deploy.services, holding the deploy lock if enabled, and exits with the deploy exit code.
+// If taskDefinitionArn is set, the services are updated to it instead of a copy of their current task definitions
+func runDeploy(taskDefinitionArn string) {
+	ctx, stop := commandContext()
+	defer stop()
 
-		exitCode, err := lib.DeployServices(
-			viper.GetString("profile"),
+	if len(viper.GetStringSlice("deploy.services")) == 0 {
+		log.Error("Can't deploy anything if no service is set")
+		os.Exit(1)
+	}
+
+	release := func() error { return nil }
+	if viper.GetBool("deploy.lock.enabled") {
+		locker, err := newDeployLocker(ctx)
+		if err != nil {
+			log.WithError(err).Error("Can't create the deploy lock")
+			os.Exit(lib.ExitCode(err))
+		}
+		release, err = lib.AcquireDeployLock(
+			ctx,
+			locker,
 			viper.GetString("cluster"),
-			viper.GetString("image_tag"),
-			viper.GetStringSlice("image_tags"),
 			viper.GetStringSlice("deploy.services"),
-			viper.GetString("workdir"),
+			viper.GetString("deploy.lock.owner"),
+			viper.GetDuration("deploy.lock.ttl"),
 		)
 		if err != nil {
-			log.WithError(err).Errorf("Deployment failed with code %d", exitCode)
+			log.WithError(err).Error("Can't acquire the deploy lock")
+			os.Exit(lib.ExitCode(err))
 		}
-		os.Exit(exitCode)
-	},
+	}
+
+	var scheduled lib.ScheduledTasks
+	if err := viper.UnmarshalKey("deploy.scheduled", &scheduled); err != nil {
+		log.WithError(err).Error("Can't parse the deploy.scheduled config")
+		os.Exit(lib.ExitCode(err))
+	}
+
+	result, err := lib.DeployServices(ctx, lib.DeployOptions{
+		Profile:           viper.GetString("profile"),
+		Cluster:           viper.GetString("cluster"),
+		Services:          viper.GetStringSlice("deploy.services"),
+		ImageTag:          viper.GetString("image_tag"),
+		ImageTags:         viper.GetStringSlice("image_tags"),
+		WorkDir:           viper.GetString("workdir"),
+		Tags:              viper.GetStringSlice("deploy.tags"),
+		Scheduled:         scheduled,
+		TaskDefinitionArn: taskDefinitionArn,
+	})
+	if err != nil {
+		log.WithError(err).Errorf("Deployment failed with code %d", lib.ExitCode(err))
+	}
+	if err := release(); err != nil {
+		log.WithError(err).Error("Can't release the deploy lock")
+	}
+	status := newExitStatus(lib.ExitCode(err), err)
+	if jsonOutput() {
+		type serviceDeployment struct {
+			lib.ServiceDeployment
+			Error string `json:"error,omitempty"`
+		}
+		var services []serviceDeployment
+		if result != nil {
+			for _, deployment := range result.Services {
+				service := serviceDeployment{ServiceDeployment: deployment}
+				if deployment.Err != nil {
+					service.Error = deployment.Err.Error()
+				}
+				services = append(services, service)
+			}
+		}
+		printJSON(struct {
+			exitStatus
+			Services []serviceDeployment `json:"services"`
+		}{status, services})
+	}
+	lib.FlushNotifications(notifyFlushTimeout)
+	os.Exit(status.Code)
 }
 
 func init() {
@@ -58,4 +126,10 @@ func init() {
 	if err := viper.BindPFlag("deploy.services", deployCmd.PersistentFlags().Lookup("service")); err != nil {
 		log.WithError(err).Fatal("can't bind flag to config")
 	}
+	deployCmd.PersistentFlags().StringSliceP("tag", "", []string{}, "Extra tag to add to the registered task definitions, as key=value. Can be specified multiple times")
+	viper.BindPFlag("deploy.tags", deployCmd.PersistentFlags().Lookup("tag"))
+	deployCmd.PersistentFlags().BoolP("lock", "", false, "Take a deploy lock on the services, so that concurrent deploys to the same environment fail instead of overwriting each other")
+	viper.BindPFlag("deploy.lock.enabled", deployCmd.PersistentFlags().Lookup("lock"))
+	deployCmd.PersistentFlags().DurationP("lock_ttl", "", 30*time.Minute, "How long the deploy lock is valid for. Expired locks are taken over by the next deploy")
+	viper.BindPFlag("deploy.lock.ttl", deployCmd.PersistentFlags().Lookup("lock_ttl"))
 }
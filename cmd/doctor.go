@@ -0,0 +1,123 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/springload/ecs-tool/lib"
+)
+
+// doctorCmd represents the doctor command
+var doctorCmd = &cobra.Command{
+	Use:   "doctor",
+	Short: "Checks the config and the AWS resources it refers to",
+	Long: `Validates the config, i.e. "ecs-tool doctor -e prod", and checks that
+  - the settings have the right types and the required ones are set for each command
+  - the profile resolves credentials
+  - the cluster, services and task definition exist
+  - the container names are in the task definition
+  - the log group exists
+  - the KMS key alias resolves and the ejson file decrypts
+
+The required settings are checked for deploy, run and the commands with a section in the config,
+or the ones given with --command.
+
+Exits with 1 if any check fails.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx, stop := commandContext()
+		defer stop()
+
+		checks := configChecks(viper.GetStringSlice("doctor.commands"))
+
+		failures := lib.Doctor(ctx, lib.DoctorConfig{
+			Profile:           viper.GetString("profile"),
+			Cluster:           viper.GetString("cluster"),
+			TaskDefinition:    viper.GetString("task_definition"),
+			Containers:        unique(viper.GetString("container_name")),
+			SSHTaskDefinition: viper.GetString("ssh.task_definition"),
+			SSHContainer:      viper.GetString("ssh.container_name"),
+			Services:          unique(append(viper.GetStringSlice("deploy.services"), viper.GetString("run.service"), viper.GetString("ssh.service"))...),
+			LogGroup:          viper.GetString("log_group"),
+			KMSKey:            viper.GetString("ejson.kms_key"),
+			EjsonFile:         viper.GetString("ejson.file"),
+			EjsonKeyDir:       os.Getenv(viper.GetString("ejson.dirvar")),
+			EjsonPrivateKey:   os.Getenv(viper.GetString("ejson.keyvar")),
+		}, checks)
+		if failures > 0 {
+			os.Exit(1)
+		}
+	},
+}
+
+// configChecks validates the config file and the required settings of the commands
+func configChecks(commands []string) []lib.Check {
+	var checks []lib.Check
+	file := viper.ConfigFileUsed()
+	if file == "" {
+		checks = append(checks, lib.Check{Name: "config", Status: lib.CheckWarning, Detail: "no config file, use -e or --config"})
+	} else {
+		errs, unknown := lib.ValidateConfig(configSettings)
+		for _, err := range errs {
+			checks = append(checks, lib.Check{Name: "config", Status: lib.CheckFailed, Detail: err.Error()})
+		}
+		for _, key := range unknown {
+			checks = append(checks, lib.Check{Name: "config", Status: lib.CheckWarning, Detail: fmt.Sprintf("%s in %s isn't used by any command", key, configSources[key])})
+		}
+		if len(errs) == 0 {
+			checks = append(checks, lib.Check{Name: "config", Status: lib.CheckPassed, Detail: strings.Join(configFiles, ", ")})
+		}
+
+		if len(commands) == 0 {
+			commands = []string{"deploy", "run"}
+			for _, command := range []string{"ssh", "taskdef", "ejson"} {
+				if _, ok := configSettings[command]; ok {
+					commands = append(commands, command)
+				}
+			}
+		}
+	}
+
+	for _, command := range commands {
+		required, ok := lib.RequiredConfig[command]
+		name := "command " + command
+		if !ok {
+			checks = append(checks, lib.Check{Name: name, Status: lib.CheckSkipped, Detail: "nothing is required"})
+			continue
+		}
+		var missing []string
+		for _, key := range required {
+			if viper.GetString(key) == "" && len(viper.GetStringSlice(key)) == 0 {
+				missing = append(missing, key)
+			}
+		}
+		if len(missing) > 0 {
+			checks = append(checks, lib.Check{Name: name, Status: lib.CheckFailed, Detail: fmt.Sprintf("%s not set", strings.Join(missing, ", "))})
+		} else {
+			checks = append(checks, lib.Check{Name: name, Status: lib.CheckPassed, Detail: strings.Join(required, ", ")})
+		}
+	}
+	return checks
+}
+
+// unique drops the empty and repeated values
+func unique(values ...string) []string {
+	seen := make(map[string]bool)
+	var result []string
+	for _, value := range values {
+		if value != "" && !seen[value] {
+			seen[value] = true
+			result = append(result, value)
+		}
+	}
+	return result
+}
+
+func init() {
+	rootCmd.AddCommand(doctorCmd)
+	doctorCmd.PersistentFlags().StringSliceP("command", "", []string{}, "commands to check the required settings of. Can be specified multiple times")
+	viper.BindPFlag("doctor.commands", doctorCmd.PersistentFlags().Lookup("command"))
+}
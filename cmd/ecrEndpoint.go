@@ -2,6 +2,7 @@ package cmd
 
 import (
 	"log"
+	"os"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -16,10 +17,15 @@ var ecrEndpointCmd = &cobra.Command{
 Prints the ECR endpoint, which is constructed as {account_number}.dkr.ecr.{region}.amazonaws.com
 `,
 	Run: func(cmd *cobra.Command, args []string) {
+		ctx, stop := commandContext()
+		defer stop()
+
 		if err := lib.EcrEndpoint(
+			ctx,
 			viper.GetString("profile"),
 		); err != nil {
-			log.Fatal(err)
+			log.Println(err)
+			os.Exit(lib.ExitCode(err))
 		}
 	},
 }
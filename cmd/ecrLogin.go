@@ -2,6 +2,7 @@ package cmd
 
 import (
 	"log"
+	"os"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -18,11 +19,16 @@ Use it like so:
 $eval $(ecs-tool ecr-login)
 `,
 	Run: func(cmd *cobra.Command, args []string) {
+		ctx, stop := commandContext()
+		defer stop()
+
 		err := lib.EcrLogin(
+			ctx,
 			viper.GetString("profile"),
 		)
 		if err != nil {
-			log.Fatal(err)
+			log.Println(err)
+			os.Exit(lib.ExitCode(err))
 		}
 	},
 }
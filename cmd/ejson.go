@@ -21,6 +21,9 @@ The processor commands should read the decrypted ejson file from stdin and shoul
 the processed content to stdout.
 `,
 	Run: func(cmd *cobra.Command, args []string) {
+		ctx, stop := commandContext()
+		defer stop()
+
 		var kmsKey, parameterName, encryptedFile, privateKey, privateKeyDir string
 		var processor, pickJsonKeys []string
 
@@ -60,8 +63,9 @@ the processed content to stdout.
 		if err != nil {
 			log.WithError(err).Fatalf("can't decrypt the file %s", encryptedFile)
 		}
-		if err := lib.WriteSSMParameter(viper.GetString("profile"), parameterName, kmsKey, string(decryptedValue), processor, pickJsonKeys); err != nil {
-			log.WithError(err).Fatal("can't write the ssm parameter")
+		if err := lib.WriteSSMParameter(ctx, viper.GetString("profile"), parameterName, kmsKey, string(decryptedValue), processor, pickJsonKeys); err != nil {
+			log.WithError(err).Error("can't write the ssm parameter")
+			os.Exit(lib.ExitCode(err))
 		}
 	},
 }
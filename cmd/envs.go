@@ -1,26 +1,99 @@
 package cmd
 
 import (
+	"fmt"
+	"os"
 	"strings"
+	"text/tabwriter"
 
 	"github.com/apex/log"
+	"github.com/spf13/cast"
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/springload/ecs-tool/lib"
 )
 
 // envsCmd represents the envs command
 var envsCmd = &cobra.Command{
 	Use:   "envs",
 	Short: "Discover available environments",
+	Long: `Lists the environment configs found in --config_paths, from the current directory up,
+with file names matching --config_patterns.
+
+With --long each config is loaded and its cluster, profile, region and services are printed.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		envs, err := findEnvironments()
 		if err != nil {
 			log.WithError(err).Fatal("No environments have been found")
 		}
-		log.Infof("Found following environments: %s", strings.Join(envs, ", "))
-		log.Infof("Try running `ecs-tool run -e %s -- uptime`", envs[0])
+		if !viper.GetBool("envs.long") && !jsonOutput() {
+			var names []string
+			for _, env := range envs {
+				names = append(names, env.Name)
+			}
+			log.Infof("Found following environments: %s", strings.Join(names, ", "))
+			log.Infof("Try running `ecs-tool run -e %s -- uptime`", envs[0].Name)
+			return
+		}
+
+		type environmentInfo struct {
+			lib.Environment
+			Cluster  string   `json:"cluster,omitempty"`
+			Profile  string   `json:"profile,omitempty"`
+			Region   string   `json:"region,omitempty"`
+			Services []string `json:"services,omitempty"`
+			Error    string   `json:"error,omitempty"`
+		}
+		var infos []environmentInfo
+		for _, env := range envs {
+			info := environmentInfo{Environment: env}
+			if viper.GetBool("envs.long") {
+				settings, _, _, err := loadConfig(env.File, env.Name)
+				if err != nil {
+					info.Error = err.Error()
+				} else {
+					info.Cluster = cast.ToString(lookupSetting(settings, "cluster"))
+					info.Profile = cast.ToString(lookupSetting(settings, "profile"))
+					info.Region = cast.ToString(lookupSetting(settings, "region"))
+					info.Services = cast.ToStringSlice(lookupSetting(settings, "deploy.services"))
+				}
+			}
+			infos = append(infos, info)
+		}
+
+		if jsonOutput() {
+			printJSON(infos)
+		} else {
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "ENVIRONMENT\tCLUSTER\tPROFILE\tREGION\tSERVICES\tFILE")
+			for _, info := range infos {
+				services := strings.Join(info.Services, ",")
+				if info.Error != "" {
+					services = "error: " + info.Error
+				}
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n",
+					info.Name,
+					dashIfEmpty(info.Cluster),
+					dashIfEmpty(info.Profile),
+					dashIfEmpty(info.Region),
+					dashIfEmpty(services),
+					info.File,
+				)
+			}
+			w.Flush()
+		}
 	},
 }
 
+func dashIfEmpty(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
 func init() {
 	rootCmd.AddCommand(envsCmd)
+	envsCmd.PersistentFlags().BoolP("long", "l", false, "load the configs and print their cluster, profile, region and services")
+	viper.BindPFlag("envs.long", envsCmd.PersistentFlags().Lookup("long"))
 }
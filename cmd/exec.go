@@ -14,45 +14,44 @@ var execCmd = &cobra.Command{
     Use:   "exec",
     Short: "Executes a command in an existing ECS Fargate container",
 
-    Long: `Executes a specified command in a running container on an ECS Fargate cluster.`,
+    Long: `Executes a specified command in a running container on an ECS Fargate cluster.
+
+The container is picked with --service, --task_id and --container. If more than one matches,
+it's asked for on the terminal, showing the revision, age and health of the tasks. Without a terminal,
+i.e. in CI, the newest task is picked and the container has to be given if the task runs more than one.
+
+The container can also be given as the first argument, as in "exec app sh", if the task runs a container
+with that name. Put the command after -- to run it as is, as in "exec -- app --help".`,
     Args: cobra.MinimumNArgs(1),
+    PersistentPreRun: func(cmd *cobra.Command, args []string) {
+        // runCmd binds container_name to its own flag, so rebind it only when exec runs
+        viper.BindPFlag("container_name", cmd.Flags().Lookup("container"))
+        initConfig()
+    },
     Run: func(cmd *cobra.Command, args []string) {
-        viper.SetDefault("run.launch_type", "FARGATE")
-        var containerName string
-        var commandArgs []string
-        if name := viper.GetString("container_name"); name == "" {
-            // If container_name not provided via flag, use first arg as container name
-            if len(args) < 2 {
-                log.Error("When --container_name is not provided, at least 2 arguments are required: <container_name> <command>")
-                os.Exit(1)
-            }
-            containerName = args[0]
-            commandArgs = args[1:]
-        } else {
-            containerName = name
-            commandArgs = args
-        }
-
-        // Validate that we have a command to execute
-        if len(commandArgs) == 0 {
-            log.Error("No command provided to execute")
-            os.Exit(1)
-        }
+        ctx, stop := commandContext()
+        defer stop()
 
-        // Join the commandArgs to form a single command string
-        commandString := strings.Join(commandArgs, " ")
+        viper.SetDefault("run.launch_type", "FARGATE")
+        // Join the args to form a single command string
+        commandString := strings.Join(args, " ")
 
-        err := lib.ExecFargate(lib.ExecConfig{
+        err := lib.ExecFargate(ctx, lib.ExecConfig{
             Profile:            viper.GetString("profile"),
             Cluster:            viper.GetString("cluster"),
             Command:            commandString,
+            Service:            viper.GetString("exec.service"),
             TaskID:             viper.GetString("task_id"),
             TaskDefinitionName: viper.GetString("task_definition"),
-            ContainerName:      containerName,
+            ContainerName:      viper.GetString("container_name"),
+            // the first argument is the container if the task runs one with that name, as in "exec app sh",
+            // unless the command comes after --
+            ContainerArg:       cmd.ArgsLenAtDash() != 0,
+            Choose:             targetChooser(),
         })
         if err != nil {
             log.WithError(err).Error("Can't execute command in Fargate mode")
-            os.Exit(1)
+            os.Exit(lib.ExitCode(err))
         }
     },
 }
@@ -61,4 +60,7 @@ func init() {
     rootCmd.AddCommand(execCmd)
     execCmd.PersistentFlags().StringP("task_id", "", "", "Task ID to use (will auto-extract task definition)")
     viper.BindPFlag("task_id", execCmd.PersistentFlags().Lookup("task_id"))
+    execCmd.Flags().StringP("service", "s", "", "Service whose tasks to pick from")
+    viper.BindPFlag("exec.service", execCmd.Flags().Lookup("service"))
+    execCmd.Flags().StringP("container", "", "", "Container to run the command in")
 }
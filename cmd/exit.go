@@ -0,0 +1,92 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/spf13/viper"
+	"github.com/springload/ecs-tool/lib"
+)
+
+// notifyFlushTimeout is how long the webhooks get to receive the queued events before exiting
+const notifyFlushTimeout = 10 * time.Second
+
+// exitStatus is how ecs-tool exits, it's printed with --output json
+type exitStatus struct {
+	Code   int    `json:"exit_code"`
+	Reason string `json:"exit_reason"`
+	Error  string `json:"error,omitempty"`
+}
+
+func newExitStatus(code int, err error) exitStatus {
+	status := exitStatus{Code: code, Reason: lib.ExitCodes[code]}
+	if err != nil {
+		status.Error = err.Error()
+	}
+	return status
+}
+
+// jsonOutput tells if the results are printed as JSON instead of text
+func jsonOutput() bool {
+	switch output := viper.GetString("output"); output {
+	case "json":
+		return true
+	case "", "text", "table":
+		return false
+	default:
+		log.Fatalf("Unknown output %q, it can be text or json, table is the same as text", output)
+		return false
+	}
+}
+
+// printJSON prints the value as indented JSON
+func printJSON(v interface{}) {
+	out, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		log.WithError(err).Fatal("Can't print the result")
+	}
+	fmt.Println(string(out))
+}
+
+// exitRun prints the output of the one-off task and exits.
+// A command that exited with a non-zero code exits with 4, or with its own code if run.container_exit_code is set
+func exitRun(result *lib.RunResult, err error, containerName string) {
+	status := newExitStatus(lib.ExitCode(err), err)
+	if err != nil {
+		log.WithError(err).Error("Can't run task")
+	}
+
+	var containerExitCode *int
+	if result != nil {
+		for _, container := range result.Containers {
+			if container.Name == containerName && container.Reason == "" {
+				code := container.ExitCode
+				containerExitCode = &code
+			}
+		}
+	}
+	switch {
+	case containerExitCode == nil || status.Code == 130:
+	case viper.GetBool("run.container_exit_code"):
+		status.Code, status.Reason = *containerExitCode, "container_exit"
+	case err == nil && *containerExitCode != 0:
+		status = newExitStatus(4, nil)
+	}
+
+	if jsonOutput() {
+		printJSON(struct {
+			exitStatus
+			ContainerExitCode *int `json:"container_exit_code,omitempty"`
+			*lib.RunResult
+		}{status, containerExitCode, result})
+	} else if result != nil {
+		for _, line := range result.Logs {
+			fmt.Println(line)
+		}
+	}
+	lib.FlushNotifications(notifyFlushTimeout)
+	os.Exit(status.Code)
+}
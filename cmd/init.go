@@ -0,0 +1,112 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/apex/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/springload/ecs-tool/lib"
+)
+
+// initCmd represents the init command
+var initCmd = &cobra.Command{
+	Use:   "init",
+	Short: "Writes an environment config from a live cluster",
+	Long: `Inspects the services of --cluster, their task definitions, containers, awslogs groups
+and launch types, and writes infra/ecs-$environment.toml, or the file given with --config.
+
+It asks which service commands are run in and whether to overwrite an existing config,
+unless --yes is passed.`,
+	Args: cobra.NoArgs,
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		// init writes the config, so there's nothing to read yet, the flags and ENV still apply
+		skipConfig = true
+		initConfig()
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx, stop := commandContext()
+		defer stop()
+
+		cluster := viper.GetString("cluster")
+		if cluster == "" {
+			log.Error("Please specify the cluster with --cluster or -c")
+			os.Exit(1)
+		}
+		file := cfgFile
+		if file == "" {
+			if environment == "" {
+				log.Error("Please specify the environment with -e or the file with --config")
+				os.Exit(1)
+			}
+			// next to the existing environments, or in the first config path
+			dir := "infra"
+			if paths := viper.GetStringSlice("config_paths"); len(paths) > 0 {
+				dir = paths[0]
+			}
+			if envs, err := findEnvironments(); err == nil {
+				dir = filepath.Dir(envs[0].File)
+			}
+			name := fmt.Sprintf("ecs-%s.toml", environment)
+			for _, pattern := range configPatterns() {
+				if strings.HasSuffix(pattern.File(environment), ".toml") {
+					name = pattern.File(environment)
+					break
+				}
+			}
+			file = filepath.Join(dir, name)
+		}
+		yes := viper.GetBool("init.yes")
+		logger := log.WithField("config", file)
+
+		inspection, err := lib.InspectCluster(ctx, viper.GetString("profile"), cluster)
+		if err != nil {
+			log.WithError(err).Error("Can't inspect the cluster")
+			os.Exit(lib.ExitCode(err))
+		}
+
+		main := viper.GetString("init.service")
+		if main == "" {
+			main = inspection.Services[0].Name
+			if !yes && len(inspection.Services) > 1 {
+				var names []string
+				for _, service := range inspection.Services {
+					names = append(names, service.Name)
+				}
+				main = choose("Which service should one-off commands run in?", names)
+			}
+		}
+		config, err := lib.RenderInitConfig(inspection, main)
+		if err != nil {
+			log.WithError(err).Error("Can't render the config")
+			os.Exit(lib.ExitCode(err))
+		}
+
+		if _, err := os.Stat(file); err == nil {
+			if !yes && !confirm(fmt.Sprintf("%s already exists, overwrite it?", file)) {
+				os.Exit(1)
+			}
+			logger.Warn("Overwriting the existing config")
+		}
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			logger.WithError(err).Error("Can't create the directory")
+			os.Exit(lib.ExitCode(err))
+		}
+		if err := os.WriteFile(file, config, 0644); err != nil {
+			logger.WithError(err).Error("Can't write the config")
+			os.Exit(lib.ExitCode(err))
+		}
+		logger.WithField("service", main).Info("Wrote the config")
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(initCmd)
+	initCmd.PersistentFlags().StringP("service", "s", "", "service to run one-off commands in, asked for if not set")
+	initCmd.PersistentFlags().BoolP("yes", "y", false, "don't ask anything, overwrite the existing config")
+	viper.BindPFlag("init.service", initCmd.PersistentFlags().Lookup("service"))
+	viper.BindPFlag("init.yes", initCmd.PersistentFlags().Lookup("yes"))
+}
@@ -0,0 +1,66 @@
+package cmd
+
+import (
+	"os"
+
+	"github.com/apex/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/springload/ecs-tool/lib"
+)
+
+// localCmd represents the local command
+var localCmd = &cobra.Command{
+	Use:   "local",
+	Short: "Helps running tasks locally",
+}
+
+var localComposeCmd = &cobra.Command{
+	Use:   "compose",
+	Short: "Generates a docker compose file from the task definition",
+	Long: `Translates the containers of --task_definition into a docker compose file, so that the task
+can be reproduced locally with "docker compose up".
+
+Secrets are referenced as ${NAME}, which compose reads from the environment or the .env file.
+With --resolve_secrets their values are fetched from SSM Parameter Store or Secrets Manager
+and written to --env_file, which isn't overwritten without --force.
+
+Settings with no compose equivalent, like log configuration or EFS volumes, are reported as warnings.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx, stop := commandContext()
+		defer stop()
+
+		taskDefinition := viper.GetString("task_definition")
+		if taskDefinition == "" {
+			log.Error("Please specify the task definition with --task_definition or -t")
+			os.Exit(1)
+		}
+		err := lib.ComposeTaskDefinition(
+			ctx,
+			viper.GetString("profile"),
+			taskDefinition,
+			viper.GetString("local.file"),
+			viper.GetString("local.env_file"),
+			viper.GetBool("local.resolve_secrets"),
+			viper.GetBool("local.force"),
+		)
+		if err != nil {
+			log.WithError(err).Error("Can't generate the compose file")
+			os.Exit(lib.ExitCode(err))
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(localCmd)
+	localCmd.AddCommand(localComposeCmd)
+	localComposeCmd.PersistentFlags().StringP("file", "f", "", "file to write the compose file to, stdout by default")
+	localComposeCmd.PersistentFlags().StringP("env_file", "", ".env", "file to write the secret values to")
+	localComposeCmd.PersistentFlags().BoolP("resolve_secrets", "", false, "fetch the secret values into --env_file")
+	localComposeCmd.PersistentFlags().BoolP("force", "", false, "overwrite an existing --env_file")
+	viper.BindPFlag("local.file", localComposeCmd.PersistentFlags().Lookup("file"))
+	viper.BindPFlag("local.env_file", localComposeCmd.PersistentFlags().Lookup("env_file"))
+	viper.BindPFlag("local.resolve_secrets", localComposeCmd.PersistentFlags().Lookup("resolve_secrets"))
+	viper.BindPFlag("local.force", localComposeCmd.PersistentFlags().Lookup("force"))
+}
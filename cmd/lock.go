@@ -0,0 +1,81 @@
+package cmd
+
+import (
+	"context"
+	"os"
+
+	"github.com/apex/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/springload/ecs-tool/lib"
+)
+
+// lockCmd represents the lock command
+var lockCmd = &cobra.Command{
+	Use:   "lock",
+	Short: "Manages deploy locks",
+	Long: `Shows and releases the locks taken by "deploy --lock".
+
+Locks are kept in SSM Parameter Store, one per cluster and service. An expired lock is taken over
+by the next deploy, only one of the deploys that find it expired at the same time gets it.`,
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		// deployCmd binds deploy.services to its own flag, so rebind it only when lock runs
+		viper.BindPFlag("deploy.services", cmd.Flags().Lookup("service"))
+		initConfig()
+	},
+}
+
+var lockStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Shows who holds the deploy locks",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx, stop := commandContext()
+		defer stop()
+
+		locker, err := newDeployLocker(ctx)
+		if err != nil {
+			log.WithError(err).Error("Can't create the deploy lock")
+			os.Exit(lib.ExitCode(err))
+		}
+		if err := lib.DeployLockStatus(ctx, locker, viper.GetString("cluster"), viper.GetStringSlice("deploy.services")); err != nil {
+			os.Exit(lib.ExitCode(err))
+		}
+	},
+}
+
+var lockReleaseCmd = &cobra.Command{
+	Use:   "release",
+	Short: "Releases the deploy locks",
+	Long: `Releases the deploy locks regardless of who holds them.
+
+Use it when a deploy was killed before it could clean up after itself.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx, stop := commandContext()
+		defer stop()
+
+		locker, err := newDeployLocker(ctx)
+		if err != nil {
+			log.WithError(err).Error("Can't create the deploy lock")
+			os.Exit(lib.ExitCode(err))
+		}
+		if err := lib.ReleaseDeployLock(ctx, locker, viper.GetString("cluster"), viper.GetStringSlice("deploy.services")); err != nil {
+			os.Exit(lib.ExitCode(err))
+		}
+	},
+}
+
+func newDeployLocker(ctx context.Context) (lib.Locker, error) {
+	return lib.NewSSMLocker(ctx, viper.GetString("profile"), viper.GetString("deploy.lock.prefix"))
+}
+
+func init() {
+	rootCmd.AddCommand(lockCmd)
+	lockCmd.AddCommand(lockStatusCmd)
+	lockCmd.AddCommand(lockReleaseCmd)
+	lockCmd.PersistentFlags().StringSliceP("service", "s", []string{}, "Names of services to check. Defaults to deploy.services")
+
+	viper.SetDefault("deploy.lock.prefix", lib.DefaultLockPrefix)
+	viper.SetDefault("deploy.lock.owner", lib.DefaultLockOwner())
+}
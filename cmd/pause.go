@@ -0,0 +1,80 @@
+package cmd
+
+import (
+	"os"
+
+	"github.com/apex/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/springload/ecs-tool/lib"
+)
+
+// pauseCmd represents the pause command
+var pauseCmd = &cobra.Command{
+	Use:   "pause",
+	Short: "Scales services down to zero",
+	Long: `Scales the services down to zero tasks, i.e. to save costs on idle environments.
+
+The desired counts and Application Auto Scaling capacity are saved as service tags,
+so that "ecs-tool resume" can bring them back. Already paused services are skipped.
+Pauses the services from deploy.services unless --service is given.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx, stop := commandContext()
+		defer stop()
+
+		services := serviceList("pause.services")
+		if len(services) == 0 {
+			log.Error("Can't pause anything if no service is set")
+			os.Exit(1)
+		}
+		if err := lib.PauseServices(
+			ctx,
+			viper.GetString("profile"),
+			viper.GetString("cluster"),
+			services,
+		); err != nil {
+			log.WithError(err).Error("Can't pause")
+			os.Exit(lib.ExitCode(err))
+		}
+	},
+}
+
+// resumeCmd represents the resume command
+var resumeCmd = &cobra.Command{
+	Use:   "resume",
+	Short: "Brings paused services back",
+	Long: `Restores the desired counts and Application Auto Scaling capacity of services
+paused with "ecs-tool pause" and waits for them to become stable.
+
+Resumes the services from deploy.services unless --service is given.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx, stop := commandContext()
+		defer stop()
+
+		services := serviceList("resume.services")
+		if len(services) == 0 {
+			log.Error("Can't resume anything if no service is set")
+			os.Exit(1)
+		}
+		if err := lib.ResumeServices(
+			ctx,
+			viper.GetString("profile"),
+			viper.GetString("cluster"),
+			services,
+		); err != nil {
+			log.WithError(err).Error("Can't resume")
+			os.Exit(lib.ExitCode(err))
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(pauseCmd)
+	rootCmd.AddCommand(resumeCmd)
+	pauseCmd.PersistentFlags().StringSliceP("service", "s", []string{}, "Names of services to pause. Can be specified multiple times")
+	resumeCmd.PersistentFlags().StringSliceP("service", "s", []string{}, "Names of services to resume. Can be specified multiple times")
+	viper.BindPFlag("pause.services", pauseCmd.PersistentFlags().Lookup("service"))
+	viper.BindPFlag("resume.services", resumeCmd.PersistentFlags().Lookup("service"))
+}
@@ -0,0 +1,106 @@
+package cmd
+
+import (
+	"os"
+
+	"github.com/apex/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/springload/ecs-tool/lib"
+)
+
+// previewCmd represents the preview command
+var previewCmd = &cobra.Command{
+	Use:   "preview",
+	Short: "Manages ephemeral preview services",
+	Long:  `Creates and destroys short-lived copies of a service, i.e. one per pull request.`,
+}
+
+var previewCreateCmd = &cobra.Command{
+	Use:   "create",
+	Short: "Clones a service into a new preview service",
+	Long: `Clones the configuration of an existing service into a new service in the same cluster.
+
+The task definition is copied under a new family named after the preview, with the image tags
+replaced by --image_tag or --image_tags. Network configuration, capacity providers and placement
+are copied as is, load balancers and service discovery only if asked for.
+
+Usage:
+$ecs-tool preview create --from app --name app-pr-123 --image_tag pr-123`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx, stop := commandContext()
+		defer stop()
+
+		from := viper.GetString("preview.from")
+		name := viper.GetString("preview.name")
+		if from == "" || name == "" {
+			log.Error("Please set the service to clone with --from and the new service name with --name")
+			os.Exit(1)
+		}
+		if err := lib.CreatePreview(
+			ctx,
+			viper.GetString("profile"),
+			viper.GetString("cluster"),
+			from,
+			name,
+			viper.GetString("image_tag"),
+			viper.GetStringSlice("image_tags"),
+			viper.GetString("workdir"),
+			lib.PreviewOptions{
+				DesiredCount:     int32(viper.GetInt("preview.count")),
+				LoadBalancer:     viper.GetBool("preview.load_balancer"),
+				ServiceDiscovery: viper.GetBool("preview.service_discovery"),
+			},
+		); err != nil {
+			log.WithError(err).Error("Can't create the preview")
+			os.Exit(lib.ExitCode(err))
+		}
+	},
+}
+
+var previewDestroyCmd = &cobra.Command{
+	Use:   "destroy",
+	Short: "Deletes a preview service",
+	Long: `Scales the preview service down, deletes it and deregisters its task definitions.
+
+Only services created by "ecs-tool preview create" can be destroyed.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx, stop := commandContext()
+		defer stop()
+
+		name := viper.GetString("preview.name")
+		if name == "" {
+			log.Error("Please set the preview service name with --name")
+			os.Exit(1)
+		}
+		if err := lib.DestroyPreview(
+			ctx,
+			viper.GetString("profile"),
+			viper.GetString("cluster"),
+			name,
+		); err != nil {
+			log.WithError(err).Error("Can't destroy the preview")
+			os.Exit(lib.ExitCode(err))
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(previewCmd)
+	previewCmd.AddCommand(previewCreateCmd)
+	previewCmd.AddCommand(previewDestroyCmd)
+
+	previewCmd.PersistentFlags().StringP("name", "n", "", "Name of the preview service")
+	previewCreateCmd.PersistentFlags().StringP("from", "", "", "Name of the service to clone")
+	previewCreateCmd.PersistentFlags().Int64P("count", "", 1, "Desired number of tasks")
+	previewCreateCmd.PersistentFlags().BoolP("load_balancer", "", false, "Register the preview in the target groups of the original service")
+	previewCreateCmd.PersistentFlags().BoolP("service_discovery", "", false, "Register the preview in the service registries of the original service")
+
+	viper.BindPFlag("preview.name", previewCmd.PersistentFlags().Lookup("name"))
+	viper.BindPFlag("preview.from", previewCreateCmd.PersistentFlags().Lookup("from"))
+	viper.BindPFlag("preview.count", previewCreateCmd.PersistentFlags().Lookup("count"))
+	viper.BindPFlag("preview.load_balancer", previewCreateCmd.PersistentFlags().Lookup("load_balancer"))
+	viper.BindPFlag("preview.service_discovery", previewCreateCmd.PersistentFlags().Lookup("service_discovery"))
+}
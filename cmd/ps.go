@@ -0,0 +1,44 @@
+package cmd
+
+import (
+	"os"
+
+	"github.com/apex/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/springload/ecs-tool/lib"
+)
+
+// psCmd represents the ps command
+var psCmd = &cobra.Command{
+	Use:   "ps",
+	Short: "Lists tasks",
+	Long: `Lists running tasks in the cluster, optionally limited to one service.
+
+With --stopped it also lists recently stopped tasks with their exit codes and stop reasons,
+which helps with crash loops. Task IDs can be used with "exec --task_id".`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx, stop := commandContext()
+		defer stop()
+
+		if err := lib.PrintTasks(
+			ctx,
+			viper.GetString("profile"),
+			viper.GetString("cluster"),
+			viper.GetString("ps.service"),
+			viper.GetBool("ps.stopped"),
+		); err != nil {
+			log.WithError(err).Error("Can't list tasks")
+			os.Exit(lib.ExitCode(err))
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(psCmd)
+	psCmd.PersistentFlags().StringP("service", "s", "", "Name of the service to list tasks of")
+	psCmd.PersistentFlags().BoolP("stopped", "", false, "Also list recently stopped tasks")
+	viper.BindPFlag("ps.service", psCmd.PersistentFlags().Lookup("service"))
+	viper.BindPFlag("ps.stopped", psCmd.PersistentFlags().Lookup("stopped"))
+}
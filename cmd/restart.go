@@ -0,0 +1,46 @@
+package cmd
+
+import (
+	"os"
+
+	"github.com/apex/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/springload/ecs-tool/lib"
+)
+
+// restartCmd represents the restart command
+var restartCmd = &cobra.Command{
+	Use:   "restart",
+	Short: "Restarts services",
+	Long: `Forces a new deployment of the services with their current task definitions
+and waits for them to become stable.
+
+Restarts the services from deploy.services unless --service is given.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx, stop := commandContext()
+		defer stop()
+
+		services := serviceList("restart.services")
+		if len(services) == 0 {
+			log.Error("Can't restart anything if no service is set")
+			os.Exit(1)
+		}
+		if err := lib.RestartServices(
+			ctx,
+			viper.GetString("profile"),
+			viper.GetString("cluster"),
+			services,
+		); err != nil {
+			log.WithError(err).Error("Can't restart")
+			os.Exit(lib.ExitCode(err))
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(restartCmd)
+	restartCmd.PersistentFlags().StringSliceP("service", "s", []string{}, "Names of services to restart. Can be specified multiple times")
+	viper.BindPFlag("restart.services", restartCmd.PersistentFlags().Lookup("service"))
+}
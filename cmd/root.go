@@ -3,17 +3,21 @@ package cmd
 import (
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/apex/log"
 	"github.com/apex/log/handlers/text"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+	"github.com/springload/ecs-tool/lib"
 )
 
 var (
 	cfgFile,
 	environment string
 	debug bool
+	// skipConfig is set by the commands that don't read the config file, like init which writes it
+	skipConfig bool
 )
 
 // rootCmd represents the base command when called without any subcommands
@@ -25,6 +29,10 @@ back if needed.
 
 It allows running one-off commands and get the output instantly.
 `,
+	// cobra only runs the closest PersistentPreRun, so the commands with their own call initConfig too
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		initConfig()
+	},
 }
 
 // Execute adds all child commands to the root command and sets flags appropriately.
@@ -38,16 +46,28 @@ func Execute(version string) {
 }
 
 func init() {
-	cobra.OnInitialize(initConfig)
+	cobra.OnInitialize(initLogging)
 
 	// Here you will define your flags and configuration settings.
 	// Cobra supports persistent flags, which, if defined here,
 	// will be global for your application.
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file to use. Overrides -e/--environment lookup")
 	rootCmd.PersistentFlags().StringVarP(&environment, "environment", "e", "", "look up config based on the environment flag. It looks for ecs-$environment.toml config in infra folder.")
+	rootCmd.PersistentFlags().StringSliceP("config_paths", "", lib.DefaultConfigPaths, "directories to look for environment configs in, from the current directory up. Globs like services/*/infra are allowed")
+	rootCmd.PersistentFlags().StringSliceP("config_patterns", "", lib.DefaultConfigPatterns, "environment config file names, {env} is the environment")
 	rootCmd.PersistentFlags().BoolVarP(&debug, "debug", "d", false, "Show debug output")
+	rootCmd.PersistentFlags().StringP("output", "o", "text", "text or json, table is the same as text. With json the results of envs, run, runFargate and deploy, with the exit code and its reason, are printed as JSON")
 	rootCmd.PersistentFlags().StringP("cluster", "c", "", "name of cluster (required)")
 	rootCmd.PersistentFlags().StringP("profile", "p", "", "name of AWS profile to use, which is set in ~/.aws/config")
+	rootCmd.PersistentFlags().StringP("region", "", "", "AWS region, overrides the region of the profile")
+	rootCmd.PersistentFlags().StringP("role_arn", "", "", "IAM role to assume with the profile credentials")
+	rootCmd.PersistentFlags().StringP("external_id", "", "", "external ID to assume the role with")
+	rootCmd.PersistentFlags().StringP("mfa_serial", "", "", "MFA device to assume the role with, the code is asked for on the terminal")
+	rootCmd.PersistentFlags().StringP("session_name", "", lib.DefaultRoleSessionName, "session name of the assumed role")
+	rootCmd.PersistentFlags().DurationP("duration", "", time.Hour, "how long the assumed role credentials last")
+	rootCmd.PersistentFlags().BoolP("credentials_cache", "", true, "keep the assumed role credentials between runs, so that the MFA code isn't asked for every time")
+	rootCmd.PersistentFlags().IntP("max_attempts", "", lib.DefaultMaxAttempts, "how many times AWS calls are attempted, backing off when AWS throttles them")
+	rootCmd.PersistentFlags().StringP("endpoint_url", "", "", "AWS endpoint to use for all the services instead of AWS, i.e. http://localhost:4566 for LocalStack")
 	rootCmd.PersistentFlags().StringP("workdir", "w", "", "Set working directory")
 	rootCmd.PersistentFlags().StringP("image_tag", "", "", "Overrides the docker image tag in all container definitions. Overrides \"--image-tags\" flag.")
 	rootCmd.PersistentFlags().StringSliceP("image_tags", "", []string{}, "Modifies the docker image tags in container definitions. Can be specified several times, one for each container definition. Also takes comma-separated values in one tag. I.e. if there are 2 containers and --image-tags is set once to \"new\", then the image tag of the first container will be modified, leaving the second one untouched. Gets overridden by  \"--image-tag\". If you have 3 container definitions and want to modify tags for the 1st and the 3rd, but leave the 2nd unchanged, specify it as \"--image_tags first_tag,,last_tag\".")
@@ -55,7 +75,13 @@ func init() {
 
     
 
+	viper.BindPFlag("config_paths", rootCmd.PersistentFlags().Lookup("config_paths"))
+	viper.BindPFlag("config_patterns", rootCmd.PersistentFlags().Lookup("config_patterns"))
 	viper.BindPFlag("profile", rootCmd.PersistentFlags().Lookup("profile"))
+	viper.BindPFlag("output", rootCmd.PersistentFlags().Lookup("output"))
+	for _, name := range []string{"region", "role_arn", "external_id", "mfa_serial", "session_name", "duration", "credentials_cache", "max_attempts", "endpoint_url"} {
+		viper.BindPFlag(name, rootCmd.PersistentFlags().Lookup(name))
+	}
 	viper.BindPFlag("cluster", rootCmd.PersistentFlags().Lookup("cluster"))
 	viper.BindPFlag("workdir", rootCmd.PersistentFlags().Lookup("workdir"))
 	viper.BindPFlag("image_tag", rootCmd.PersistentFlags().Lookup("image_tag"))
@@ -65,27 +91,30 @@ func init() {
 
 }
 
-// initConfig reads in config file and ENV variables if set.
-func initConfig() {
+// initLogging sets up the logging and ENV variables
+func initLogging() {
 	log.SetHandler(text.New(os.Stderr))
 	if debug {
 		log.SetLevel(log.DebugLevel)
 	}
 	viper.SetEnvPrefix("ecs")
 	viper.AutomaticEnv() // read in environment variables that match
-	if cfgFile != "" || environment != "" {
+}
+
+// initConfig reads in config file unless skipConfig is set, and configures AWS and the notifications
+func initConfig() {
+	if !skipConfig && (cfgFile != "" || environment != "") {
 		// Use config file from the flag. cfgFile takes precedence over environment
-		if cfgFile != "" {
-			viper.SetConfigFile(cfgFile)
-		} else {
+		file := cfgFile
+		if file == "" {
 			if cfg, err := findConfigByEnvironment(environment); err != nil {
 				log.WithError(err).Fatal("Can't find the config")
 			} else {
-				viper.SetConfigFile(cfg)
+				file = cfg
 			}
 		}
-		// If a config file is found, read it in.
-		if err := viper.ReadInConfig(); err == nil {
+		// If a config file is found, read it in, merged over the base config and includes
+		if err := readConfig(file); err == nil {
 			log.Infof("Using config file: %s", viper.ConfigFileUsed())
 		} else {
 			log.WithError(err).Fatal("Had some errors while parsing the config")
@@ -93,4 +122,26 @@ func initConfig() {
 		}
 	}
 
+	awsConfig := lib.AWSConfig{
+		Region:      viper.GetString("region"),
+		RoleARN:     viper.GetString("role_arn"),
+		ExternalID:  viper.GetString("external_id"),
+		MFASerial:   viper.GetString("mfa_serial"),
+		SessionName: viper.GetString("session_name"),
+		Duration:    viper.GetDuration("duration"),
+		MaxAttempts: viper.GetInt("max_attempts"),
+		EndpointURL: viper.GetString("endpoint_url"),
+		Endpoints:   viper.GetStringMapString("endpoints"),
+	}
+	if viper.GetBool("credentials_cache") {
+		awsConfig.CacheDir = lib.DefaultCredentialsCacheDir()
+	}
+	lib.ConfigureAWS(awsConfig)
+
+	var notifiers []lib.Notifier
+	if err := viper.UnmarshalKey("notify", &notifiers); err != nil {
+		log.WithError(err).Fatal("Can't parse the notify config")
+	}
+	lib.ConfigureNotifications(notifiers)
+
 }
@@ -1,9 +1,6 @@
 package cmd
 
 import (
-	"os"
-
-	"github.com/apex/log"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 	"github.com/springload/ecs-tool/lib"
@@ -18,6 +15,9 @@ It can modify the container command.
 `,
 	Args: cobra.MinimumNArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
+		ctx, stop := commandContext()
+		defer stop()
+
 		viper.SetDefault("run.launch_type", "EC2")
 		var containerName string
 		var commandArgs []string
@@ -29,23 +29,20 @@ It can modify the container command.
 			commandArgs = args
 		}
 
-		exitCode, err := lib.RunTask(
-			viper.GetString("profile"),
-			viper.GetString("cluster"),
-			viper.GetString("run.service"),
-			viper.GetString("task_definition"),
-			viper.GetString("image_tag"),
-			viper.GetStringSlice("image_tags"),
-			viper.GetString("workdir"),
-			containerName,
-			viper.GetString("log_group"),
-			viper.GetString("run.launch_type"),
-			commandArgs,
-		)
-		if err != nil {
-			log.WithError(err).Error("Can't run task")
-		}
-		os.Exit(exitCode)
+		result, err := lib.RunTask(ctx, lib.RunOptions{
+			Profile:        viper.GetString("profile"),
+			Cluster:        viper.GetString("cluster"),
+			Service:        viper.GetString("run.service"),
+			TaskDefinition: viper.GetString("task_definition"),
+			ImageTag:       viper.GetString("image_tag"),
+			ImageTags:      viper.GetStringSlice("image_tags"),
+			WorkDir:        viper.GetString("workdir"),
+			ContainerName:  containerName,
+			LogGroup:       viper.GetString("log_group"),
+			LaunchType:     viper.GetString("run.launch_type"),
+			Command:        commandArgs,
+		})
+		exitRun(result, err, containerName)
 	},
 }
 
@@ -55,5 +52,7 @@ func init() {
 	runCmd.PersistentFlags().StringP("container_name", "", "", "Name of the container to modify parameters for")
 	viper.BindPFlag("log_group", runCmd.PersistentFlags().Lookup("log_group"))
 	viper.BindPFlag("container_name", runCmd.PersistentFlags().Lookup("container_name"))
+	runCmd.Flags().BoolP("container_exit_code", "", false, "exit with the exit code of the command instead of 4 when it fails, even if its output can't be fetched")
+	viper.BindPFlag("run.container_exit_code", runCmd.Flags().Lookup("container_exit_code"))
 	//viper.BindPFlag("task_definition", runCmd.PersistentFlags().Lookup("task_definition"))
 }
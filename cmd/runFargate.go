@@ -1,9 +1,6 @@
 package cmd
 
 import (
-    "os"
-
-    "github.com/apex/log"
     "github.com/spf13/cobra"
     "github.com/spf13/viper"
     "github.com/springload/ecs-tool/lib"
@@ -16,7 +13,15 @@ var runFargateCmd = &cobra.Command{
 
 This command is specifically tailored for future Fargate-specific functionality.`,
     Args: cobra.MinimumNArgs(1),
+    PersistentPreRun: func(cmd *cobra.Command, args []string) {
+        // runCmd binds run.container_exit_code to its own flag, so rebind it only when runFargate runs
+        viper.BindPFlag("run.container_exit_code", cmd.Flags().Lookup("container_exit_code"))
+        initConfig()
+    },
     Run: func(cmd *cobra.Command, args []string) {
+        ctx, stop := commandContext()
+        defer stop()
+
         viper.SetDefault("run.launch_type", "FARGATE")
         viper.SetDefault("run.security_group_filter", "ec2")
         var containerName string
@@ -29,27 +34,25 @@ This command is specifically tailored for future Fargate-specific functionality.
             commandArgs = args
         }
 
-        exitCode, err := lib.RunFargate(
-            viper.GetString("profile"),
-            viper.GetString("cluster"),
-            viper.GetString("run.service"),
-            viper.GetString("task_definition"),
-            viper.GetString("image_tag"),
-            viper.GetStringSlice("image_tags"),
-            viper.GetString("workdir"),
-            containerName,
-            viper.GetString("log_group"),
-            viper.GetString("run.launch_type"),
-            viper.GetString("run.security_group_filter"),
-            commandArgs,
-        )
-        if err != nil {
-            log.WithError(err).Error("Can't run task in Fargate mode")
-        }
-        os.Exit(exitCode)
+        result, err := lib.RunFargate(ctx, lib.RunOptions{
+            Profile:             viper.GetString("profile"),
+            Cluster:             viper.GetString("cluster"),
+            Service:             viper.GetString("run.service"),
+            TaskDefinition:      viper.GetString("task_definition"),
+            ImageTag:            viper.GetString("image_tag"),
+            ImageTags:           viper.GetStringSlice("image_tags"),
+            WorkDir:             viper.GetString("workdir"),
+            ContainerName:       containerName,
+            LogGroup:            viper.GetString("log_group"),
+            LaunchType:          viper.GetString("run.launch_type"),
+            SecurityGroupFilter: viper.GetString("run.security_group_filter"),
+            Command:             commandArgs,
+        })
+        exitRun(result, err, containerName)
     },
 }
 
 func init() {
     rootCmd.AddCommand(runFargateCmd)
+    runFargateCmd.Flags().BoolP("container_exit_code", "", false, "exit with the exit code of the command instead of 4 when it fails, even if its output can't be fetched")
 }
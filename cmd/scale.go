@@ -0,0 +1,52 @@
+package cmd
+
+import (
+	"os"
+
+	"github.com/apex/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/springload/ecs-tool/lib"
+)
+
+// scaleCmd represents the scale command
+var scaleCmd = &cobra.Command{
+	Use:   "scale",
+	Short: "Changes the number of tasks of services",
+	Long: `Sets the desired count of the services and waits for them to become stable.
+
+Scales the services from deploy.services unless --service is given.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx, stop := commandContext()
+		defer stop()
+
+		if !cmd.Flags().Changed("count") {
+			log.Error("Please set the number of tasks with --count")
+			os.Exit(1)
+		}
+		services := serviceList("scale.services")
+		if len(services) == 0 {
+			log.Error("Can't scale anything if no service is set")
+			os.Exit(1)
+		}
+		if err := lib.ScaleServices(
+			ctx,
+			viper.GetString("profile"),
+			viper.GetString("cluster"),
+			services,
+			int32(viper.GetInt("scale.count")),
+		); err != nil {
+			log.WithError(err).Error("Can't scale")
+			os.Exit(lib.ExitCode(err))
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(scaleCmd)
+	scaleCmd.PersistentFlags().StringSliceP("service", "s", []string{}, "Names of services to scale. Can be specified multiple times")
+	scaleCmd.PersistentFlags().Int64P("count", "", 0, "Desired number of tasks")
+	viper.BindPFlag("scale.services", scaleCmd.PersistentFlags().Lookup("service"))
+	viper.BindPFlag("scale.count", scaleCmd.PersistentFlags().Lookup("count"))
+}
@@ -0,0 +1,39 @@
+package cmd
+
+import (
+	"os"
+
+	"github.com/apex/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/springload/ecs-tool/lib"
+)
+
+// schedulesCmd represents the schedules command
+var schedulesCmd = &cobra.Command{
+	Use:   "schedules",
+	Short: "Lists scheduled tasks",
+	Long: `Lists EventBridge rules running tasks in the cluster with their schedules and task definition revisions.
+
+The rules are looked for on the event bus set in [deploy.scheduled] event_bus, the default one if it isn't set.
+Scheduled tasks listed in [deploy.scheduled] are updated by deploy to the newly registered revision.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx, stop := commandContext()
+		defer stop()
+
+		if err := lib.ListSchedules(
+			ctx,
+			viper.GetString("profile"),
+			viper.GetString("cluster"),
+			viper.GetString("deploy.scheduled.event_bus"),
+		); err != nil {
+			log.WithError(err).Error("Can't list scheduled tasks")
+			os.Exit(lib.ExitCode(err))
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(schedulesCmd)
+}
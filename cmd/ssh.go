@@ -12,28 +12,42 @@ import (
 var sshCmd = &cobra.Command{
 	Use:   "ssh",
 	Short: "Get a shell",
-	Long:  "Drops the user into a shell inside the application container",
+	Long: `Drops the user into a shell inside the application container.
+
+The container is picked with --service, --task_id and --container. If more than one matches,
+it's asked for on the terminal, showing the revision, age and health of the tasks. Without a terminal,
+i.e. in CI, the newest task is picked and the container defaults to the service name.`,
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		// execCmd binds task_id to its own flag, so rebind it only when ssh runs
+		viper.BindPFlag("task_id", cmd.Flags().Lookup("task_id"))
+		initConfig()
+	},
 	Run: func(cmd *cobra.Command, args []string) {
+		ctx, stop := commandContext()
+		defer stop()
+
 		containerName := viper.GetString("ssh.container_name")
 		service := viper.GetString("ssh.service")
-		if containerName == "" {
+		chooser := targetChooser()
+		if containerName == "" && chooser == nil {
 			containerName = service
 		}
 
-		exitCode, err := lib.ConnectSSH(
-			viper.GetString("profile"),
-			viper.GetString("cluster"),
-			viper.GetString("ssh.task_definition"),
-			containerName,
-			viper.GetString("ssh.shell"),
-			service,
-			viper.GetString("ssh.instance_user"),
-			viper.GetBool("ssh.push_ssh_key"),
-		)
-		if err != nil {
-			log.WithError(err).Error("Can't execute ssh")
-		}
-		os.Exit(exitCode)
+		err := lib.ConnectSSH(ctx, lib.SSHOptions{
+			Profile:        viper.GetString("profile"),
+			Cluster:        viper.GetString("cluster"),
+			TaskDefinition: viper.GetString("ssh.task_definition"),
+			TaskID:         viper.GetString("task_id"),
+			ContainerName:  containerName,
+			Shell:          viper.GetString("ssh.shell"),
+			Service:        service,
+			InstanceUser:   viper.GetString("ssh.instance_user"),
+			PushSSHKey:     viper.GetBool("ssh.push_ssh_key"),
+			Choose:         chooser,
+		})
+		// ssh replaces the process, so getting here means it couldn't be run
+		log.WithError(err).Error("Can't execute ssh")
+		os.Exit(lib.ExitCode(err))
 	},
 }
 
@@ -46,6 +60,12 @@ func init() {
 	// call panicked inside pflagValue.HasChanged.
 	viper.BindPFlag("ssh.task_definition", sshCmd.PersistentFlags().Lookup("task_definition"))
 
+	sshCmd.Flags().StringP("task_id", "", "", "ID of the task to connect to, or its prefix")
+	sshCmd.Flags().StringP("service", "s", "", "Service whose tasks to pick from")
+	viper.BindPFlag("ssh.service", sshCmd.Flags().Lookup("service"))
+	sshCmd.Flags().StringP("container", "", "", "Container to connect to")
+	viper.BindPFlag("ssh.container_name", sshCmd.Flags().Lookup("container"))
+
 	viper.SetDefault("ssh.push_ssh_key", true)
 	viper.SetDefault("ssh.task_definition", viper.GetString("task_definition"))
 }
@@ -0,0 +1,42 @@
+package cmd
+
+import (
+	"os"
+
+	"github.com/apex/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/springload/ecs-tool/lib"
+)
+
+// stopTaskCmd represents the stop-task command
+var stopTaskCmd = &cobra.Command{
+	Use:   "stop-task <task id>",
+	Short: "Stops a task",
+	Long: `Stops the task and waits until it's stopped.
+
+If the task belongs to a service, the service will start a replacement.
+Task IDs can be found with "ecs-tool ps".`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx, stop := commandContext()
+		defer stop()
+
+		if err := lib.StopTask(
+			ctx,
+			viper.GetString("profile"),
+			viper.GetString("cluster"),
+			args[0],
+			viper.GetString("stop_task.reason"),
+		); err != nil {
+			log.WithError(err).Error("Can't stop the task")
+			os.Exit(lib.ExitCode(err))
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(stopTaskCmd)
+	stopTaskCmd.PersistentFlags().StringP("reason", "", "Stopped by ecs-tool", "Reason to show in the stopped task")
+	viper.BindPFlag("stop_task.reason", stopTaskCmd.PersistentFlags().Lookup("reason"))
+}
@@ -0,0 +1,190 @@
+package cmd
+
+import (
+	"os"
+	"strings"
+
+	"github.com/apex/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/springload/ecs-tool/lib"
+)
+
+// taskdefCmd represents the taskdef command
+var taskdefCmd = &cobra.Command{
+	Use:   "taskdef",
+	Short: "Manages task definitions",
+}
+
+var taskdefRegisterCmd = &cobra.Command{
+	Use:   "register",
+	Short: "Registers a task definition rendered from a template",
+	Long: `Renders a Go template into a task definition and registers it.
+
+The template should render to the same JSON as "aws ecs register-task-definition --cli-input-json" takes.
+It can use:
+  {{ .Vars.name }}  variables from [taskdef.vars] in the config and --var flags,
+                    their names are lowercased like all config keys, so use {{ .Vars.db_host }}
+  {{ .Env.NAME }}   environment variables
+  {{ .GitSHA }}     the current git commit
+  {{ .ImageTag }}   the --image_tag flag
+  {{ .Cluster }}    the cluster name
+  {{ json .Vars.x }} JSON-quoted value
+Undefined variables are errors.
+
+With --deploy the services from deploy.services are updated to the new revision,
+the same way "ecs-tool deploy" does.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx, stop := commandContext()
+		defer stop()
+
+		file := viper.GetString("taskdef.file")
+		if file == "" {
+			log.Error("Please specify the template with --file or -f")
+			os.Exit(1)
+		}
+
+		taskDefinitionArn, err := lib.RegisterTaskDefinitionTemplate(
+			ctx,
+			viper.GetString("profile"),
+			file,
+			templateData(),
+			viper.GetStringSlice("deploy.tags"),
+			viper.GetBool("taskdef.dry_run"),
+		)
+		if err != nil {
+			log.WithError(err).Error("Can't register the task definition")
+			os.Exit(lib.ExitCode(err))
+		}
+		if viper.GetBool("taskdef.deploy") && taskDefinitionArn != "" {
+			runDeploy(taskDefinitionArn)
+		}
+	},
+}
+
+var taskdefExportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Prints the task definition as JSON that can be registered again",
+	Long: `Prints the task definition from --task_definition without the read-only fields
+like revision, status and registeredAt, ready for "aws ecs register-task-definition --cli-input-json".`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx, stop := commandContext()
+		defer stop()
+
+		err := lib.ExportTaskDefinition(
+			ctx,
+			viper.GetString("profile"),
+			viper.GetString("task_definition"),
+			viper.GetString("taskdef.revision"),
+		)
+		if err != nil {
+			log.WithError(err).Error("Can't export the task definition")
+			os.Exit(lib.ExitCode(err))
+		}
+	},
+}
+
+var taskdefDiffCmd = &cobra.Command{
+	Use:   "diff <from> <to>",
+	Short: "Shows the fields that differ between two task definition revisions",
+	Long: `Shows the fields that differ between two revisions of --task_definition, i.e.
+
+  ecs-tool taskdef diff 41 42
+
+Revisions can also be given as family:revision or full ARNs.`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx, stop := commandContext()
+		defer stop()
+
+		err := lib.DiffTaskDefinitions(
+			ctx,
+			viper.GetString("profile"),
+			viper.GetString("task_definition"),
+			args[0],
+			args[1],
+		)
+		if err != nil {
+			log.WithError(err).Error("Can't compare the task definitions")
+			os.Exit(lib.ExitCode(err))
+		}
+	},
+}
+
+var taskdefLintCmd = &cobra.Command{
+	Use:   "lint",
+	Short: "Checks the task definition for common mistakes",
+	Long: `Checks the task definition from --task_definition, or the template given with --file, for
+mutable latest image tags, missing health checks, missing memory limits,
+secrets in plain environment values and missing log configuration.
+
+Exits with 1 if anything is found.`,
+	Args: cobra.NoArgs,
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		// the same keys are bound to the register flags
+		viper.BindPFlag("taskdef.file", cmd.Flags().Lookup("file"))
+		viper.BindPFlag("taskdef.var", cmd.Flags().Lookup("var"))
+		initConfig()
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx, stop := commandContext()
+		defer stop()
+
+		file := viper.GetString("taskdef.file")
+		var data lib.TaskDefinitionTemplateData
+		if file != "" {
+			data = templateData()
+		}
+		findings, err := lib.LintTaskDefinition(
+			ctx,
+			viper.GetString("profile"),
+			viper.GetString("task_definition"),
+			viper.GetString("taskdef.revision"),
+			file,
+			data,
+		)
+		if err != nil {
+			log.WithError(err).Error("Can't lint the task definition")
+			os.Exit(lib.ExitCode(err))
+		}
+		if findings > 0 {
+			os.Exit(1)
+		}
+	},
+}
+
+// templateData collects the template variables from the config and --var flags
+func templateData() lib.TaskDefinitionTemplateData {
+	vars := viper.GetStringMapString("taskdef.vars")
+	for _, kv := range viper.GetStringSlice("taskdef.var") {
+		pair := strings.SplitN(kv, "=", 2)
+		if len(pair) != 2 {
+			log.Errorf("Variable %q should be in name=value format", kv)
+			os.Exit(1)
+		}
+		// the config keys are lowercased, so are the flags to override them
+		vars[strings.ToLower(pair[0])] = pair[1]
+	}
+	return lib.NewTaskDefinitionTemplateData(vars, viper.GetString("image_tag"), viper.GetString("cluster"))
+}
+
+func init() {
+	rootCmd.AddCommand(taskdefCmd)
+	taskdefCmd.AddCommand(taskdefRegisterCmd)
+	taskdefRegisterCmd.PersistentFlags().StringP("file", "f", "", "task definition template to render")
+	taskdefRegisterCmd.PersistentFlags().StringSliceP("var", "", []string{}, "template variable as name=value, the name is lowercased. Can be specified multiple times")
+	taskdefRegisterCmd.PersistentFlags().BoolP("deploy", "", false, "deploy the registered task definition to deploy.services")
+	taskdefRegisterCmd.PersistentFlags().BoolP("dry_run", "", false, "print the rendered task definition without registering it")
+	viper.BindPFlag("taskdef.file", taskdefRegisterCmd.PersistentFlags().Lookup("file"))
+	viper.BindPFlag("taskdef.var", taskdefRegisterCmd.PersistentFlags().Lookup("var"))
+	viper.BindPFlag("taskdef.deploy", taskdefRegisterCmd.PersistentFlags().Lookup("deploy"))
+	viper.BindPFlag("taskdef.dry_run", taskdefRegisterCmd.PersistentFlags().Lookup("dry_run"))
+
+	taskdefCmd.AddCommand(taskdefExportCmd, taskdefDiffCmd, taskdefLintCmd)
+	taskdefCmd.PersistentFlags().StringP("revision", "", "", "task definition revision, latest active one by default")
+	viper.BindPFlag("taskdef.revision", taskdefCmd.PersistentFlags().Lookup("revision"))
+	taskdefLintCmd.Flags().StringP("file", "f", "", "lint the rendered template instead of the live task definition")
+	taskdefLintCmd.Flags().StringSliceP("var", "", []string{}, "template variable as name=value, the name is lowercased. Can be specified multiple times")
+}
@@ -1,79 +1,146 @@
 package cmd
 
 import (
+	"bufio"
+	"context"
 	"fmt"
 	"os"
-	"path"
-	"path/filepath"
-	"regexp"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"unicode/utf8"
 
 	"github.com/apex/log"
+	"github.com/spf13/viper"
+	"github.com/springload/ecs-tool/lib"
+	"golang.org/x/term"
 )
 
-const (
-	globPattern  = "ecs-*.toml"
-	configFormat = "ecs-%s.toml"
-)
+// configPatterns parses the config file name patterns
+func configPatterns() []lib.ConfigPattern {
+	patterns, err := lib.ConfigPatterns(viper.GetStringSlice("config_patterns"))
+	if err != nil {
+		log.WithError(err).Fatal("Can't parse the config patterns")
+	}
+	return patterns
+}
 
-var (
-	rePattern = regexp.MustCompile(`^ecs-(\w+)\.toml$`)
-)
+func findEnvironments() ([]lib.Environment, error) {
+	dir, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	return lib.FindEnvironments(dir, viper.GetStringSlice("config_paths"), configPatterns())
+}
 
-func findInfraDir() (string, error) {
-	var infra string
+func findConfigByEnvironment(environment string) (string, error) {
 	dir, err := os.Getwd()
 	if err != nil {
 		return "", err
 	}
-	for dir != path.Dir(dir) {
-		infra = path.Join(dir, "infra")
-		if stat, err := os.Stat(infra); err == nil {
-			if stat.IsDir() { // directory infra found
-				if files, _ := filepath.Glob(path.Join(infra, globPattern)); len(files) > 0 {
-					return infra, nil
-				}
-			}
-		}
-		dir = path.Dir(dir)
+	filename, err := lib.FindEnvironment(dir, viper.GetStringSlice("config_paths"), configPatterns(), environment)
+	if err != nil {
+		return "", err
 	}
+	log.WithFields(log.Fields{
+		"config": filename,
+	}).Debug("Found config!")
+	return filename, nil
+}
 
-	return "", fmt.Errorf("Can't find directory with config files")
+// serviceList returns the services set for the command, falling back to deploy.services
+func serviceList(key string) []string {
+	if services := viper.GetStringSlice(key); len(services) > 0 {
+		return services
+	}
+	return viper.GetStringSlice("deploy.services")
+}
 
+// commandContext is cancelled on Ctrl-C, so that the AWS calls and waiters stop instead of hanging on
+func commandContext() (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 }
 
-func findEnvironments() ([]string, error) {
-	var envs []string
-	infra, err := findInfraDir()
-	if err != nil {
-		return []string{}, err
-	}
-	if files, _ := filepath.Glob(path.Join(infra, globPattern)); len(files) > 0 {
-		for _, fp := range files {
-			file := filepath.Base(fp)
-			match := rePattern.FindStringSubmatch(file)
-			if match != nil {
-				envs = append(envs, match[1])
+var stdin = bufio.NewReader(os.Stdin)
+
+// confirm asks a yes/no question on the terminal, no is the default
+func confirm(question string) bool {
+	fmt.Fprintf(os.Stderr, "%s [y/N] ", question)
+	answer, _ := stdin.ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+// choose asks to pick one of the options on the terminal, the first one is the default.
+// The options can be picked by their number, or narrowed down by typing a part of them
+func choose(question string, options []string) string {
+	for {
+		fmt.Fprintln(os.Stderr, question)
+		for n, option := range options {
+			fmt.Fprintf(os.Stderr, "  %d) %s\n", n+1, option)
+		}
+		fmt.Fprintf(os.Stderr, "[1] ")
+		answer, err := stdin.ReadString('\n')
+		answer = strings.TrimSpace(answer)
+		if answer == "" {
+			return options[0]
+		}
+		if n, err := strconv.Atoi(answer); err == nil && n >= 1 && n <= len(options) {
+			return options[n-1]
+		}
+		var matching []string
+		for _, option := range options {
+			if option == answer {
+				return option
 			}
+			if fuzzyMatch(option, answer) {
+				matching = append(matching, option)
+			}
+		}
+		if len(matching) == 1 {
+			return matching[0]
+		}
+		if err != nil {
+			// no terminal to ask again
+			return options[0]
+		}
+		if len(matching) > 1 {
+			options = matching
+		}
+	}
+}
+
+// fuzzyMatch tells if the letters of the pattern are in the text in the same order, ignoring the case
+func fuzzyMatch(text, pattern string) bool {
+	text, pattern = strings.ToLower(text), strings.ToLower(pattern)
+	for _, r := range pattern {
+		n := strings.IndexRune(text, r)
+		if n < 0 {
+			return false
 		}
-		return envs, nil
+		text = text[n+utf8.RuneLen(r):]
 	}
+	return true
+}
 
-	return []string{}, fmt.Errorf("can't find any environment")
+// interactive tells if there is somebody at the terminal to ask, which isn't the case in CI
+func interactive() bool {
+	return os.Getenv("CI") == "" && term.IsTerminal(int(os.Stdin.Fd())) && term.IsTerminal(int(os.Stderr.Fd()))
 }
 
-func findConfigByEnvironment(environment string) (string, error) {
-	var filename string
-	infra, err := findInfraDir()
-	if err != nil {
-		return "", err
+// targetChooser asks on the terminal which task or container to connect to, it's nil if there is nobody to ask
+func targetChooser() lib.Chooser {
+	if !interactive() {
+		return nil
 	}
-	filename = path.Join(infra, fmt.Sprintf(configFormat, environment))
-	if _, err := os.Stat(filename); err == nil {
-		log.WithFields(log.Fields{
-			"config": filename,
-		}).Debug("Found config!")
-		return filename, nil
+	return func(question string, options []string) (int, error) {
+		picked := choose(question, options)
+		for n, option := range options {
+			if option == picked {
+				return n, nil
+			}
+		}
+		return 0, fmt.Errorf("unknown option %q", picked)
 	}
-
-	return "", fmt.Errorf("'infra/ecs-%s.toml' doesn't exist", environment)
 }
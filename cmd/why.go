@@ -0,0 +1,43 @@
+package cmd
+
+import (
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/springload/ecs-tool/lib"
+)
+
+// whyCmd represents the why command
+var whyCmd = &cobra.Command{
+	Use:   "why",
+	Short: "Explains why tasks are failing",
+	Long: `Looks at the recently stopped tasks of the services, groups them by stop reason,
+container exit codes and health status and prints the last log lines of the failing containers.
+
+Checks the services from deploy.services unless --service is given. The same diagnosis
+is printed automatically when a deploy fails.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx, stop := commandContext()
+		defer stop()
+
+		if err := lib.Diagnose(
+			ctx,
+			viper.GetString("profile"),
+			viper.GetString("cluster"),
+			serviceList("why.services"),
+			viper.GetInt64("why.log_lines"),
+		); err != nil {
+			os.Exit(lib.ExitCode(err))
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(whyCmd)
+	whyCmd.PersistentFlags().StringSliceP("service", "s", []string{}, "Names of services to check. Can be specified multiple times")
+	whyCmd.PersistentFlags().Int64P("lines", "n", lib.DefaultDiagnosisLogLines, "Number of log lines to show for each failing container")
+	viper.BindPFlag("why.services", whyCmd.PersistentFlags().Lookup("service"))
+	viper.BindPFlag("why.log_lines", whyCmd.PersistentFlags().Lookup("lines"))
+}
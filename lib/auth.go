@@ -0,0 +1,248 @@
+package lib
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+// DefaultRoleSessionName is used when assuming roles without a session name
+const DefaultRoleSessionName = "ecs-tool"
+
+// credentialsExpiryWindow is how long before the expiration cached credentials are refreshed
+const credentialsExpiryWindow = 5 * time.Minute
+
+// AWSConfig is how the clients authenticate on top of the profile
+type AWSConfig struct {
+	Region string
+	// RoleARN is assumed with the profile credentials
+	RoleARN     string
+	ExternalID  string
+	MFASerial   string
+	SessionName string
+	Duration    time.Duration
+	// CacheDir keeps the assumed role credentials between runs, they aren't cached if it's empty
+	CacheDir string
+	// EndpointURL replaces the AWS endpoints of all the services, i.e. with LocalStack
+	EndpointURL string
+	// Endpoints replace the endpoints of single services, by their names in EndpointServices
+	Endpoints map[string]string
+	// MaxAttempts of every call, DefaultMaxAttempts if it's 0
+	MaxAttempts int
+}
+
+// EndpointServices are the services whose endpoints can be set one by one, by the name used in the config
+var EndpointServices = map[string]string{
+	"ecs":  ecs.ServiceID,
+	"ec2":  ec2.ServiceID,
+	"logs": cloudwatchlogs.ServiceID,
+	"ssm":  ssm.ServiceID,
+	"ecr":  ecr.ServiceID,
+	"sts":  sts.ServiceID,
+}
+
+var awsConfig AWSConfig
+
+// ConfigureAWS sets how the clients authenticate
+func ConfigureAWS(cfg AWSConfig) {
+	awsConfig = cfg
+}
+
+// DefaultCredentialsCacheDir is where the assumed role credentials are kept
+func DefaultCredentialsCacheDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "ecs-tool", "credentials")
+}
+
+// mfaTokenProvider asks for the MFA code on the terminal
+func mfaTokenProvider() (string, error) {
+	fmt.Fprintf(os.Stderr, "MFA code for %s: ", awsConfig.MFASerial)
+	code, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && code == "" {
+		return "", fmt.Errorf("can't read the MFA code: %w", err)
+	}
+	return strings.TrimSpace(code), nil
+}
+
+var (
+	localConfig       aws.Config
+	localConfigLoaded bool
+)
+
+// makeConfig loads the SDK config of the profile once, all the clients are made from localConfig
+func makeConfig(ctx context.Context, profile string) error {
+	if localConfigLoaded {
+		return nil
+	}
+	log.Debug("Loading AWS config")
+	cfg, err := newConfig(ctx, profile)
+	if err != nil {
+		return fmt.Errorf("can't load aws config: %w", err)
+	}
+	localConfig = cfg
+	localConfigLoaded = true
+	return nil
+}
+
+// newConfig loads the SDK config for the profile, assuming the configured role if any
+func newConfig(ctx context.Context, profile string) (aws.Config, error) {
+	options := []func(*config.LoadOptions) error{
+		config.WithSharedConfigProfile(profile),
+		// used by the profiles with mfa_serial in ~/.aws/config too
+		config.WithAssumeRoleCredentialOptions(func(o *stscreds.AssumeRoleOptions) {
+			o.TokenProvider = mfaTokenProvider
+		}),
+	}
+	if awsConfig.Region != "" {
+		options = append(options, config.WithRegion(awsConfig.Region))
+	}
+	retryer := newRetryer(awsConfig.MaxAttempts)
+	options = append(options, config.WithRetryer(func() aws.Retryer { return retryer }))
+	cfg, err := config.LoadDefaultConfig(ctx, options...)
+	if err != nil {
+		return cfg, err
+	}
+	if awsConfig.EndpointURL != "" {
+		cfg.BaseEndpoint = aws.String(awsConfig.EndpointURL)
+	}
+	if len(awsConfig.Endpoints) > 0 {
+		endpoints, err := newServiceEndpoints(awsConfig.Endpoints)
+		if err != nil {
+			return cfg, err
+		}
+		// the clients look the service endpoints up in the config sources, the first one wins
+		cfg.ConfigSources = append([]interface{}{endpoints}, cfg.ConfigSources...)
+	}
+	if awsConfig.RoleARN == "" {
+		return cfg, nil
+	}
+
+	var provider aws.CredentialsProvider = stscreds.NewAssumeRoleProvider(sts.NewFromConfig(cfg), awsConfig.RoleARN, func(o *stscreds.AssumeRoleOptions) {
+		o.RoleSessionName = awsConfig.SessionName
+		if o.RoleSessionName == "" {
+			o.RoleSessionName = DefaultRoleSessionName
+		}
+		o.Duration = awsConfig.Duration
+		if awsConfig.ExternalID != "" {
+			o.ExternalID = aws.String(awsConfig.ExternalID)
+		}
+		if awsConfig.MFASerial != "" {
+			o.SerialNumber = aws.String(awsConfig.MFASerial)
+			o.TokenProvider = mfaTokenProvider
+		}
+	})
+	if awsConfig.CacheDir != "" {
+		provider = &cachedCredentialsProvider{
+			provider: provider,
+			file:     filepath.Join(awsConfig.CacheDir, credentialsCacheKey(profile, awsConfig)+".json"),
+		}
+	}
+	cfg.Credentials = aws.NewCredentialsCache(provider, func(o *aws.CredentialsCacheOptions) {
+		o.ExpiryWindow = credentialsExpiryWindow
+	})
+	return cfg, nil
+}
+
+// serviceEndpoints are the configured endpoints by the service ID of the SDK
+type serviceEndpoints map[string]string
+
+func newServiceEndpoints(endpoints map[string]string) (serviceEndpoints, error) {
+	byID := make(serviceEndpoints)
+	for name, url := range endpoints {
+		id, ok := EndpointServices[strings.ToLower(name)]
+		if !ok {
+			return nil, fmt.Errorf("can't set the endpoint of %s, only the ones of %s can be set", name, strings.Join(endpointServiceNames(), ", "))
+		}
+		byID[id] = url
+	}
+	return byID, nil
+}
+
+func endpointServiceNames() []string {
+	var names []string
+	for name := range EndpointServices {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// GetServiceBaseEndpoint is how the SDK clients get the endpoints from the config sources
+func (e serviceEndpoints) GetServiceBaseEndpoint(ctx context.Context, sdkID string) (string, bool, error) {
+	url, ok := e[sdkID]
+	return url, ok && url != "", nil
+}
+
+// credentialsCacheKey identifies the credentials of the role assumed from the profile
+func credentialsCacheKey(profile string, cfg AWSConfig) string {
+	hash := sha1.Sum([]byte(strings.Join([]string{profile, cfg.RoleARN, cfg.ExternalID, cfg.MFASerial, cfg.SessionName}, "\n")))
+	return hex.EncodeToString(hash[:])
+}
+
+type cachedCredentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	Expiration      time.Time
+}
+
+// cachedCredentialsProvider keeps the credentials in a file, so that the role isn't assumed
+// and the MFA code isn't asked for on every run
+type cachedCredentialsProvider struct {
+	provider aws.CredentialsProvider
+	file     string
+}
+
+func (p *cachedCredentialsProvider) Retrieve(ctx context.Context) (aws.Credentials, error) {
+	if content, err := os.ReadFile(p.file); err == nil {
+		var cached cachedCredentials
+		if json.Unmarshal(content, &cached) == nil && time.Now().Add(credentialsExpiryWindow).Before(cached.Expiration) {
+			return aws.Credentials{
+				AccessKeyID:     cached.AccessKeyID,
+				SecretAccessKey: cached.SecretAccessKey,
+				SessionToken:    cached.SessionToken,
+				Source:          stscreds.ProviderName,
+				CanExpire:       true,
+				Expires:         cached.Expiration,
+			}, nil
+		}
+	}
+
+	creds, err := p.provider.Retrieve(ctx)
+	if err != nil {
+		return creds, err
+	}
+	content, err := json.Marshal(cachedCredentials{
+		AccessKeyID:     creds.AccessKeyID,
+		SecretAccessKey: creds.SecretAccessKey,
+		SessionToken:    creds.SessionToken,
+		Expiration:      creds.Expires,
+	})
+	if err == nil && os.MkdirAll(filepath.Dir(p.file), 0700) == nil {
+		// the credentials are usable without the cache, so it's fine if it can't be written
+		os.WriteFile(p.file, content, 0600)
+	}
+	return creds, nil
+}
@@ -0,0 +1,116 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/aws/aws-sdk-go-v2/service/sts/types"
+)
+
+type fakeAssumeRoler struct {
+	calls int
+}
+
+func (f *fakeAssumeRoler) AssumeRole(ctx context.Context, input *sts.AssumeRoleInput, optFns ...func(*sts.Options)) (*sts.AssumeRoleOutput, error) {
+	f.calls++
+	return &sts.AssumeRoleOutput{Credentials: &types.Credentials{
+		AccessKeyId:     aws.String("AKID"),
+		SecretAccessKey: aws.String("secret"),
+		SessionToken:    aws.String("token"),
+		Expiration:      aws.Time(time.Now().Add(time.Hour)),
+	}}, nil
+}
+
+func TestCachedCredentialsProvider(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "credentials", "role.json")
+	client := &fakeAssumeRoler{}
+	newProvider := func() *cachedCredentialsProvider {
+		return &cachedCredentialsProvider{
+			provider: stscreds.NewAssumeRoleProvider(client, "arn:aws:iam::1:role/deploy"),
+			file:     file,
+		}
+	}
+
+	value, err := newProvider().Retrieve(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value.AccessKeyID != "AKID" || value.Expired() {
+		t.Fatalf("unexpected %+v", value)
+	}
+
+	// the next run reads the cache instead of assuming the role again
+	value, err = newProvider().Retrieve(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client.calls != 1 || value.SessionToken != "token" {
+		t.Fatalf("expected the cached credentials, got %+v after %d calls", value, client.calls)
+	}
+}
+
+func TestCredentialsCacheKey(t *testing.T) {
+	cfg := AWSConfig{RoleARN: "arn:aws:iam::1:role/deploy"}
+	other := AWSConfig{RoleARN: "arn:aws:iam::2:role/deploy"}
+	if credentialsCacheKey("prod", cfg) == credentialsCacheKey("prod", other) {
+		t.Fatal("different roles should be cached separately")
+	}
+	if credentialsCacheKey("prod", cfg) == credentialsCacheKey("dev", cfg) {
+		t.Fatal("different profiles should be cached separately")
+	}
+}
+
+// fakeAWS answers the JSON API calls with an empty result and remembers which ones it got
+func fakeAWS(t *testing.T, targets *[]string) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*targets = append(*targets, r.Header.Get("X-Amz-Target"))
+		w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+		fmt.Fprint(w, "{}")
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestNewConfigEndpoints(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(dir, "config"))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(dir, "credentials"))
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKID")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	var global, ecsOnly []string
+	defer ConfigureAWS(awsConfig)
+	ConfigureAWS(AWSConfig{
+		Region:      "us-east-1",
+		EndpointURL: fakeAWS(t, &global).URL,
+		Endpoints:   map[string]string{"ECS": fakeAWS(t, &ecsOnly).URL},
+	})
+
+	cfg, err := newConfig(context.Background(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ecs.NewFromConfig(cfg).ListClusters(context.Background(), &ecs.ListClustersInput{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cloudwatchlogs.NewFromConfig(cfg).DescribeLogGroups(context.Background(), &cloudwatchlogs.DescribeLogGroupsInput{}); err != nil {
+		t.Fatal(err)
+	}
+	if len(ecsOnly) != 1 || len(global) != 1 || global[0] != "Logs_20140328.DescribeLogGroups" {
+		t.Fatalf("ecs should use its own endpoint and logs the global one, got %v and %v", ecsOnly, global)
+	}
+
+	awsConfig.Endpoints = map[string]string{"s3": "http://localhost"}
+	if _, err := newConfig(context.Background(), ""); err == nil {
+		t.Fatal("expected an error for a service whose endpoint can't be set")
+	}
+}
@@ -0,0 +1,422 @@
+package lib
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/apex/log"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"gopkg.in/yaml.v2"
+)
+
+type composeFile struct {
+	Services map[string]*composeService `yaml:"services"`
+	Volumes  map[string]composeVolume   `yaml:"volumes,omitempty"`
+}
+
+type composeVolume struct {
+	Driver     string            `yaml:"driver,omitempty"`
+	DriverOpts map[string]string `yaml:"driver_opts,omitempty"`
+	Labels     map[string]string `yaml:"labels,omitempty"`
+}
+
+type composeService struct {
+	Image       string                       `yaml:"image"`
+	Entrypoint  []string                     `yaml:"entrypoint,omitempty"`
+	Command     []string                     `yaml:"command,omitempty"`
+	WorkingDir  string                       `yaml:"working_dir,omitempty"`
+	User        string                       `yaml:"user,omitempty"`
+	Hostname    string                       `yaml:"hostname,omitempty"`
+	Environment map[string]string            `yaml:"environment,omitempty"`
+	Ports       []string                     `yaml:"ports,omitempty"`
+	DependsOn   map[string]composeDependency `yaml:"depends_on,omitempty"`
+	Volumes     []string                     `yaml:"volumes,omitempty"`
+	VolumesFrom []string                     `yaml:"volumes_from,omitempty"`
+	Healthcheck *composeHealthcheck          `yaml:"healthcheck,omitempty"`
+	Labels      map[string]string            `yaml:"labels,omitempty"`
+	Sysctls     map[string]string            `yaml:"sysctls,omitempty"`
+	Init        *bool                        `yaml:"init,omitempty"`
+	Privileged  bool                         `yaml:"privileged,omitempty"`
+	ReadOnly    bool                         `yaml:"read_only,omitempty"`
+	TTY         bool                         `yaml:"tty,omitempty"`
+	StdinOpen   bool                         `yaml:"stdin_open,omitempty"`
+}
+
+type composeDependency struct {
+	Condition string `yaml:"condition"`
+}
+
+type composeHealthcheck struct {
+	Test        []string `yaml:"test"`
+	Interval    string   `yaml:"interval,omitempty"`
+	Timeout     string   `yaml:"timeout,omitempty"`
+	Retries     int32    `yaml:"retries,omitempty"`
+	StartPeriod string   `yaml:"start_period,omitempty"`
+}
+
+// composeConditions maps ECS container dependency conditions to compose ones
+var composeConditions = map[types.ContainerCondition]string{
+	types.ContainerConditionStart:    "service_started",
+	types.ContainerConditionHealthy:  "service_healthy",
+	types.ContainerConditionComplete: "service_completed_successfully",
+	types.ContainerConditionSuccess:  "service_completed_successfully",
+}
+
+// composeEscape escapes the dollar signs, otherwise compose would interpolate them
+func composeEscape(value string) string {
+	return strings.ReplaceAll(value, "$", "$$")
+}
+
+func composeEscapeAll(values []string) []string {
+	var result []string
+	for _, value := range values {
+		result = append(result, composeEscape(value))
+	}
+	return result
+}
+
+func composeSeconds(seconds *int32) string {
+	if seconds == nil {
+		return ""
+	}
+	return fmt.Sprintf("%ds", aws.ToInt32(seconds))
+}
+
+// composeFromTaskDefinition translates the task definition into a compose file.
+// Secrets are referenced as ${NAME} and returned as name => valueFrom, so that they can be put into the .env file.
+// notes lists the settings that have no compose equivalent
+func composeFromTaskDefinition(taskDefinition *types.TaskDefinition) (compose composeFile, secrets map[string]string, notes []string, err error) {
+	compose.Services = make(map[string]*composeService)
+	secrets = make(map[string]string)
+	note := func(format string, args ...interface{}) {
+		notes = append(notes, fmt.Sprintf(format, args...))
+	}
+
+	volumes := make(map[string]types.Volume)
+	for _, volume := range taskDefinition.Volumes {
+		name := aws.ToString(volume.Name)
+		volumes[name] = volume
+		switch {
+		case volume.Host != nil && volume.Host.SourcePath != nil:
+			// bind mounted, see the mount points
+		case volume.EfsVolumeConfiguration != nil:
+			note("volume %s: EFS isn't available locally, using a local volume", name)
+			compose.addVolume(name, composeVolume{})
+		case volume.FsxWindowsFileServerVolumeConfiguration != nil:
+			note("volume %s: FSx isn't available locally, using a local volume", name)
+			compose.addVolume(name, composeVolume{})
+		case volume.DockerVolumeConfiguration != nil:
+			config := volume.DockerVolumeConfiguration
+			compose.addVolume(name, composeVolume{
+				Driver:     aws.ToString(config.Driver),
+				DriverOpts: config.DriverOpts,
+				Labels:     config.Labels,
+			})
+		default:
+			compose.addVolume(name, composeVolume{})
+		}
+	}
+
+	if taskDefinition.ProxyConfiguration != nil {
+		note("proxy configuration isn't supported")
+	}
+	if len(taskDefinition.PlacementConstraints) > 0 {
+		note("placement constraints are ignored")
+	}
+
+	for _, container := range taskDefinition.ContainerDefinitions {
+		name := aws.ToString(container.Name)
+		note := func(format string, args ...interface{}) {
+			note("container %s: %s", name, fmt.Sprintf(format, args...))
+		}
+		service := &composeService{
+			Image:      aws.ToString(container.Image),
+			Entrypoint: composeEscapeAll(container.EntryPoint),
+			Command:    composeEscapeAll(container.Command),
+			WorkingDir: aws.ToString(container.WorkingDirectory),
+			User:       aws.ToString(container.User),
+			Hostname:   aws.ToString(container.Hostname),
+			Labels:     container.DockerLabels,
+			Privileged: aws.ToBool(container.Privileged),
+			ReadOnly:   aws.ToBool(container.ReadonlyRootFilesystem),
+			TTY:        aws.ToBool(container.PseudoTerminal),
+			StdinOpen:  aws.ToBool(container.Interactive),
+		}
+
+		if len(container.Environment) > 0 || len(container.Secrets) > 0 {
+			service.Environment = make(map[string]string)
+		}
+		for _, env := range container.Environment {
+			service.Environment[aws.ToString(env.Name)] = composeEscape(aws.ToString(env.Value))
+		}
+		for _, secret := range container.Secrets {
+			secretName := aws.ToString(secret.Name)
+			valueFrom := aws.ToString(secret.ValueFrom)
+			if existing, ok := secrets[secretName]; ok && existing != valueFrom {
+				return compose, nil, nil, fmt.Errorf("secret %s comes from both %s and %s, it can't be put into one .env file", secretName, existing, valueFrom)
+			}
+			secrets[secretName] = valueFrom
+			service.Environment[secretName] = fmt.Sprintf("${%s}", secretName)
+		}
+		if len(container.EnvironmentFiles) > 0 {
+			note("environment files from S3 aren't supported")
+		}
+
+		for _, mapping := range container.PortMappings {
+			port := fmt.Sprintf("%d", aws.ToInt32(mapping.ContainerPort))
+			if hostPort := aws.ToInt32(mapping.HostPort); hostPort != 0 {
+				port = fmt.Sprintf("%d:%s", hostPort, port)
+			} else {
+				port = fmt.Sprintf("%s:%s", port, port)
+			}
+			if protocol := mapping.Protocol; protocol != "" && protocol != types.TransportProtocolTcp {
+				port = fmt.Sprintf("%s/%s", port, protocol)
+			}
+			service.Ports = append(service.Ports, port)
+		}
+
+		for _, dependency := range container.DependsOn {
+			if service.DependsOn == nil {
+				service.DependsOn = make(map[string]composeDependency)
+			}
+			service.DependsOn[aws.ToString(dependency.ContainerName)] = composeDependency{
+				Condition: composeConditions[dependency.Condition],
+			}
+		}
+		for _, link := range container.Links {
+			// links are name:alias, the dependency is what matters locally
+			linked := strings.SplitN(link, ":", 2)[0]
+			if _, ok := service.DependsOn[linked]; !ok {
+				if service.DependsOn == nil {
+					service.DependsOn = make(map[string]composeDependency)
+				}
+				service.DependsOn[linked] = composeDependency{Condition: "service_started"}
+			}
+		}
+
+		for _, mount := range container.MountPoints {
+			source := aws.ToString(mount.SourceVolume)
+			if volume, ok := volumes[source]; ok && volume.Host != nil && volume.Host.SourcePath != nil {
+				source = aws.ToString(volume.Host.SourcePath)
+			}
+			spec := fmt.Sprintf("%s:%s", source, aws.ToString(mount.ContainerPath))
+			if aws.ToBool(mount.ReadOnly) {
+				spec += ":ro"
+			}
+			service.Volumes = append(service.Volumes, spec)
+		}
+		for _, from := range container.VolumesFrom {
+			spec := aws.ToString(from.SourceContainer)
+			if aws.ToBool(from.ReadOnly) {
+				spec += ":ro"
+			}
+			service.VolumesFrom = append(service.VolumesFrom, spec)
+		}
+
+		if check := container.HealthCheck; check != nil {
+			service.Healthcheck = &composeHealthcheck{
+				Test:        composeEscapeAll(check.Command),
+				Interval:    composeSeconds(check.Interval),
+				Timeout:     composeSeconds(check.Timeout),
+				Retries:     aws.ToInt32(check.Retries),
+				StartPeriod: composeSeconds(check.StartPeriod),
+			}
+		}
+
+		if len(container.SystemControls) > 0 {
+			service.Sysctls = make(map[string]string)
+			for _, control := range container.SystemControls {
+				service.Sysctls[aws.ToString(control.Namespace)] = aws.ToString(control.Value)
+			}
+		}
+		if parameters := container.LinuxParameters; parameters != nil {
+			service.Init = parameters.InitProcessEnabled
+			if parameters.Capabilities != nil || len(parameters.Devices) > 0 || len(parameters.Tmpfs) > 0 {
+				note("linux capabilities, devices and tmpfs aren't translated")
+			}
+		}
+
+		if container.LogConfiguration != nil {
+			note("log configuration is ignored, logs go to docker compose")
+		}
+		if container.FirelensConfiguration != nil {
+			note("FireLens isn't supported")
+		}
+		if container.RepositoryCredentials != nil {
+			note("repository credentials aren't supported, log in to the registry with docker login")
+		}
+		if len(container.ResourceRequirements) > 0 {
+			note("resource requirements like GPUs aren't supported")
+		}
+		if len(container.ExtraHosts) > 0 {
+			note("extra hosts aren't translated")
+		}
+		if len(container.Ulimits) > 0 {
+			note("ulimits aren't translated")
+		}
+		if container.Essential != nil && !aws.ToBool(container.Essential) {
+			note("isn't essential, compose won't stop the other containers when it exits")
+		}
+
+		compose.Services[name] = service
+	}
+	return compose, secrets, notes, nil
+}
+
+func (c *composeFile) addVolume(name string, volume composeVolume) {
+	if c.Volumes == nil {
+		c.Volumes = make(map[string]composeVolume)
+	}
+	c.Volumes[name] = volume
+}
+
+// resolveSecret gets the value of a task definition secret from SSM Parameter Store or Secrets Manager
+func resolveSecret(ctx context.Context, ssmSvc *ssm.Client, secretsSvc *secretsmanager.Client, valueFrom string) (string, error) {
+	parts := strings.Split(valueFrom, ":")
+	if !strings.HasPrefix(valueFrom, "arn:") || (len(parts) > 2 && parts[2] == "ssm") {
+		result, err := ssmSvc.GetParameter(ctx, &ssm.GetParameterInput{
+			Name:           aws.String(valueFrom),
+			WithDecryption: aws.Bool(true),
+		})
+		if err != nil {
+			return "", err
+		}
+		return aws.ToString(result.Parameter.Value), nil
+	}
+
+	// arn:aws:secretsmanager:region:account:secret:name:json-key:version-stage:version-id
+	if len(parts) < 7 {
+		return "", fmt.Errorf("unknown secret %s", valueFrom)
+	}
+	input := &secretsmanager.GetSecretValueInput{SecretId: aws.String(strings.Join(parts[:7], ":"))}
+	var jsonKey string
+	if len(parts) > 7 {
+		jsonKey = parts[7]
+	}
+	if len(parts) > 8 && parts[8] != "" {
+		input.VersionStage = aws.String(parts[8])
+	}
+	if len(parts) > 9 && parts[9] != "" {
+		input.VersionId = aws.String(parts[9])
+	}
+	result, err := secretsSvc.GetSecretValue(ctx, input)
+	if err != nil {
+		return "", err
+	}
+	value := aws.ToString(result.SecretString)
+	if jsonKey == "" {
+		return value, nil
+	}
+	var values map[string]interface{}
+	if err := json.Unmarshal([]byte(value), &values); err != nil {
+		return "", fmt.Errorf("secret %s isn't JSON: %w", valueFrom, err)
+	}
+	picked, ok := values[jsonKey]
+	if !ok {
+		return "", fmt.Errorf("secret %s has no %s key", valueFrom, jsonKey)
+	}
+	if s, ok := picked.(string); ok {
+		return s, nil
+	}
+	return jsonValue(picked), nil
+}
+
+// envFileLine quotes the value if needed, the way compose reads .env files
+func envFileLine(name, value string) string {
+	switch {
+	case !strings.ContainsAny(value, " \t\n\"'#$\\"):
+	case !strings.Contains(value, "'"):
+		// single quoted values are taken literally
+		value = "'" + value + "'"
+	default:
+		// compose interpolates double quoted values, $$ is a literal $
+		value = `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "$", "$$").Replace(value) + `"`
+	}
+	return fmt.Sprintf("%s=%s\n", name, value)
+}
+
+// ComposeTaskDefinition writes a docker compose file for running the task definition locally.
+// With resolveSecrets the secret values are fetched and written to envFile, which compose reads
+// for ${NAME} references; otherwise they have to be set in the environment.
+// An existing envFile is only overwritten with force.
+func ComposeTaskDefinition(ctx context.Context, profile, taskDefinition, outputFile, envFile string, resolveSecrets, force bool) error {
+	err := makeConfig(ctx, profile)
+	if err != nil {
+		return err
+	}
+	logger := log.WithField("task_definition", taskDefinition)
+	if resolveSecrets && !force {
+		// the env file usually has other settings too, they'd be lost
+		if _, err := os.Stat(envFile); err == nil {
+			return fmt.Errorf("%s already exists, use --force to overwrite it or --env_file to write the secrets to another file", envFile)
+		}
+	}
+
+	describeResult, err := ecs.NewFromConfig(localConfig).DescribeTaskDefinition(ctx, &ecs.DescribeTaskDefinitionInput{
+		TaskDefinition: aws.String(taskDefinition),
+	})
+	if err != nil {
+		logger.WithError(err).Error("Can't get task definition")
+		return err
+	}
+
+	compose, secrets, notes, err := composeFromTaskDefinition(describeResult.TaskDefinition)
+	if err != nil {
+		return err
+	}
+	for _, note := range notes {
+		logger.Warn(note)
+	}
+
+	out, err := yaml.Marshal(compose)
+	if err != nil {
+		return err
+	}
+	if outputFile == "" {
+		fmt.Print(string(out))
+	} else {
+		if err := os.WriteFile(outputFile, out, 0644); err != nil {
+			return err
+		}
+		logger.WithField("file", outputFile).Info("Wrote the compose file")
+	}
+
+	if len(secrets) == 0 {
+		return nil
+	}
+	names := make([]string, 0, len(secrets))
+	for name := range secrets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if !resolveSecrets {
+		logger.Warnf("Secrets %s have to be set in the environment or %s", strings.Join(names, ", "), envFile)
+		return nil
+	}
+
+	ssmSvc := ssm.NewFromConfig(localConfig)
+	secretsSvc := secretsmanager.NewFromConfig(localConfig)
+	var env strings.Builder
+	for _, name := range names {
+		value, err := resolveSecret(ctx, ssmSvc, secretsSvc, secrets[name])
+		if err != nil {
+			logger.WithError(err).WithField("secret", name).Error("Can't get the secret value")
+			return err
+		}
+		env.WriteString(envFileLine(name, value))
+	}
+	// secrets, so only readable by the owner
+	if err := os.WriteFile(envFile, []byte(env.String()), 0600); err != nil {
+		return err
+	}
+	logger.WithField("file", envFile).Infof("Wrote %d secrets", len(names))
+	return nil
+}
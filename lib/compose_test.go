@@ -0,0 +1,110 @@
+package lib
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"gopkg.in/yaml.v2"
+)
+
+func TestComposeFromTaskDefinition(t *testing.T) {
+	taskDefinition := &types.TaskDefinition{
+		Volumes: []types.Volume{
+			{Name: aws.String("static")},
+			{Name: aws.String("docker"), Host: &types.HostVolumeProperties{SourcePath: aws.String("/var/run/docker.sock")}},
+			{Name: aws.String("shared"), EfsVolumeConfiguration: &types.EFSVolumeConfiguration{FileSystemId: aws.String("fs-1")}},
+		},
+		ContainerDefinitions: []types.ContainerDefinition{
+			{
+				Name:        aws.String("app"),
+				Image:       aws.String("app:v1"),
+				Command:     []string{"sh", "-c", "echo $HOME"},
+				Environment: []types.KeyValuePair{{Name: aws.String("MODE"), Value: aws.String("web")}},
+				Secrets: []types.Secret{
+					{Name: aws.String("DATABASE_URL"), ValueFrom: aws.String("/app/database_url")},
+				},
+				PortMappings: []types.PortMapping{
+					{ContainerPort: aws.Int32(8000)},
+					{ContainerPort: aws.Int32(53), HostPort: aws.Int32(5353), Protocol: types.TransportProtocolUdp},
+				},
+				DependsOn: []types.ContainerDependency{
+					{ContainerName: aws.String("migrate"), Condition: types.ContainerConditionSuccess},
+				},
+				MountPoints: []types.MountPoint{
+					{SourceVolume: aws.String("static"), ContainerPath: aws.String("/static")},
+					{SourceVolume: aws.String("docker"), ContainerPath: aws.String("/var/run/docker.sock"), ReadOnly: aws.Bool(true)},
+				},
+				HealthCheck: &types.HealthCheck{
+					Command:  []string{"CMD-SHELL", "curl -f localhost:8000"},
+					Interval: aws.Int32(30),
+					Retries:  aws.Int32(3),
+				},
+				LogConfiguration: &types.LogConfiguration{LogDriver: types.LogDriverAwslogs},
+			},
+			{
+				Name:  aws.String("migrate"),
+				Image: aws.String("app:v1"),
+				Secrets: []types.Secret{
+					{Name: aws.String("DATABASE_URL"), ValueFrom: aws.String("/app/database_url")},
+				},
+				MountPoints: []types.MountPoint{
+					{SourceVolume: aws.String("shared"), ContainerPath: aws.String("/shared")},
+				},
+			},
+		},
+	}
+
+	compose, secrets, notes, err := composeFromTaskDefinition(taskDefinition)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := yaml.Marshal(compose)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{
+		"- echo $$HOME",
+		"MODE: web",
+		"DATABASE_URL: ${DATABASE_URL}",
+		"- 8000:8000",
+		"- 5353:53/udp",
+		"condition: service_completed_successfully",
+		"- static:/static",
+		"- /var/run/docker.sock:/var/run/docker.sock:ro",
+		"interval: 30s",
+		"- shared:/shared",
+	} {
+		if !strings.Contains(string(out), expected) {
+			t.Fatalf("expected %q in\n%s", expected, out)
+		}
+	}
+	if _, ok := compose.Volumes["docker"]; ok {
+		t.Fatal("host volumes should be bind mounted, not declared")
+	}
+	if len(secrets) != 1 || secrets["DATABASE_URL"] != "/app/database_url" {
+		t.Fatalf("unexpected secrets %v", secrets)
+	}
+	if len(notes) != 2 {
+		t.Fatalf("expected EFS and log configuration notes, got %v", notes)
+	}
+
+	// the same secret name can't come from two places
+	taskDefinition.ContainerDefinitions[1].Secrets[0].ValueFrom = aws.String("/migrate/database_url")
+	if _, _, _, err := composeFromTaskDefinition(taskDefinition); err == nil {
+		t.Fatal("expected an error for conflicting secrets")
+	}
+}
+
+func TestEnvFileLine(t *testing.T) {
+	for value, expected := range map[string]string{
+		"plain":      "A=plain\n",
+		"with space": "A='with space'\n",
+		"it's $x":    "A=\"it's $$x\"\n",
+	} {
+		if line := envFileLine("A", value); line != expected {
+			t.Fatalf("%q != %q", line, expected)
+		}
+	}
+}
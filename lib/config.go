@@ -0,0 +1,281 @@
+package lib
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Kinds of config values
+const (
+	ConfigString     = "string"
+	ConfigBool       = "bool"
+	ConfigInt        = "integer"
+	ConfigDuration   = "duration"
+	ConfigStringList = "list of strings"
+	ConfigTable      = "table"
+	ConfigTableList  = "list of tables"
+)
+
+// ConfigKey describes a config setting
+type ConfigKey struct {
+	Key  string
+	Kind string
+	// Values limits a string to one of them
+	Values []string
+}
+
+// ConfigKeys are all the settings commands read from the config.
+// Tables like endpoints and deploy.scheduled are listed by their keys
+var ConfigKeys = []ConfigKey{
+	{Key: "profile", Kind: ConfigString},
+	{Key: "region", Kind: ConfigString},
+	{Key: "role_arn", Kind: ConfigString},
+	{Key: "external_id", Kind: ConfigString},
+	{Key: "mfa_serial", Kind: ConfigString},
+	{Key: "session_name", Kind: ConfigString},
+	{Key: "duration", Kind: ConfigDuration},
+	{Key: "credentials_cache", Kind: ConfigBool},
+	{Key: "max_attempts", Kind: ConfigInt},
+	{Key: "endpoint_url", Kind: ConfigString},
+	{Key: "output", Kind: ConfigString, Values: []string{"text", "table", "json"}},
+	{Key: "endpoints.ecs", Kind: ConfigString},
+	{Key: "endpoints.ec2", Kind: ConfigString},
+	{Key: "endpoints.logs", Kind: ConfigString},
+	{Key: "endpoints.ssm", Kind: ConfigString},
+	{Key: "endpoints.ecr", Kind: ConfigString},
+	{Key: "endpoints.sts", Kind: ConfigString},
+	{Key: "cluster", Kind: ConfigString},
+	{Key: "task_definition", Kind: ConfigString},
+	{Key: "container_name", Kind: ConfigString},
+	{Key: "log_group", Kind: ConfigString},
+	{Key: "workdir", Kind: ConfigString},
+	{Key: "image_tag", Kind: ConfigString},
+	{Key: "image_tags", Kind: ConfigStringList},
+	{Key: "task_id", Kind: ConfigString},
+	{Key: "include", Kind: ConfigStringList},
+	{Key: "config_paths", Kind: ConfigStringList},
+	{Key: "config_patterns", Kind: ConfigStringList},
+
+	{Key: "deploy.services", Kind: ConfigStringList},
+	{Key: "deploy.tags", Kind: ConfigStringList},
+	{Key: "deploy.scheduled.rules", Kind: ConfigStringList},
+	{Key: "deploy.scheduled.all", Kind: ConfigBool},
+	{Key: "deploy.scheduled.event_bus", Kind: ConfigString},
+	{Key: "deploy.lock.enabled", Kind: ConfigBool},
+	{Key: "deploy.lock.ttl", Kind: ConfigDuration},
+	{Key: "deploy.lock.owner", Kind: ConfigString},
+	{Key: "deploy.lock.prefix", Kind: ConfigString},
+
+	{Key: "notify", Kind: ConfigTableList},
+
+	{Key: "taskdef.file", Kind: ConfigString},
+	{Key: "taskdef.vars", Kind: ConfigTable},
+	{Key: "taskdef.deploy", Kind: ConfigBool},
+	{Key: "taskdef.var", Kind: ConfigStringList},
+	{Key: "taskdef.dry_run", Kind: ConfigBool},
+	{Key: "taskdef.revision", Kind: ConfigString},
+
+	{Key: "ssh.shell", Kind: ConfigString},
+	{Key: "ssh.service", Kind: ConfigString},
+	{Key: "ssh.container_name", Kind: ConfigString},
+	{Key: "ssh.instance_user", Kind: ConfigString},
+	{Key: "ssh.push_ssh_key", Kind: ConfigBool},
+	{Key: "ssh.task_definition", Kind: ConfigString},
+
+	{Key: "exec.service", Kind: ConfigString},
+
+	{Key: "run.service", Kind: ConfigString},
+	{Key: "run.launch_type", Kind: ConfigString, Values: []string{"EC2", "FARGATE"}},
+	{Key: "run.security_group_filter", Kind: ConfigString},
+	{Key: "run.container_exit_code", Kind: ConfigBool},
+
+	{Key: "ps.service", Kind: ConfigString},
+	{Key: "ps.stopped", Kind: ConfigBool},
+	{Key: "why.services", Kind: ConfigStringList},
+	{Key: "why.log_lines", Kind: ConfigInt},
+	{Key: "scale.services", Kind: ConfigStringList},
+	{Key: "scale.count", Kind: ConfigInt},
+	{Key: "restart.services", Kind: ConfigStringList},
+	{Key: "pause.services", Kind: ConfigStringList},
+	{Key: "resume.services", Kind: ConfigStringList},
+	{Key: "stop_task.reason", Kind: ConfigString},
+
+	{Key: "preview.name", Kind: ConfigString},
+	{Key: "preview.from", Kind: ConfigString},
+	{Key: "preview.count", Kind: ConfigInt},
+	{Key: "preview.load_balancer", Kind: ConfigBool},
+	{Key: "preview.service_discovery", Kind: ConfigBool},
+
+	{Key: "local.file", Kind: ConfigString},
+	{Key: "local.env_file", Kind: ConfigString},
+	{Key: "local.resolve_secrets", Kind: ConfigBool},
+	{Key: "local.force", Kind: ConfigBool},
+
+	{Key: "envs.long", Kind: ConfigBool},
+	{Key: "doctor.commands", Kind: ConfigStringList},
+	{Key: "init.service", Kind: ConfigString},
+	{Key: "init.yes", Kind: ConfigBool},
+
+	{Key: "ejson.file", Kind: ConfigString},
+	{Key: "ejson.name", Kind: ConfigString},
+	{Key: "ejson.kms_key", Kind: ConfigString},
+	{Key: "ejson.keyvar", Kind: ConfigString},
+	{Key: "ejson.dirvar", Kind: ConfigString},
+	{Key: "ejson.pick_keys", Kind: ConfigStringList},
+	{Key: "ejson.processor", Kind: ConfigStringList},
+}
+
+// RequiredConfig lists the settings each command can't work without
+var RequiredConfig = map[string][]string{
+	"deploy":     {"cluster", "deploy.services"},
+	"run":        {"cluster", "task_definition"},
+	"runFargate": {"cluster", "task_definition"},
+	"exec":       {"cluster"},
+	"ssh":        {"cluster", "ssh.service"},
+	"taskdef":    {"taskdef.file"},
+	"ejson":      {"ejson.file", "ejson.name", "ejson.kms_key"},
+}
+
+// checkConfigKind returns an error if the value doesn't fit the kind
+func checkConfigKind(key ConfigKey, value interface{}) error {
+	ok := true
+	switch key.Kind {
+	case ConfigString:
+		var s string
+		if s, ok = value.(string); ok && len(key.Values) > 0 {
+			for _, allowed := range key.Values {
+				if s == allowed {
+					return nil
+				}
+			}
+			return fmt.Errorf("%s should be one of %s, not %q", key.Key, strings.Join(key.Values, ", "), s)
+		}
+	case ConfigBool:
+		_, ok = value.(bool)
+	case ConfigInt:
+		switch value.(type) {
+		case int, int64:
+		default:
+			ok = false
+		}
+	case ConfigDuration:
+		var s string
+		if s, ok = value.(string); ok {
+			if _, err := time.ParseDuration(s); err != nil {
+				return fmt.Errorf("%s should be a duration like \"30m\": %w", key.Key, err)
+			}
+		}
+	case ConfigStringList:
+		var list []interface{}
+		if list, ok = value.([]interface{}); ok {
+			for _, item := range list {
+				if _, isString := item.(string); !isString {
+					ok = false
+				}
+			}
+		}
+	case ConfigTable:
+		_, ok = value.(map[string]interface{})
+	case ConfigTableList:
+		switch value.(type) {
+		case []map[string]interface{}:
+		case []interface{}:
+			for _, item := range value.([]interface{}) {
+				if _, isTable := item.(map[string]interface{}); !isTable {
+					ok = false
+				}
+			}
+		default:
+			ok = false
+		}
+	}
+	if !ok {
+		return fmt.Errorf("%s should be a %s, not %T", key.Key, key.Kind, value)
+	}
+	return nil
+}
+
+// ValidateConfig checks the types of the settings read from the config file.
+// It returns errors for wrong types and the keys no command reads, which are usually typos.
+func ValidateConfig(settings map[string]interface{}) (errs []error, unknown []string) {
+	known := make(map[string]ConfigKey)
+	for _, key := range ConfigKeys {
+		known[key.Key] = key
+	}
+
+	var walk func(prefix string, settings map[string]interface{})
+	walk = func(prefix string, settings map[string]interface{}) {
+		names := make([]string, 0, len(settings))
+		for name := range settings {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			path := strings.ToLower(joinPath(prefix, name))
+			value := settings[name]
+			if key, ok := known[path]; ok {
+				if err := checkConfigKind(key, value); err != nil {
+					errs = append(errs, err)
+				}
+				continue
+			}
+			if table, ok := value.(map[string]interface{}); ok {
+				walk(path, table)
+				continue
+			}
+			unknown = append(unknown, path)
+		}
+	}
+	walk("", settings)
+	return errs, unknown
+}
+
+// ConfigLayer is one of the files the config is merged from
+type ConfigLayer struct {
+	File     string
+	Settings map[string]interface{}
+}
+
+// MergeConfigLayers deep merges the tables of the layers, the later layers win.
+// Lists are replaced, not appended to. sources has the file every value came from, by dotted key
+func MergeConfigLayers(layers []ConfigLayer) (settings map[string]interface{}, sources map[string]string) {
+	settings = make(map[string]interface{})
+	sources = make(map[string]string)
+
+	var merge func(prefix string, target, layer map[string]interface{}, file string)
+	merge = func(prefix string, target, layer map[string]interface{}, file string) {
+		for name, value := range layer {
+			name = strings.ToLower(name)
+			path := joinPath(prefix, name)
+			if table, ok := value.(map[string]interface{}); ok {
+				existing, ok := target[name].(map[string]interface{})
+				if !ok {
+					// a table replaces a plain value
+					dropSources(sources, path)
+					existing = make(map[string]interface{})
+					target[name] = existing
+				}
+				merge(path, existing, table, file)
+				continue
+			}
+			dropSources(sources, path)
+			target[name] = value
+			sources[path] = file
+		}
+	}
+	for _, layer := range layers {
+		merge("", settings, layer.Settings, layer.File)
+	}
+	return settings, sources
+}
+
+// dropSources forgets the key and everything under it
+func dropSources(sources map[string]string, path string) {
+	for key := range sources {
+		if key == path || strings.HasPrefix(key, path+".") {
+			delete(sources, key)
+		}
+	}
+}
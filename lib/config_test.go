@@ -0,0 +1,110 @@
+package lib
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestValidateExampleConfig(t *testing.T) {
+	config := viper.New()
+	config.SetConfigFile("../example.toml")
+	if err := config.ReadInConfig(); err != nil {
+		t.Fatal(err)
+	}
+	errs, unknown := ValidateConfig(config.AllSettings())
+	if len(errs) > 0 || len(unknown) > 0 {
+		t.Fatalf("example.toml should be valid, got %v and unknown keys %v", errs, unknown)
+	}
+}
+
+func TestValidateConfig(t *testing.T) {
+	errs, unknown := ValidateConfig(map[string]interface{}{
+		"cluster": int64(1),
+		"deploy": map[string]interface{}{
+			"services": []interface{}{"app", int64(2)},
+			"lock":     map[string]interface{}{"ttl": "30 minutes"},
+			"servics":  []interface{}{"app"},
+		},
+		"run":    map[string]interface{}{"launch_type": "fargate"},
+		"notify": []map[string]interface{}{{"url": "https://example.com"}},
+	})
+	if len(errs) != 4 {
+		t.Fatalf("expected cluster, services, ttl and launch_type errors, got %v", errs)
+	}
+	if len(unknown) != 1 || unknown[0] != "deploy.servics" {
+		t.Fatalf("expected deploy.servics to be unknown, got %v", unknown)
+	}
+}
+
+func TestMergeConfigLayers(t *testing.T) {
+	settings, sources := MergeConfigLayers([]ConfigLayer{
+		{File: "ecs.toml", Settings: map[string]interface{}{
+			"profile": "base",
+			"run":     map[string]interface{}{"service": "app", "launch_type": "EC2"},
+			"deploy":  map[string]interface{}{"services": []interface{}{"app", "worker"}},
+			"workdir": "x",
+		}},
+		{File: "ecs-prod.toml", Settings: map[string]interface{}{
+			"run":     map[string]interface{}{"launch_type": "FARGATE"},
+			"deploy":  map[string]interface{}{"services": []interface{}{"app"}},
+			"workdir": map[string]interface{}{"oops": true},
+		}},
+	})
+	run := settings["run"].(map[string]interface{})
+	if run["service"] != "app" || run["launch_type"] != "FARGATE" {
+		t.Fatalf("tables should be merged, got %v", run)
+	}
+	if services := settings["deploy"].(map[string]interface{})["services"].([]interface{}); len(services) != 1 {
+		t.Fatalf("lists should be replaced, got %v", services)
+	}
+	for key, file := range map[string]string{
+		"profile":         "ecs.toml",
+		"run.service":     "ecs.toml",
+		"run.launch_type": "ecs-prod.toml",
+		"deploy.services": "ecs-prod.toml",
+		"workdir.oops":    "ecs-prod.toml",
+	} {
+		if sources[key] != file {
+			t.Fatalf("%s should come from %s, got %s", key, file, sources[key])
+		}
+	}
+	if _, ok := sources["workdir"]; ok {
+		t.Fatal("workdir was replaced by a table")
+	}
+}
+
+// TestConfigKeysListed makes sure every key the commands bind or read is in ConfigKeys,
+// otherwise it'd be reported as unused in the config
+func TestConfigKeysListed(t *testing.T) {
+	files, err := filepath.Glob("../cmd/*.go")
+	if err != nil || len(files) == 0 {
+		t.Fatalf("can't find the commands: %v", err)
+	}
+	used := regexp.MustCompile(`(?:viper\.(?:BindPFlag|Get\w*|UnmarshalKey|IsSet)|serviceList)\("([^"]+)"`)
+	for _, file := range files {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, match := range used.FindAllStringSubmatch(string(content), -1) {
+			if !configKeyListed(match[1]) {
+				t.Errorf("%s uses %s, which isn't in ConfigKeys", file, match[1])
+			}
+		}
+	}
+}
+
+// configKeyListed is true for the listed keys and the tables of the listed keys
+func configKeyListed(name string) bool {
+	for _, key := range ConfigKeys {
+		if key.Key == name || strings.HasPrefix(key.Key, name+".") {
+			return true
+		}
+	}
+	return false
+}
@@ -1,47 +1,107 @@
 package lib
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/apex/log"
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge"
 )
 
-// DeployServices deploys specified services in parallel
-func DeployServices(profile, cluster, imageTag string, imageTags, services []string, workDir string) (exitCode int, err error) {
-	ctx := log.WithFields(log.Fields{
-		"cluster":   cluster,
-		"image_tag": imageTag,
+// servicesStableTimeout is how long deploy waits for a service to become stable
+const servicesStableTimeout = 10 * time.Minute
+
+// DeployOptions are the services to deploy with DeployServices
+type DeployOptions struct {
+	Profile string
+	Cluster string
+	// Services are deployed in parallel
+	Services []string
+	// ImageTag replaces the image tags of all the containers, ImageTags the ones of the containers in order
+	ImageTag  string
+	ImageTags []string
+	WorkDir   string
+	// Tags are added to the new task definitions as key=value
+	Tags      []string
+	Scheduled ScheduledTasks
+	// TaskDefinitionArn is a registered task definition to deploy instead of copies of the current ones
+	TaskDefinitionArn string
+}
+
+// ServiceDeployment is how the deploy of a service went
+type ServiceDeployment struct {
+	Service string `json:"service"`
+	// TaskDefinitionArn is the revision the service was updated to
+	TaskDefinitionArn string `json:"task_definition_arn,omitempty"`
+	// PreviousTaskDefinitionArn is the revision the service ran before the deploy
+	PreviousTaskDefinitionArn string `json:"previous_task_definition_arn,omitempty"`
+	RolledBack                bool   `json:"rolled_back"`
+	Err                       error  `json:"-"`
+}
+
+// DeployResult is how the deploy of every service went, in the order of DeployOptions.Services
+type DeployResult struct {
+	Services []ServiceDeployment `json:"services"`
+}
+
+// DeployServices deploys specified services in parallel.
+// If any of them fails, all of them are rolled back and ErrDeployFailed is returned.
+// If AWS throttled some of them and nothing else failed, nothing is rolled back and ErrThrottled is returned
+func DeployServices(ctx context.Context, opts DeployOptions) (*DeployResult, error) {
+	logger := log.WithFields(log.Fields{
+		"cluster":   opts.Cluster,
+		"image_tag": opts.ImageTag,
 	})
 
-	err = makeSession(profile)
+	if err := makeConfig(ctx, opts.Profile); err != nil {
+		return nil, err
+	}
+	tags, err := deployTags(ctx, opts.Tags)
 	if err != nil {
-		return 1, err
+		return nil, err
 	}
-	exits := make(chan int, len(services))
-	rollback := make(chan bool, len(services))
+	exits := make(chan error, len(opts.Services))
+	rollback := make(chan bool, len(opts.Services))
 
+	// one poller describes all the services, the rollbacks need it even after Ctrl-C
+	poller := newServicePoller(ecs.NewFromConfig(localConfig), opts.Cluster)
+	stopPolling := poller.start(context.WithoutCancel(ctx))
+	defer stopPolling()
+
+	result := &DeployResult{Services: make([]ServiceDeployment, len(opts.Services))}
 	var wg sync.WaitGroup
-	for _, service := range services {
-		service := service // go catch
+	for n, service := range opts.Services {
+		deployment := &result.Services[n]
+		deployment.Service = service
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			deployService(ctx, cluster, imageTag, imageTags, workDir, service, exits, rollback, &wg)
+			deployService(ctx, logger, poller, opts, deployment, tags, exits, rollback, &wg)
 		}()
 	}
 
-	for n := 0; n < len(services); n++ {
-		if code := <-exits; code > 0 {
-			exitCode = 127
-			err = fmt.Errorf("One of the services failed to deploy")
+	throttled := false
+	for n := 0; n < len(opts.Services); n++ {
+		switch exitErr := <-exits; {
+		case isThrottleError(exitErr):
+			throttled = true
+		case exitErr != nil && err == nil:
+			err = fmt.Errorf("%w: %w", ErrDeployFailed, exitErr)
 		}
 	}
-	if exitCode != 0 {
-		for n := 0; n < len(services); n++ {
+	if err == nil && throttled {
+		// the services aren't broken, so they are left as they are
+		err = fmt.Errorf("%w of the deploy, nothing has been rolled back", ErrThrottled)
+	}
+	if errors.Is(err, ErrDeployFailed) {
+		for n := 0; n < len(opts.Services); n++ {
 			rollback <- true
 		}
 	} else {
@@ -49,191 +109,294 @@ func DeployServices(profile, cluster, imageTag string, imageTags, services []str
 	}
 
 	wg.Wait()
-	return
+	return result, err
 }
 
-func deployService(ctx log.Interface, cluster, imageTag string, imageTags []string, workDir, service string, exitChan chan int, rollback chan bool, wg *sync.WaitGroup) {
-	ctx = ctx.WithFields(log.Fields{
+func deployService(ctx context.Context, logger log.Interface, poller *servicePoller, opts DeployOptions, deployment *ServiceDeployment, tags []types.Tag, exitChan chan error, rollback chan bool, wg *sync.WaitGroup) {
+	cluster, service := opts.Cluster, deployment.Service
+	imageTag, imageTags := opts.ImageTag, opts.ImageTags
+	logger = logger.WithFields(log.Fields{
 		"service": service,
 	})
-	ctx.Info("Deploying")
+	logger.Info("Deploying")
 
-	svc := ecs.New(localSession)
+	event := NotifyEvent{
+		Cluster:  cluster,
+		Service:  service,
+		ImageTag: imageTag,
+	}
+	if event.ImageTag == "" {
+		event.ImageTag = strings.Join(imageTags, ",")
+	}
+	notifyEvent := func(name string, err error) {
+		event := event
+		event.Event = name
+		if err != nil {
+			event.Error = err.Error()
+		}
+		notify(event)
+	}
+	fail := func(err error) {
+		notifyEvent(EventDeployFailed, err)
+		deployment.Err = err
+		exitChan <- err
+	}
+	notifyEvent(EventDeployStarted, nil)
+
+	svc := ecs.NewFromConfig(localConfig)
 
 	// first, describe the service to get current task definition
-	describeResult, err := svc.DescribeServices(&ecs.DescribeServicesInput{
+	describeResult, err := svc.DescribeServices(ctx, &ecs.DescribeServicesInput{
 		Cluster:  aws.String(cluster),
-		Services: aws.StringSlice([]string{service}),
+		Services: []string{service},
 	})
 	if err != nil {
-		ctx.WithError(err).Error("Can't describe service")
-		exitChan <- 1
+		logger.WithError(err).Error("Can't describe service")
+		fail(err)
 		return
 	}
 	if len(describeResult.Failures) > 0 {
 		for _, failure := range describeResult.Failures {
-			ctx.Error(failure.GoString())
+			logger.Errorf("%s: %s", aws.ToString(failure.Arn), aws.ToString(failure.Reason))
 		}
-		exitChan <- 2
+		fail(fmt.Errorf("can't describe service: %s", aws.ToString(describeResult.Failures[0].Reason)))
 		return
 	}
 
 	// then describe the task definition to get a copy of it
-	describeTaskResult, err := svc.DescribeTaskDefinition(&ecs.DescribeTaskDefinitionInput{
+	describeTaskResult, err := svc.DescribeTaskDefinition(ctx, &ecs.DescribeTaskDefinitionInput{
 		TaskDefinition: describeResult.Services[0].TaskDefinition,
-		Include:        aws.StringSlice([]string{"TAGS"}),
+		Include:        []types.TaskDefinitionField{types.TaskDefinitionFieldTags},
 	})
 	if err != nil {
-		ctx.WithError(err).Error("Can't get task definition")
-		exitChan <- 3
+		logger.WithError(err).Error("Can't get task definition")
+		fail(err)
 		return
 	}
 
 	taskDefinition := describeTaskResult.TaskDefinition
-	// replace the image tag if there is any
-	if err := modifyContainerDefinitionImages(imageTag, imageTags, workDir, taskDefinition.ContainerDefinitions, ctx); err != nil {
-		ctx.WithError(err).Error("Can't modify container definition images")
-		exitChan <- 1
+	var registerResult *ecs.RegisterTaskDefinitionOutput
+	if opts.TaskDefinitionArn != "" {
+		// the new task definition has been registered already, i.e. rendered from a template
+		newTaskResult, err := svc.DescribeTaskDefinition(ctx, &ecs.DescribeTaskDefinitionInput{
+			TaskDefinition: aws.String(opts.TaskDefinitionArn),
+		})
+		if err != nil {
+			logger.WithError(err).Error("Can't get task definition")
+			fail(err)
+			return
+		}
+		registerResult = &ecs.RegisterTaskDefinitionOutput{TaskDefinition: newTaskResult.TaskDefinition}
+	} else {
+		// replace the image tag if there is any
+		if err := modifyContainerDefinitionImages(imageTag, imageTags, opts.WorkDir, taskDefinition.ContainerDefinitions, logger); err != nil {
+			logger.WithError(err).Error("Can't modify container definition images")
+			fail(err)
+			return
+		}
 	}
 
-	// now, register the new task
-	registerResult, err := svc.RegisterTaskDefinition(&ecs.RegisterTaskDefinitionInput{
-		ContainerDefinitions:    taskDefinition.ContainerDefinitions,
-		Cpu:                     taskDefinition.Cpu,
-		ExecutionRoleArn:        taskDefinition.ExecutionRoleArn,
-		Family:                  taskDefinition.Family,
-		Memory:                  taskDefinition.Memory,
-		NetworkMode:             taskDefinition.NetworkMode,
-		PlacementConstraints:    taskDefinition.PlacementConstraints,
-		RequiresCompatibilities: taskDefinition.Compatibilities,
-		TaskRoleArn:             taskDefinition.TaskRoleArn,
-		Volumes:                 taskDefinition.Volumes,
-		Tags:                    nilIfEmpty(describeTaskResult.Tags),
-	})
-	if err != nil {
-		ctx.WithError(err).Error("Can't register task definition")
-		exitChan <- 4
-		return
+	// find the scheduled tasks running the same task definition family
+	eventsSvc := eventbridge.NewFromConfig(localConfig)
+	var scheduledTargets []scheduledTarget
+	if opts.Scheduled.Enabled() {
+		family := taskDefinition.Family
+		if registerResult != nil {
+			family = registerResult.TaskDefinition.Family
+		}
+		scheduledTargets, err = findScheduledTargets(
+			ctx,
+			eventsSvc,
+			aws.ToString(describeResult.Services[0].ClusterArn),
+			aws.ToString(family),
+			opts.Scheduled,
+		)
+		if err != nil {
+			logger.WithError(err).Error("Can't find scheduled tasks")
+			fail(err)
+			return
+		}
+		logger.Debugf("Found %d scheduled tasks to update", len(scheduledTargets))
 	}
-	ctx.WithField(
-		"task_definition_arn",
-		aws.StringValue(registerResult.TaskDefinition.TaskDefinitionArn),
-	).Debug("Registered the task definition")
 
-	// now we are running DescribeService periodically to get the events
-	doneChan := make(chan bool)
-	defer func() { doneChan <- true }()
-
-	wg.Add(1)
-	go func(ctx log.Interface, cluster, service string) {
-		last := time.Now()
+	// now, register the new task
+	if registerResult == nil {
+		registerResult, err = svc.RegisterTaskDefinition(ctx, registerTaskDefinitionInput(
+			taskDefinition,
+			mergeTags(
+				mergeTags(withoutToolTags(describeTaskResult.Tags), tags...),
+				imageTagsTag(taskDefinition.ContainerDefinitions),
+			),
+		))
+		if err != nil {
+			logger.WithError(err).Error("Can't register task definition")
+			fail(err)
+			return
+		}
+		logger.WithField(
+			"task_definition_arn",
+			aws.ToString(registerResult.TaskDefinition.TaskDefinitionArn),
+		).Debug("Registered the task definition")
+	}
 
-		defer wg.Done()
-		svc := ecs.New(localSession)
-
-		ticker := time.NewTicker(10 * time.Second)
-		defer ticker.Stop()
-		printEvent := func(last time.Time) time.Time {
-			describeResult, err := svc.DescribeServices(&ecs.DescribeServicesInput{
-				Cluster:  aws.String(cluster),
-				Services: aws.StringSlice([]string{service}),
-			})
-			if err != nil {
-				ctx.WithError(err).Error("Can't describe service")
-				return last
-			}
-			for _, event := range describeResult.Services[0].Events {
-				if !aws.TimeValue(event.CreatedAt).Before(last) {
-					ctx.Info(aws.StringValue(event.Message))
-					last = aws.TimeValue(event.CreatedAt)
-				}
-			}
+	deployment.PreviousTaskDefinitionArn = aws.ToString(describeResult.Services[0].TaskDefinition)
+	deployment.TaskDefinitionArn = aws.ToString(registerResult.TaskDefinition.TaskDefinitionArn)
 
-			return last
-		}
-		for {
-			select {
-			case <-doneChan:
-				printEvent(last)
-				return
-			case <-ticker.C:
-				last = printEvent(last)
-			}
-		}
-	}(ctx, cluster, service)
+	// now we are running DescribeService periodically to get the events
+	defer watchServiceEvents(logger, poller, service, wg)()
 
 	// update the service using the new registered task definition
 	err = updateService(
 		ctx,
-		aws.StringValue(describeResult.Services[0].ClusterArn),
-		aws.StringValue(describeResult.Services[0].ServiceArn),
-		aws.StringValue(registerResult.TaskDefinition.TaskDefinitionArn),
+		logger,
+		poller,
+		aws.ToString(describeResult.Services[0].ClusterArn),
+		aws.ToString(describeResult.Services[0].ServiceArn),
+		aws.ToString(registerResult.TaskDefinition.TaskDefinitionArn),
 	)
+	// then point the scheduled tasks at the new task definition
+	scheduledUpdated := false
+	if err == nil && len(scheduledTargets) > 0 {
+		scheduledUpdated = true
+		err = updateScheduledTargets(ctx, logger, eventsSvc, scheduledTargets, aws.ToString(registerResult.TaskDefinition.TaskDefinitionArn))
+	}
 
 	wg.Add(1)
 	// run the rollback function in background
-	go func(ctx log.Interface) {
+	go func(logger log.Interface) {
 		defer wg.Done()
+		// roll back even if the deploy has been interrupted
+		ctx := context.WithoutCancel(ctx)
 		if n, ok := <-rollback; n && ok {
-			ctx.WithField(
+			logger.WithField(
 				"task_definition_arn",
-				aws.StringValue(describeResult.Services[0].TaskDefinition),
+				aws.ToString(describeResult.Services[0].TaskDefinition),
 			).Info("Rolling back to the previous task definition")
-			if err := updateService(
+			notifyEvent(EventRollbackStarted, nil)
+			err := updateService(
 				ctx,
-				aws.StringValue(describeResult.Services[0].ClusterArn),
-				aws.StringValue(describeResult.Services[0].ServiceArn),
-				aws.StringValue(describeResult.Services[0].TaskDefinition),
-			); err != nil {
-				ctx.WithError(err).Error("Couldn't rollback.")
+				logger,
+				poller,
+				aws.ToString(describeResult.Services[0].ClusterArn),
+				aws.ToString(describeResult.Services[0].ServiceArn),
+				aws.ToString(describeResult.Services[0].TaskDefinition),
+			)
+			if err != nil {
+				logger.WithError(err).Error("Couldn't rollback.")
 			}
+			if scheduledUpdated {
+				if scheduledErr := updateScheduledTargets(ctx, logger, eventsSvc, scheduledTargets, ""); scheduledErr != nil {
+					logger.WithError(scheduledErr).Error("Couldn't rollback the scheduled tasks.")
+					err = scheduledErr
+				}
+			}
+			deployment.RolledBack = err == nil
+			notifyEvent(EventRollbackFinished, err)
 		}
-	}(ctx)
+	}(logger)
 
 	var deregisterTaskArn *string
-	if err != nil {
-		ctx.WithError(err).Error("Couldn't deploy. Will try to roll back")
+	switch {
+	case isThrottleError(err):
+		// the service may run the new task definition already, so neither of them is deregistered
+		logger.WithError(err).Error("AWS throttled the deploy. It won't be rolled back")
+		fail(err)
+		return
+	case err != nil:
+		logger.WithError(err).Error("Couldn't deploy. Will try to roll back")
+		if diagnoseErr := diagnoseService(
+			context.WithoutCancel(ctx),
+			logger,
+			svc,
+			cluster,
+			service,
+			aws.ToString(registerResult.TaskDefinition.TaskDefinitionArn),
+			DefaultDiagnosisLogLines,
+		); diagnoseErr != nil {
+			logger.WithError(diagnoseErr).Warn("Can't find out why the tasks failed")
+		}
 		deregisterTaskArn = registerResult.TaskDefinition.TaskDefinitionArn
-		exitChan <- 5
-	} else {
+		fail(err)
+	default:
 		deregisterTaskArn = describeTaskResult.TaskDefinition.TaskDefinitionArn
-		exitChan <- 0
+		notifyEvent(EventServiceStable, nil)
+		exitChan <- nil
 	}
 
 	// deregister the old task definition
-	ctx = ctx.WithFields(log.Fields{"task_definition_arn": aws.StringValue(deregisterTaskArn)})
-	ctx.Debug("Deregistered the task definition")
-	_, err = svc.DeregisterTaskDefinition(&ecs.DeregisterTaskDefinitionInput{
+	logger = logger.WithFields(log.Fields{"task_definition_arn": aws.ToString(deregisterTaskArn)})
+	logger.Debug("Deregistered the task definition")
+	_, err = svc.DeregisterTaskDefinition(context.WithoutCancel(ctx), &ecs.DeregisterTaskDefinitionInput{
 		TaskDefinition: deregisterTaskArn,
 	})
 	if err != nil {
-		ctx.WithError(err).Error("Can't deregister task definition")
+		logger.WithError(err).Error("Can't deregister task definition")
 	}
 
 }
 
-func updateService(ctx log.Interface, cluster, service, taskDefinition string) error {
-	svc := ecs.New(localSession)
+// watchServiceEvents prints the new events of the service the poller gets until the returned function is called
+func watchServiceEvents(logger log.Interface, poller *servicePoller, service string, wg *sync.WaitGroup) (stop func()) {
+	updates, unwatch := poller.watch(service)
+	doneChan := make(chan bool)
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer unwatch()
+
+		last := time.Now()
+		printEvents := func(update serviceUpdate) {
+			for _, event := range update.service.Events {
+				if !aws.ToTime(event.CreatedAt).Before(last) {
+					logger.Info(aws.ToString(event.Message))
+					last = aws.ToTime(event.CreatedAt)
+				}
+			}
+		}
+		for {
+			select {
+			case <-doneChan:
+				// the events of the last poll
+				select {
+				case update := <-updates:
+					printEvents(update)
+				default:
+				}
+				return
+			case update := <-updates:
+				printEvents(update)
+			}
+		}
+	}()
+
+	return func() { doneChan <- true }
+}
+
+func updateService(ctx context.Context, logger log.Interface, poller *servicePoller, cluster, service, taskDefinition string) error {
 	// update the service using the new registered task definition
-	_, err := svc.UpdateService(&ecs.UpdateServiceInput{
+	return updateServiceWith(ctx, logger, poller, &ecs.UpdateServiceInput{
 		Cluster:        aws.String(cluster),
 		Service:        aws.String(service),
 		TaskDefinition: aws.String(taskDefinition),
 	})
+}
+
+// updateServiceWith updates the service and waits for the poller to find it stable
+func updateServiceWith(ctx context.Context, logger log.Interface, poller *servicePoller, input *ecs.UpdateServiceInput) error {
+	svc := ecs.NewFromConfig(localConfig)
+	_, err := svc.UpdateService(ctx, input)
 	if err != nil {
-		ctx.WithError(err).Error("Can't update the service")
+		logger.WithError(err).Error("Can't update the service")
 		return err
 	}
-	ctx.Info("Updated the service")
-	err = svc.WaitUntilServicesStable(&ecs.DescribeServicesInput{
-		Cluster:  aws.String(cluster),
-		Services: []*string{aws.String(service)},
-	})
+	logger.Info("Updated the service")
+	err = poller.waitStable(ctx, aws.ToString(input.Service), servicesStableTimeout)
 	if err != nil {
-		ctx.WithError(err).Error("The waiter has been finished with an error")
+		logger.WithError(err).Error("The waiter has been finished with an error")
 		return err
 	}
 
-	ctx.Info("Service has been deployed")
+	logger.Info("Service has been deployed")
 	return nil
 }
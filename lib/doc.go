@@ -0,0 +1,20 @@
+// Package lib is what ecs-tool is made of, it can be used by other Go tools too.
+//
+// The functions take a context, which cancels the AWS calls and the waiters, and return errors
+// instead of exiting. The errors wrap the sentinel errors like ErrDeployFailed, so they can be told apart with errors.Is:
+//
+//	result, err := lib.RunTask(ctx, lib.RunOptions{
+//		Cluster:        "production",
+//		TaskDefinition: "app-production",
+//		ContainerName:  "app",
+//		LogGroup:       "ecs-tool",
+//		Command:        []string{"./manage.py", "migrate"},
+//	})
+//	if err != nil {
+//		return err
+//	}
+//	fmt.Println(strings.Join(result.Logs, "\n"))
+//	os.Exit(result.ExitCode)
+//
+// ConfigureAWS sets the region, endpoints and retries of the AWS calls, it should be called before any of them.
+package lib
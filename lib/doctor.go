@@ -0,0 +1,239 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/Shopify/ejson"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+// Check statuses
+const (
+	CheckPassed  = "ok"
+	CheckFailed  = "FAIL"
+	CheckWarning = "warn"
+	CheckSkipped = "skip"
+)
+
+// Check is one line of the doctor checklist
+type Check struct {
+	Name   string
+	Status string
+	Detail string
+}
+
+func passed(name, format string, args ...interface{}) Check {
+	return Check{Name: name, Status: CheckPassed, Detail: fmt.Sprintf(format, args...)}
+}
+
+func failed(name string, err error) Check {
+	return Check{Name: name, Status: CheckFailed, Detail: err.Error()}
+}
+
+func skipped(name, format string, args ...interface{}) Check {
+	return Check{Name: name, Status: CheckSkipped, Detail: fmt.Sprintf(format, args...)}
+}
+
+// DoctorConfig is what doctor checks against AWS
+type DoctorConfig struct {
+	Profile        string
+	Cluster        string
+	TaskDefinition string
+	// Containers are the container names to look for in the task definition
+	Containers []string
+	// SSHContainer is looked for in SSHTaskDefinition, ssh can connect to another task definition
+	SSHTaskDefinition string
+	SSHContainer      string
+	Services          []string
+	LogGroup          string
+	KMSKey            string
+
+	EjsonFile       string
+	EjsonKeyDir     string
+	EjsonPrivateKey string
+}
+
+// doctorChecks runs the checks against AWS, skipping the ones that depend on a failed check
+func doctorChecks(ctx context.Context, cfg DoctorConfig) []Check {
+	var checks []Check
+	add := func(check Check) bool {
+		checks = append(checks, check)
+		return check.Status != CheckFailed
+	}
+
+	if err := makeConfig(ctx, cfg.Profile); err != nil {
+		add(failed("credentials", err))
+		return checks
+	}
+	identity, err := sts.NewFromConfig(localConfig).GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{})
+	if err != nil {
+		add(failed("credentials", err))
+		return append(checks, skipped("aws", "the rest needs credentials"))
+	}
+	add(passed("credentials", "%s", aws.ToString(identity.Arn)))
+
+	svc := ecs.NewFromConfig(localConfig)
+	clusterOK := false
+	if cfg.Cluster == "" {
+		add(skipped("cluster", "cluster isn't set"))
+	} else {
+		clusterOK = add(checkCluster(ctx, svc, cfg.Cluster))
+	}
+
+	switch {
+	case len(cfg.Services) == 0:
+		add(skipped("services", "no services are set"))
+	case !clusterOK:
+		add(skipped("services", "needs the cluster"))
+	default:
+		_, err := describeServicesWithTags(ctx, svc, cfg.Cluster, cfg.Services)
+		add(checkResult("services", err, "%s", strings.Join(cfg.Services, ", ")))
+	}
+
+	if cfg.TaskDefinition == "" {
+		add(skipped("task definition", "task_definition isn't set"))
+		add(skipped("containers", "needs the task definition"))
+	} else {
+		describeResult, err := svc.DescribeTaskDefinition(ctx, &ecs.DescribeTaskDefinitionInput{
+			TaskDefinition: aws.String(cfg.TaskDefinition),
+		})
+		if add(checkResult("task definition", err, "%s", cfg.TaskDefinition)) {
+			add(checkContainers(describeResult.TaskDefinition, cfg.Containers))
+		} else {
+			add(skipped("containers", "needs the task definition"))
+		}
+	}
+
+	switch {
+	case cfg.SSHContainer == "":
+	case cfg.SSHTaskDefinition == "":
+		add(skipped("ssh container", "ssh.task_definition isn't set"))
+	default:
+		describeResult, err := svc.DescribeTaskDefinition(ctx, &ecs.DescribeTaskDefinitionInput{
+			TaskDefinition: aws.String(cfg.SSHTaskDefinition),
+		})
+		if add(checkResult("ssh task definition", err, "%s", cfg.SSHTaskDefinition)) {
+			check := checkContainers(describeResult.TaskDefinition, []string{cfg.SSHContainer})
+			check.Name = "ssh container"
+			add(check)
+		}
+	}
+
+	if cfg.LogGroup == "" {
+		add(skipped("log group", "log_group isn't set"))
+	} else {
+		add(checkLogGroup(ctx, cloudwatchlogs.NewFromConfig(localConfig), cfg.LogGroup))
+	}
+
+	if cfg.KMSKey == "" {
+		add(skipped("kms key", "ejson.kms_key isn't set"))
+	} else {
+		keyResult, err := kms.NewFromConfig(localConfig).DescribeKey(ctx, &kms.DescribeKeyInput{KeyId: aws.String(cfg.KMSKey)})
+		if err == nil {
+			add(passed("kms key", "%s is %s", cfg.KMSKey, aws.ToString(keyResult.KeyMetadata.KeyId)))
+		} else {
+			add(failed("kms key", err))
+		}
+	}
+
+	switch {
+	case cfg.EjsonFile == "":
+		add(skipped("ejson", "ejson.file isn't set"))
+	case cfg.EjsonPrivateKey == "" && cfg.EjsonKeyDir == "":
+		add(failed("ejson", fmt.Errorf("neither the private key nor the keys directory is set in the environment")))
+	default:
+		_, err := ejson.DecryptFile(cfg.EjsonFile, cfg.EjsonKeyDir, cfg.EjsonPrivateKey)
+		add(checkResult("ejson", err, "%s decrypts", cfg.EjsonFile))
+	}
+	return checks
+}
+
+func checkResult(name string, err error, format string, args ...interface{}) Check {
+	if err != nil {
+		return failed(name, err)
+	}
+	return passed(name, format, args...)
+}
+
+func checkCluster(ctx context.Context, svc *ecs.Client, cluster string) Check {
+	describeResult, err := svc.DescribeClusters(ctx, &ecs.DescribeClustersInput{
+		Clusters: []string{cluster},
+	})
+	if err != nil {
+		return failed("cluster", err)
+	}
+	if len(describeResult.Clusters) == 0 {
+		return failed("cluster", fmt.Errorf("cluster %s doesn't exist", cluster))
+	}
+	if status := aws.ToString(describeResult.Clusters[0].Status); status != "ACTIVE" {
+		return failed("cluster", fmt.Errorf("cluster %s is %s", cluster, status))
+	}
+	return passed("cluster", "%s", cluster)
+}
+
+// checkContainers makes sure all the configured containers are in the task definition
+func checkContainers(taskDefinition *types.TaskDefinition, containers []string) Check {
+	if len(containers) == 0 {
+		return skipped("containers", "no container names are set")
+	}
+	defined := make(map[string]bool)
+	var names []string
+	for _, container := range taskDefinition.ContainerDefinitions {
+		defined[aws.ToString(container.Name)] = true
+		names = append(names, aws.ToString(container.Name))
+	}
+	for _, container := range containers {
+		if !defined[container] {
+			return failed("containers", fmt.Errorf("container %s isn't in the task definition, it has %s", container, strings.Join(names, ", ")))
+		}
+	}
+	return passed("containers", "%s", strings.Join(containers, ", "))
+}
+
+func checkLogGroup(ctx context.Context, svc *cloudwatchlogs.Client, logGroup string) Check {
+	found := false
+	paginator := cloudwatchlogs.NewDescribeLogGroupsPaginator(svc, &cloudwatchlogs.DescribeLogGroupsInput{
+		LogGroupNamePrefix: aws.String(logGroup),
+	})
+	for !found && paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return failed("log group", err)
+		}
+		for _, group := range page.LogGroups {
+			if aws.ToString(group.LogGroupName) == logGroup {
+				found = true
+			}
+		}
+	}
+	if !found {
+		return failed("log group", fmt.Errorf("log group %s doesn't exist", logGroup))
+	}
+	return passed("log group", "%s", logGroup)
+}
+
+// Doctor prints a checklist of the config checks followed by the checks against AWS.
+// It returns the number of failed checks
+func Doctor(ctx context.Context, cfg DoctorConfig, configChecks []Check) int {
+	checks := append(configChecks, doctorChecks(ctx, cfg)...)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	failures := 0
+	for _, check := range checks {
+		if check.Status == CheckFailed {
+			failures++
+		}
+		fmt.Fprintf(w, "[%s]\t%s\t%s\n", check.Status, check.Name, check.Detail)
+	}
+	w.Flush()
+	return failures
+}
@@ -0,0 +1,26 @@
+package lib
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+)
+
+func TestCheckContainers(t *testing.T) {
+	taskDefinition := &types.TaskDefinition{
+		ContainerDefinitions: []types.ContainerDefinition{
+			{Name: aws.String("app")},
+			{Name: aws.String("nginx")},
+		},
+	}
+	if check := checkContainers(taskDefinition, []string{"app", "nginx"}); check.Status != CheckPassed {
+		t.Fatalf("expected to pass, got %+v", check)
+	}
+	if check := checkContainers(taskDefinition, []string{"web"}); check.Status != CheckFailed {
+		t.Fatalf("expected to fail, got %+v", check)
+	}
+	if check := checkContainers(taskDefinition, nil); check.Status != CheckSkipped {
+		t.Fatalf("expected to be skipped, got %+v", check)
+	}
+}
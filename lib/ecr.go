@@ -1,25 +1,26 @@
 package lib
 
 import (
+	"context"
 	"encoding/base64"
 	"fmt"
 	"strings"
 
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/service/ecr"
-	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
 )
 
 // EcrLogin prints login cmd for docker
-func EcrLogin(profile string) (err error) {
-	err = makeSession(profile)
+func EcrLogin(ctx context.Context, profile string) (err error) {
+	err = makeConfig(ctx, profile)
 	if err != nil {
 		return err
 	}
-	svc := ecr.New(localSession)
+	svc := ecr.NewFromConfig(localConfig)
 	input := &ecr.GetAuthorizationTokenInput{}
 
-	result, err := svc.GetAuthorizationToken(input)
+	result, err := svc.GetAuthorizationToken(ctx, input)
 	if err != nil {
 		return err
 	}
@@ -27,7 +28,7 @@ func EcrLogin(profile string) (err error) {
 		return fmt.Errorf("Got %d authorizations instead of one", n)
 	}
 	auth := result.AuthorizationData[0]
-	decodedToken, err := base64.StdEncoding.DecodeString(aws.StringValue(auth.AuthorizationToken))
+	decodedToken, err := base64.StdEncoding.DecodeString(aws.ToString(auth.AuthorizationToken))
 	if err != nil {
 		return err
 	}
@@ -43,29 +44,29 @@ func EcrLogin(profile string) (err error) {
 		userPass[0],
 		"-p",
 		userPass[1],
-		aws.StringValue(auth.ProxyEndpoint),
+		aws.ToString(auth.ProxyEndpoint),
 	}, " "))
 
 	return nil
 }
 
 // EcrEndpoint prints endpoint for docker
-func EcrEndpoint(profile string) (err error) {
-	err = makeSession(profile)
+func EcrEndpoint(ctx context.Context, profile string) (err error) {
+	err = makeConfig(ctx, profile)
 	if err != nil {
 		return err
 	}
-	svc := sts.New(localSession)
+	svc := sts.NewFromConfig(localConfig)
 	input := &sts.GetCallerIdentityInput{}
-	result, err := svc.GetCallerIdentity(input)
+	result, err := svc.GetCallerIdentity(ctx, input)
 	if err != nil {
 		return err
 	}
 
 	fmt.Println(strings.Join([]string{
-		aws.StringValue(result.Account),
+		aws.ToString(result.Account),
 		"dkr.ecr",
-		aws.StringValue(localSession.Config.Region),
+		localConfig.Region,
 		"amazonaws.com",
 	}, "."))
 
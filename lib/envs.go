@@ -0,0 +1,168 @@
+package lib
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// DefaultConfigPaths are the directories searched for environment configs, from the current directory up
+var DefaultConfigPaths = []string{"infra"}
+
+// DefaultConfigPatterns are the environment config file names, {env} is the environment name
+var DefaultConfigPatterns = []string{"ecs-{env}.toml", "ecs-{env}.yaml", "ecs-{env}.yml", "ecs-{env}.json"}
+
+// Environment is an environment config found by FindEnvironments
+type Environment struct {
+	Name string `json:"name"`
+	File string `json:"file"`
+}
+
+// ConfigPattern matches environment config file names
+type ConfigPattern struct {
+	pattern string
+	re      *regexp.Regexp
+}
+
+// NewConfigPattern parses a file name pattern like ecs-{env}.toml
+func NewConfigPattern(pattern string) (ConfigPattern, error) {
+	if strings.Count(pattern, "{env}") != 1 || strings.ContainsRune(pattern, filepath.Separator) {
+		return ConfigPattern{}, fmt.Errorf("config pattern %q should be a file name with one {env}", pattern)
+	}
+	parts := strings.SplitN(pattern, "{env}", 2)
+	re := regexp.MustCompile("^" + regexp.QuoteMeta(parts[0]) + `(\w[\w.-]*)` + regexp.QuoteMeta(parts[1]) + "$")
+	return ConfigPattern{pattern: pattern, re: re}, nil
+}
+
+// File returns the file name of the environment
+func (p ConfigPattern) File(environment string) string {
+	return strings.Replace(p.pattern, "{env}", environment, 1)
+}
+
+// Match returns the environment name if the file name matches
+func (p ConfigPattern) Match(file string) (string, bool) {
+	match := p.re.FindStringSubmatch(filepath.Base(file))
+	if match == nil {
+		return "", false
+	}
+	return match[1], true
+}
+
+// Base returns the name of the config shared by the environments, i.e. ecs.toml for ecs-{env}.toml
+func (p ConfigPattern) Base() string {
+	base := p.pattern
+	for _, sep := range []string{"-{env}", "_{env}", ".{env}", "{env}-", "{env}_", "{env}."} {
+		base = strings.Replace(base, sep, "", 1)
+	}
+	return strings.Replace(base, "{env}", "", 1)
+}
+
+// ConfigPatterns parses the patterns
+func ConfigPatterns(patterns []string) ([]ConfigPattern, error) {
+	var parsed []ConfigPattern
+	for _, pattern := range patterns {
+		p, err := NewConfigPattern(pattern)
+		if err != nil {
+			return nil, err
+		}
+		parsed = append(parsed, p)
+	}
+	return parsed, nil
+}
+
+// MatchConfigPattern returns the environment name and the pattern the file name matches
+func MatchConfigPattern(patterns []ConfigPattern, file string) (string, ConfigPattern, bool) {
+	for _, pattern := range patterns {
+		if name, ok := pattern.Match(file); ok {
+			return name, pattern, true
+		}
+	}
+	return "", ConfigPattern{}, false
+}
+
+// configDirs finds the directories matching the path, walking up from dir until some of them have configs.
+// The path can be a glob like services/*/infra
+func configDirs(dir, path string, patterns []ConfigPattern) []string {
+	if filepath.IsAbs(path) {
+		dirs, _ := filepath.Glob(path)
+		return dirs
+	}
+	for {
+		candidates, _ := filepath.Glob(filepath.Join(dir, path))
+		var dirs []string
+		for _, candidate := range candidates {
+			if stat, err := os.Stat(candidate); err == nil && stat.IsDir() && len(environmentsIn(candidate, patterns)) > 0 {
+				dirs = append(dirs, candidate)
+			}
+		}
+		if len(dirs) > 0 || dir == filepath.Dir(dir) {
+			return dirs
+		}
+		dir = filepath.Dir(dir)
+	}
+}
+
+func environmentsIn(dir string, patterns []ConfigPattern) []Environment {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	var envs []Environment
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		if name, _, ok := MatchConfigPattern(patterns, entry.Name()); ok {
+			envs = append(envs, Environment{Name: name, File: filepath.Join(dir, entry.Name())})
+		}
+	}
+	return envs
+}
+
+// FindEnvironments finds the environment configs in the paths, looked up from dir, sorted by name.
+// The same environment can be found in several directories, i.e. in a monorepo
+func FindEnvironments(dir string, paths []string, patterns []ConfigPattern) ([]Environment, error) {
+	seen := make(map[string]bool)
+	var envs []Environment
+	for _, path := range paths {
+		for _, configDir := range configDirs(dir, path, patterns) {
+			for _, env := range environmentsIn(configDir, patterns) {
+				if !seen[env.File] {
+					seen[env.File] = true
+					envs = append(envs, env)
+				}
+			}
+		}
+	}
+	if len(envs) == 0 {
+		return nil, fmt.Errorf("can't find any environment in %s", strings.Join(paths, ", "))
+	}
+	sort.SliceStable(envs, func(i, j int) bool {
+		return envs[i].Name < envs[j].Name
+	})
+	return envs, nil
+}
+
+// FindEnvironment finds the config of the environment, which has to be unique
+func FindEnvironment(dir string, paths []string, patterns []ConfigPattern, environment string) (string, error) {
+	envs, err := FindEnvironments(dir, paths, patterns)
+	if err != nil {
+		return "", err
+	}
+	var files []string
+	for _, env := range envs {
+		if env.Name == environment {
+			files = append(files, env.File)
+		}
+	}
+	switch len(files) {
+	case 0:
+		return "", fmt.Errorf("there's no config for the %s environment", environment)
+	case 1:
+		return files[0], nil
+	}
+	return "", fmt.Errorf("the %s environment has several configs, pick one with --config: %s", environment, strings.Join(files, ", "))
+}
@@ -0,0 +1,78 @@
+package lib
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestConfigPattern(t *testing.T) {
+	pattern, err := NewConfigPattern("ecs-{env}.toml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name, ok := pattern.Match("infra/ecs-pr-12.toml"); !ok || name != "pr-12" {
+		t.Fatalf("expected pr-12, got %q", name)
+	}
+	for _, file := range []string{"ecs.toml", "ecs-prod.yaml", "ecs-.toml"} {
+		if _, ok := pattern.Match(file); ok {
+			t.Fatalf("%s shouldn't match", file)
+		}
+	}
+	if pattern.Base() != "ecs.toml" || pattern.File("prod") != "ecs-prod.toml" {
+		t.Fatalf("unexpected base %s or file %s", pattern.Base(), pattern.File("prod"))
+	}
+	if _, err := NewConfigPattern("ecs.toml"); err == nil {
+		t.Fatal("patterns without {env} should be errors")
+	}
+}
+
+func TestFindEnvironments(t *testing.T) {
+	root := t.TempDir()
+	for _, file := range []string{
+		"services/api/infra/ecs-prod.toml",
+		"services/api/infra/ecs.toml",
+		"services/web/infra/ecs-staging.yaml",
+		"services/web/infra/ecs-prod.json",
+		"services/web/infra/notes.txt",
+	} {
+		path := filepath.Join(root, file)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	patterns, err := ConfigPatterns(DefaultConfigPatterns)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// walks up from the service directory to its own infra
+	envs, err := FindEnvironments(filepath.Join(root, "services/web"), DefaultConfigPaths, patterns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(envs) != 2 || envs[0].Name != "prod" || envs[1].Name != "staging" {
+		t.Fatalf("unexpected %v", envs)
+	}
+
+	envs, err = FindEnvironments(root, []string{"services/*/infra"}, patterns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(envs) != 3 {
+		t.Fatalf("expected environments from both services, got %v", envs)
+	}
+	if _, err := FindEnvironment(root, []string{"services/*/infra"}, patterns, "prod"); err == nil {
+		t.Fatal("prod is in both services, it should be ambiguous")
+	}
+	if file, err := FindEnvironment(root, []string{"services/*/infra"}, patterns, "staging"); err != nil || filepath.Base(file) != "ecs-staging.yaml" {
+		t.Fatalf("unexpected %s %v", file, err)
+	}
+
+	if _, err := FindEnvironments(root, DefaultConfigPaths, patterns); err == nil {
+		t.Fatal("there's no infra directory above the root")
+	}
+}
@@ -0,0 +1,66 @@
+package lib
+
+import (
+	"context"
+	"errors"
+)
+
+// The errors returned by lib are wrapping these, so they can be told apart with errors.Is
+var (
+	// ErrContainerNotFound means the task definition has no container with the given name
+	ErrContainerNotFound = errors.New("can't find container with specified name in the task definition")
+	// ErrNoTaskStarted means ECS couldn't place the task, usually because the cluster lacks resources
+	ErrNoTaskStarted = errors.New("no tasks could be run")
+	// ErrTaskNotStopped means the task didn't stop in time or couldn't be waited for
+	ErrTaskNotStopped = errors.New("the task didn't stop")
+	// ErrContainerNotRun means the container stopped without an exit code, i.e. the image couldn't be pulled
+	ErrContainerNotRun = errors.New("the container didn't run")
+	// ErrLogsUnavailable means the task ran but its output couldn't be read
+	ErrLogsUnavailable = errors.New("can't fetch the logs")
+	// ErrTaskNotFound means there is no running task to connect to
+	ErrTaskNotFound = errors.New("can't find a running task")
+	// ErrAmbiguousTarget means there is more than one container to connect to and nobody to ask which one
+	ErrAmbiguousTarget = errors.New("more than one container matches")
+	// ErrDeployFailed means at least one of the services failed to deploy and the deploy was rolled back
+	ErrDeployFailed = errors.New("the deploy failed")
+	// ErrThrottled means AWS kept throttling the calls, nothing has been rolled back
+	ErrThrottled = errors.New("AWS throttled the calls")
+)
+
+// ExitCodes are the exit codes of ecs-tool and their reasons, as documented in the README.
+// They don't change between releases, so that scripts can rely on them
+var ExitCodes = map[int]string{
+	0:   "ok",
+	1:   "tool_error",        // bad config or flags, failed AWS calls
+	3:   "timeout",           // the task didn't stop or the service didn't become stable in time
+	4:   "container_failed",  // the command exited with a non-zero code
+	10:  "logs_unavailable",  // the command ran but its output couldn't be fetched
+	11:  "container_not_run", // the container stopped without running, i.e. the image couldn't be pulled
+	12:  "task_not_placed",   // ECS couldn't place the task, i.e. the cluster lacks resources
+	75:  "throttled",         // AWS kept throttling the calls, nothing has been rolled back
+	127: "deploy_failed",     // a service failed to deploy and the services have been rolled back
+	130: "interrupted",       // Ctrl-C or SIGTERM
+}
+
+// ExitCode returns the exit code of the error. A timeout wrapped in ErrDeployFailed is still a timeout
+func ExitCode(err error) int {
+	switch {
+	case err == nil:
+		return 0
+	case errors.Is(err, context.Canceled):
+		return 130
+	case errors.Is(err, ErrTaskNotStopped), errors.Is(err, context.DeadlineExceeded):
+		return 3
+	case errors.Is(err, ErrThrottled):
+		return 75
+	case errors.Is(err, ErrDeployFailed):
+		return 127
+	case errors.Is(err, ErrLogsUnavailable):
+		return 10
+	case errors.Is(err, ErrContainerNotRun):
+		return 11
+	case errors.Is(err, ErrNoTaskStarted):
+		return 12
+	}
+	return 1
+}
@@ -0,0 +1,34 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestExitCode(t *testing.T) {
+	for err, expected := range map[error]int{
+		nil:                      0,
+		errors.New("bad flag"):   1,
+		context.Canceled:         130,
+		ErrNoTaskStarted:         12,
+		ErrContainerNotRun:       11,
+		ErrLogsUnavailable:       10,
+		ErrThrottled:             75,
+		ErrDeployFailed:          127,
+		context.DeadlineExceeded: 3,
+		// the deploy is rolled back after a timeout, it's still a timeout
+		fmt.Errorf("%w: %w", ErrDeployFailed, context.DeadlineExceeded): 3,
+		fmt.Errorf("%w: %w", ErrDeployFailed, ErrTaskNotStopped):        3,
+		fmt.Errorf("%w: %w", ErrDeployFailed, ErrThrottled):             75,
+		fmt.Errorf("%w: %w", ErrTaskNotStopped, context.Canceled):       130,
+	} {
+		if code := ExitCode(err); code != expected {
+			t.Errorf("%v should exit with %d, got %d", err, expected, code)
+		}
+		if _, ok := ExitCodes[expected]; !ok {
+			t.Errorf("exit code %d has no reason", expected)
+		}
+	}
+}
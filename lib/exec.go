@@ -4,15 +4,12 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"strconv"
 	"strings"
 
 	"github.com/apex/log"
-	awsv2 "github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/config"
-	ecsv2 "github.com/aws/aws-sdk-go-v2/service/ecs"
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/arn"
-	ecsv1 "github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
 	"github.com/fujiwara/ecsta"
 )
 
@@ -24,113 +21,66 @@ const (
 	ErrForkExec    = "fork/exec"
 )
 
-var sessionInstance *ecsv2.Client
-var sessionConfig awsv2.Config // Variable for session configuration
-
-// InitAWS initializes a new AWS session with the specified profile for Ecsta realization
-func InitAWS(profile string) error {
-	if sessionInstance == nil {
-		cfg, err := config.LoadDefaultConfig(context.TODO(),
-			config.WithSharedConfigProfile(profile),
-		)
-		if err != nil {
-			return fmt.Errorf("failed to load configuration: %w", err)
+// exportCredentials passes the region, the credentials, the retries and the endpoints to ecsta, which loads its own config
+// where the environment credentials take precedence over the profile. ecsta doesn't take an aws.Config and its clients
+// are unexported, so the environment is set only while ecsta.New loads the config and restored by the returned func,
+// not to leak the credentials into the commands started afterwards
+func exportCredentials(ctx context.Context) (restore func(), err error) {
+	creds, err := localConfig.Credentials.Retrieve(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get credentials: %w", err)
+	}
+	env := map[string]string{
+		"AWS_REGION":            localConfig.Region,
+		"AWS_ACCESS_KEY_ID":     creds.AccessKeyID,
+		"AWS_SECRET_ACCESS_KEY": creds.SecretAccessKey,
+		"AWS_SESSION_TOKEN":     creds.SessionToken,
+		"AWS_RETRY_MODE":        "adaptive",
+		"AWS_MAX_ATTEMPTS":      strconv.Itoa(localConfig.Retryer().MaxAttempts()),
+	}
+	if awsConfig.EndpointURL != "" {
+		env["AWS_ENDPOINT_URL"] = awsConfig.EndpointURL
+	}
+	for name, url := range awsConfig.Endpoints {
+		if id, ok := EndpointServices[strings.ToLower(name)]; ok && url != "" {
+			env["AWS_ENDPOINT_URL_"+strings.ToUpper(strings.ReplaceAll(id, " ", "_"))] = url
 		}
-		os.Setenv("AWS_PROFILE", profile) //required for aws sdk
-		sessionInstance = ecsv2.NewFromConfig(cfg)
-		sessionConfig = cfg // Save session configuration
 	}
-	return nil
+	return setEnv(env), nil
 }
 
-// getTaskDefinitionFromTaskID gets the task definition ARN from a task ID and extracts the family name
-func getTaskDefinitionFromTaskID(profile, cluster, taskID string) (taskDefinitionName string, err error) {
-	err = makeSession(profile)
-	if err != nil {
-		return "", fmt.Errorf("failed to create session: %w", err)
-	}
-
-	svc := ecsv1.New(localSession)
-
-	// List tasks to find the one matching the task ID
-	listResult, err := svc.ListTasks(&ecsv1.ListTasksInput{
-		Cluster: aws.String(cluster),
-	})
-	if err != nil {
-		return "", fmt.Errorf("failed to list tasks: %w", err)
-	}
-
-	if len(listResult.TaskArns) == 0 {
-		return "", fmt.Errorf("no tasks found in cluster")
+// setEnv sets the environment variables and returns a func putting the previous values back
+func setEnv(env map[string]string) func() {
+	previous := make(map[string]*string)
+	for name, value := range env {
+		if old, ok := os.LookupEnv(name); ok {
+			previous[name] = &old
+		} else {
+			previous[name] = nil
+		}
+		os.Setenv(name, value)
 	}
-
-	// Find task that matches the task ID (task ID is usually a prefix of the full ARN)
-	var matchingTaskArn *string
-	for _, taskArn := range listResult.TaskArns {
-		taskArnStr := aws.StringValue(taskArn)
-		// Task ID is usually the last part of the ARN after the last /
-		parts := strings.Split(taskArnStr, "/")
-		if len(parts) > 0 {
-			taskArnID := parts[len(parts)-1]
-			// Check if task ID matches (task ID is always a prefix of the ARN ID)
-			if strings.HasPrefix(taskArnID, taskID) {
-				matchingTaskArn = taskArn
-				break
+	return func() {
+		for name, old := range previous {
+			if old == nil {
+				os.Unsetenv(name)
+			} else {
+				os.Setenv(name, *old)
 			}
 		}
 	}
-
-	if matchingTaskArn == nil {
-		return "", fmt.Errorf("task ID %s not found in cluster", taskID)
-	}
-
-	// Describe the task to get task definition ARN
-	describeResult, err := svc.DescribeTasks(&ecsv1.DescribeTasksInput{
-		Cluster: aws.String(cluster),
-		Tasks:   []*string{matchingTaskArn},
-	})
-	if err != nil {
-		return "", fmt.Errorf("failed to describe task: %w", err)
-	}
-
-	if len(describeResult.Tasks) == 0 {
-		return "", fmt.Errorf("task not found")
-	}
-
-	taskDefinitionArn := aws.StringValue(describeResult.Tasks[0].TaskDefinitionArn)
-
-	// Extract task definition family name from ARN using proper ARN parsing
-	// ARN format: arn:aws:ecs:region:account:task-definition/family:revision
-	parsed, err := arn.Parse(taskDefinitionArn)
-	if err != nil {
-		return "", fmt.Errorf("invalid task definition ARN: %w", err)
-	}
-
-	// parsed.Resource == "task-definition/family:revision"
-	parts := strings.Split(parsed.Resource, "/")
-	if len(parts) != 2 {
-		return "", fmt.Errorf("invalid task definition ARN format: %s", taskDefinitionArn)
-	}
-
-	// Get family:revision and extract just the family name
-	familyRevision := parts[1]
-	familyParts := strings.Split(familyRevision, ":")
-	taskDefinitionName = familyParts[0]
-
-	return taskDefinitionName, nil
 }
 
 // extractEntrypointFromTaskDefinition extracts ssm-parent entrypoint and config from task definition
-func extractEntrypointFromTaskDefinition(profile, cluster, taskDefinitionName, containerName string) (entrypoint string, configPath string, err error) {
-	// Use AWS SDK v1 for compatibility with existing code
-	err = makeSession(profile)
+func extractEntrypointFromTaskDefinition(ctx context.Context, profile, cluster, taskDefinitionName, containerName string) (entrypoint string, configPath string, err error) {
+	err = makeConfig(ctx, profile)
 	if err != nil {
-		return "", "", fmt.Errorf("failed to create session: %w", err)
+		return "", "", err
 	}
 
-	svc := ecsv1.New(localSession)
+	svc := ecs.NewFromConfig(localConfig)
 
-	describeResult, err := svc.DescribeTaskDefinition(&ecsv1.DescribeTaskDefinitionInput{
+	describeResult, err := svc.DescribeTaskDefinition(ctx, &ecs.DescribeTaskDefinitionInput{
 		TaskDefinition: aws.String(taskDefinitionName),
 	})
 	if err != nil {
@@ -139,17 +89,17 @@ func extractEntrypointFromTaskDefinition(profile, cluster, taskDefinitionName, c
 
 	// Find the container definition
 	for _, containerDef := range describeResult.TaskDefinition.ContainerDefinitions {
-		if aws.StringValue(containerDef.Name) == containerName {
+		if aws.ToString(containerDef.Name) == containerName {
 			// Check EntryPoint field
 			if len(containerDef.EntryPoint) > 0 {
 				// EntryPoint is typically: ["/sbin/ssm-parent", "run", "-e", "-p", "...", "--", "su-exec", "www"]
 				// We want to extract the ssm-parent path (first element) and config if present
-				entrypoint = aws.StringValue(containerDef.EntryPoint[0])
+				entrypoint = containerDef.EntryPoint[0]
 
 				// Look for -c flag in EntryPoint to find config path
 				for i, arg := range containerDef.EntryPoint {
-					if i > 0 && aws.StringValue(arg) == "-c" && i+1 < len(containerDef.EntryPoint) {
-						configPath = aws.StringValue(containerDef.EntryPoint[i+1])
+					if i > 0 && arg == "-c" && i+1 < len(containerDef.EntryPoint) {
+						configPath = containerDef.EntryPoint[i+1]
 						break
 					}
 				}
@@ -178,14 +128,14 @@ type execResult struct {
 
 // determineSSMParentConfig extracts entrypoint and config from task definition
 // and determines the appropriate ssm-parent configuration
-func determineSSMParentConfig(profile, cluster, taskDefinitionName, containerName string) SSMParentConfig {
+func determineSSMParentConfig(ctx context.Context, profile, cluster, taskDefinitionName, containerName string) SSMParentConfig {
 	config := SSMParentConfig{
 		EntrypointPaths: []string{},
 		ConfigPaths:     []string{},
 	}
 
 	if taskDefinitionName != "" && containerName != "" {
-		extractedEntrypoint, extractedConfig, err := extractEntrypointFromTaskDefinition(profile, cluster, taskDefinitionName, containerName)
+		extractedEntrypoint, extractedConfig, err := extractEntrypointFromTaskDefinition(ctx, profile, cluster, taskDefinitionName, containerName)
 		if err == nil {
 			config.ExtractionSucceeded = true
 			// Only try the extracted entrypoint - don't try unknown fallback paths
@@ -251,33 +201,17 @@ func isEntrypointNotFoundError(err error) bool {
 		strings.Contains(errMsg, ErrForkExec)
 }
 
-// resolveTaskDefinitionName extracts task definition name from task ID if needed
-func resolveTaskDefinitionName(cfg ExecConfig) string {
-	taskDefinitionName := cfg.TaskDefinitionName
-	if cfg.TaskID != "" && taskDefinitionName == "" {
-		extractedTaskDef, err := getTaskDefinitionFromTaskID(cfg.Profile, cfg.Cluster, cfg.TaskID)
-		if err == nil {
-			taskDefinitionName = extractedTaskDef
-			log.WithFields(log.Fields{
-				"task_id":         cfg.TaskID,
-				"task_definition": taskDefinitionName,
-			}).Debug("Extracted task definition from task ID")
-		} else {
-			log.WithError(err).Debug("Could not extract task definition from task ID, will use path fallback")
-		}
-	}
-	return taskDefinitionName
-}
-
 // createEcstaApp creates and initializes the ecsta application
-func createEcstaApp(cfg ExecConfig) (*ecsta.Ecsta, error) {
-	if err := InitAWS(cfg.Profile); err != nil {
-		return nil, fmt.Errorf("failed to initialize AWS session: %w", err)
+func createEcstaApp(ctx context.Context, cfg ExecConfig) (*ecsta.Ecsta, error) {
+	if err := makeConfig(ctx, cfg.Profile); err != nil {
+		return nil, err
 	}
-
-	// Get region from session config (already a string in awsv2.Config)
-	regionStr := sessionConfig.Region
-	ecstaApp, err := ecsta.New(context.TODO(), regionStr, cfg.Cluster)
+	restore, err := exportCredentials(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ecstaApp, err := ecsta.New(ctx, localConfig.Region, cfg.Cluster)
+	restore()
 	if err != nil {
 		return nil, fmt.Errorf("failed to create ecsta application: %w", err)
 	}
@@ -285,7 +219,7 @@ func createEcstaApp(cfg ExecConfig) (*ecsta.Ecsta, error) {
 }
 
 // trySSMParentWithConfig attempts to execute command using ssm-parent with -c flag
-func trySSMParentWithConfig(ecstaApp *ecsta.Ecsta, entrypoint string, configPaths []string, command string) execResult {
+func trySSMParentWithConfig(ctx context.Context, ecstaApp *ecsta.Ecsta, target *Target, entrypoint string, configPaths []string, command string) execResult {
 	for _, configPath := range configPaths {
 		if configPath == "" {
 			continue // Skip empty config path when trying -c format
@@ -294,30 +228,32 @@ func trySSMParentWithConfig(ecstaApp *ecsta.Ecsta, entrypoint string, configPath
 		fullCommand := fmt.Sprintf("%s -c %s run -- %s", entrypoint, configPath, command)
 
 		execOpt := ecsta.ExecOption{
-			Command: fullCommand,
+			ID:        target.TaskID,
+			Container: target.Container,
+			Command:   fullCommand,
 		}
 
-		ctx := log.WithFields(log.Fields{
+		logger := log.WithFields(log.Fields{
 			"entrypoint":  entrypoint,
 			"config_path": configPath,
 			"format":      "with -c flag",
 			"command":     fullCommand,
 		})
-		ctx.Debug("Attempting to execute command with ssm-parent (-c flag format)")
+		logger.Debug("Attempting to execute command with ssm-parent (-c flag format)")
 
-		if err := ecstaApp.RunExec(context.Background(), &execOpt); err != nil {
+		if err := ecstaApp.RunExec(ctx, &execOpt); err != nil {
 			// If we get "unknown shorthand flag", this ssm-parent doesn't support -c flag
 			if isUnknownFlagError(err) {
-				ctx.WithError(err).Debug("ssm-parent doesn't support -c flag, skipping remaining configs and will try without -c")
+				logger.WithError(err).Debug("ssm-parent doesn't support -c flag, skipping remaining configs and will try without -c")
 				return execResult{succeeded: false, err: err}
 			}
 			// If entrypoint not found, break config loop and move to next entrypoint
 			if isEntrypointNotFoundError(err) {
-				ctx.WithError(err).Debug("entrypoint not found, breaking config loop")
+				logger.WithError(err).Debug("entrypoint not found, breaking config loop")
 				return execResult{succeeded: false, err: err}
 			}
 			// Other errors: try without -c flag for this entrypoint
-			ctx.WithError(err).Debug("ssm-parent -c format failed with other error, will try without -c")
+			logger.WithError(err).Debug("ssm-parent -c format failed with other error, will try without -c")
 			return execResult{succeeded: false, err: err}
 		}
 
@@ -331,28 +267,30 @@ func trySSMParentWithConfig(ecstaApp *ecsta.Ecsta, entrypoint string, configPath
 }
 
 // trySSMParentWithoutConfig attempts to execute command using ssm-parent without -c flag
-func trySSMParentWithoutConfig(ecstaApp *ecsta.Ecsta, entrypoint string, command string) execResult {
+func trySSMParentWithoutConfig(ctx context.Context, ecstaApp *ecsta.Ecsta, target *Target, entrypoint string, command string) execResult {
 	fullCommand := fmt.Sprintf("%s run -- %s", entrypoint, command)
 
 	execOpt := ecsta.ExecOption{
-		Command: fullCommand,
+		ID:        target.TaskID,
+		Container: target.Container,
+		Command:   fullCommand,
 	}
 
-	ctx := log.WithFields(log.Fields{
+	logger := log.WithFields(log.Fields{
 		"entrypoint": entrypoint,
 		"format":     "without -c flag",
 		"command":    fullCommand,
 	})
-	ctx.Debug("Attempting to execute command with ssm-parent (simple format)")
+	logger.Debug("Attempting to execute command with ssm-parent (simple format)")
 
-	if err := ecstaApp.RunExec(context.Background(), &execOpt); err != nil {
+	if err := ecstaApp.RunExec(ctx, &execOpt); err != nil {
 		// If entrypoint not found, try next entrypoint path
 		if isEntrypointNotFoundError(err) {
-			ctx.WithError(err).Debug("ssm-parent not found, trying next entrypoint path")
+			logger.WithError(err).Debug("ssm-parent not found, trying next entrypoint path")
 			return execResult{succeeded: false, err: err}
 		}
 		// Other errors: also try next entrypoint
-		ctx.WithError(err).Debug("ssm-parent execution failed, trying next entrypoint")
+		logger.WithError(err).Debug("ssm-parent execution failed, trying next entrypoint")
 		return execResult{succeeded: false, err: err}
 	}
 
@@ -362,7 +300,7 @@ func trySSMParentWithoutConfig(ecstaApp *ecsta.Ecsta, entrypoint string, command
 }
 
 // trySSMParent attempts to execute command using ssm-parent with all available entrypoints
-func trySSMParent(ecstaApp *ecsta.Ecsta, ssmConfig SSMParentConfig, command string) execResult {
+func trySSMParent(ctx context.Context, ecstaApp *ecsta.Ecsta, target *Target, ssmConfig SSMParentConfig, command string) execResult {
 	entrypointPaths := ssmConfig.EntrypointPaths
 	configPaths := ssmConfig.ConfigPaths
 
@@ -378,14 +316,14 @@ func trySSMParent(ecstaApp *ecsta.Ecsta, ssmConfig SSMParentConfig, command stri
 
 		// First, try with -c flag format if config paths are available
 		if len(configPaths) > 0 {
-			result := trySSMParentWithConfig(ecstaApp, entrypoint, configPaths, command)
+			result := trySSMParentWithConfig(ctx, ecstaApp, target, entrypoint, configPaths, command)
 			if result.succeeded {
 				return result
 			}
 			// If unknown flag error, skip remaining configs and try without -c
 			if result.err != nil && isUnknownFlagError(result.err) {
 				// Try without -c flag for this entrypoint
-				result = trySSMParentWithoutConfig(ecstaApp, entrypoint, command)
+				result = trySSMParentWithoutConfig(ctx, ecstaApp, target, entrypoint, command)
 				if result.succeeded {
 					return result
 				}
@@ -409,7 +347,7 @@ func trySSMParent(ecstaApp *ecsta.Ecsta, ssmConfig SSMParentConfig, command stri
 		}
 
 		// Try without -c flag format
-		result := trySSMParentWithoutConfig(ecstaApp, entrypoint, command)
+		result := trySSMParentWithoutConfig(ctx, ecstaApp, target, entrypoint, command)
 		if result.succeeded {
 			return result
 		}
@@ -431,7 +369,7 @@ func trySSMParent(ecstaApp *ecsta.Ecsta, ssmConfig SSMParentConfig, command stri
 }
 
 // tryDirectExecution attempts to execute command directly without ssm-parent
-func tryDirectExecution(ecstaApp *ecsta.Ecsta, command string, ssmTried, ssmSucceeded bool) execResult {
+func tryDirectExecution(ctx context.Context, ecstaApp *ecsta.Ecsta, target *Target, command string, ssmTried, ssmSucceeded bool) execResult {
 	// Log appropriate debug message
 	if ssmTried {
 		if ssmSucceeded {
@@ -444,10 +382,12 @@ func tryDirectExecution(ecstaApp *ecsta.Ecsta, command string, ssmTried, ssmSucc
 	}
 
 	execOpt := ecsta.ExecOption{
-		Command: command,
+		ID:        target.TaskID,
+		Container: target.Container,
+		Command:   command,
 	}
 
-	if err := ecstaApp.RunExec(context.Background(), &execOpt); err != nil {
+	if err := ecstaApp.RunExec(ctx, &execOpt); err != nil {
 		return execResult{succeeded: false, err: err}
 	}
 
@@ -485,28 +425,59 @@ type ExecConfig struct {
 	Profile            string
 	Cluster            string
 	Command            string
+	Service            string
 	TaskID             string
 	TaskDefinitionName string
 	ContainerName      string
+	// ContainerArg takes the first word of the command as the container, as in "exec app sh",
+	// if ContainerName isn't set and the task runs a container with that name
+	ContainerArg bool
+	// Choose picks the service, the task and the container if more than one matches, see TargetOptions
+	Choose Chooser
 }
 
 // ExecFargate executes a command in a specified container on an ECS Fargate service
-// taskID, taskDefinitionName and containerName are optional - if provided, will extract entrypoint from task definition
-func ExecFargate(cfg ExecConfig) error {
+// service, taskID, taskDefinitionName and containerName are optional - they narrow down the container to run the command in
+func ExecFargate(ctx context.Context, cfg ExecConfig) error {
 	// Setup
-	ecstaApp, err := createEcstaApp(cfg)
+	ecstaApp, err := createEcstaApp(ctx, cfg)
 	if err != nil {
 		return err
 	}
 
-	taskDefName := resolveTaskDefinitionName(cfg)
-	ssmConfig := determineSSMParentConfig(cfg.Profile, cfg.Cluster, taskDefName, cfg.ContainerName)
+	var containerArg, command string
+	if cfg.ContainerArg && cfg.ContainerName == "" {
+		if words := strings.SplitN(cfg.Command, " ", 2); len(words) == 2 {
+			containerArg, command = words[0], words[1]
+		}
+	}
+	target, err := ResolveTarget(ctx, TargetOptions{
+		Profile:        cfg.Profile,
+		Cluster:        cfg.Cluster,
+		Service:        cfg.Service,
+		TaskID:         cfg.TaskID,
+		TaskDefinition: cfg.TaskDefinitionName,
+		Container:      cfg.ContainerName,
+		ContainerHint:  containerArg,
+		Choose:         cfg.Choose,
+	})
+	if err != nil {
+		return err
+	}
+	if containerArg != "" && target.Container == containerArg {
+		cfg.Command = command
+	}
+	log.WithFields(log.Fields{
+		"task_id":   target.TaskID,
+		"container": target.Container,
+	}).Debug("Picked the container")
+	ssmConfig := determineSSMParentConfig(ctx, cfg.Profile, cfg.Cluster, target.TaskDefinitionArn, target.Container)
 
 	// Execute
-	ssmResult := trySSMParent(ecstaApp, ssmConfig, cfg.Command)
+	ssmResult := trySSMParent(ctx, ecstaApp, target, ssmConfig, cfg.Command)
 	ssmTried := len(ssmConfig.EntrypointPaths) > 0
 	ssmSucceeded := ssmResult.succeeded
-	directResult := tryDirectExecution(ecstaApp, cfg.Command, ssmTried, ssmSucceeded)
+	directResult := tryDirectExecution(ctx, ecstaApp, target, cfg.Command, ssmTried, ssmSucceeded)
 
 	// Handle results
 	return handleExecutionResults(ssmResult, directResult)
@@ -0,0 +1,23 @@
+package lib
+
+import (
+	"os"
+	"testing"
+)
+
+func TestSetEnv(t *testing.T) {
+	t.Setenv("ECS_TOOL_TEST_SET", "before")
+	os.Unsetenv("ECS_TOOL_TEST_UNSET")
+
+	restore := setEnv(map[string]string{"ECS_TOOL_TEST_SET": "during", "ECS_TOOL_TEST_UNSET": "during"})
+	if os.Getenv("ECS_TOOL_TEST_SET") != "during" || os.Getenv("ECS_TOOL_TEST_UNSET") != "during" {
+		t.Fatal("the variables should be set")
+	}
+	restore()
+	if value := os.Getenv("ECS_TOOL_TEST_SET"); value != "before" {
+		t.Fatalf("the previous value should be back, got %q", value)
+	}
+	if _, ok := os.LookupEnv("ECS_TOOL_TEST_UNSET"); ok {
+		t.Fatal("the variable should be unset again")
+	}
+}
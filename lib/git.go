@@ -0,0 +1,47 @@
+package lib
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// commitEnvVars are checked when git isn't available, i.e. in CI builds from a source tarball
+var commitEnvVars = []string{"GITHUB_SHA", "CI_COMMIT_SHA", "BITBUCKET_COMMIT", "CIRCLE_SHA1"}
+
+func gitOutput(args ...string) (string, error) {
+	out, err := exec.Command("git", args...).Output()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// GitSHA returns the commit the current directory is checked out at
+func GitSHA() string {
+	if sha, err := gitOutput("rev-parse", "HEAD"); err == nil {
+		return sha
+	}
+	for _, name := range commitEnvVars {
+		if sha := os.Getenv(name); sha != "" {
+			return sha
+		}
+	}
+	return ""
+}
+
+// branchEnvVars are checked when git isn't available or the checkout is detached, as it usually is in CI
+var branchEnvVars = []string{"GITHUB_HEAD_REF", "GITHUB_REF_NAME", "CI_COMMIT_REF_NAME", "BITBUCKET_BRANCH", "CIRCLE_BRANCH"}
+
+// GitBranch returns the branch the current directory is checked out at
+func GitBranch() string {
+	if branch, err := gitOutput("rev-parse", "--abbrev-ref", "HEAD"); err == nil && branch != "HEAD" {
+		return branch
+	}
+	for _, name := range branchEnvVars {
+		if branch := os.Getenv(name); branch != "" {
+			return branch
+		}
+	}
+	return ""
+}
@@ -0,0 +1,208 @@
+package lib
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/apex/log"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+)
+
+// describeServicesBatch is the maximum number of services DescribeServices accepts
+const describeServicesBatch = 10
+
+// ClusterService is what init needs to know about a service
+type ClusterService struct {
+	Name           string
+	TaskDefinition string // family
+	LaunchType     string
+	Containers     []string
+	// Container is the first essential container, the one commands are run in
+	Container string
+	LogGroups []string
+}
+
+// ClusterInspection is the result of InspectCluster
+type ClusterInspection struct {
+	Profile  string
+	Cluster  string
+	Services []ClusterService
+}
+
+// Service finds the service by name
+func (c *ClusterInspection) Service(name string) (ClusterService, bool) {
+	for _, service := range c.Services {
+		if service.Name == name {
+			return service, true
+		}
+	}
+	return ClusterService{}, false
+}
+
+// serviceLaunchType returns FARGATE or EC2, looking at the capacity providers if the launch type isn't set
+func serviceLaunchType(service types.Service) string {
+	if service.LaunchType != "" {
+		return string(service.LaunchType)
+	}
+	for _, strategy := range service.CapacityProviderStrategy {
+		if strings.HasPrefix(aws.ToString(strategy.CapacityProvider), string(types.LaunchTypeFargate)) {
+			return string(types.LaunchTypeFargate)
+		}
+	}
+	return string(types.LaunchTypeEc2)
+}
+
+// clusterService collects the container names and log groups of the task definition
+func clusterService(service types.Service, taskDefinition *types.TaskDefinition) ClusterService {
+	result := ClusterService{
+		Name:           aws.ToString(service.ServiceName),
+		TaskDefinition: aws.ToString(taskDefinition.Family),
+		LaunchType:     serviceLaunchType(service),
+	}
+	seen := make(map[string]bool)
+	for _, container := range taskDefinition.ContainerDefinitions {
+		name := aws.ToString(container.Name)
+		result.Containers = append(result.Containers, name)
+		if result.Container == "" && (container.Essential == nil || aws.ToBool(container.Essential)) {
+			result.Container = name
+		}
+		if config := container.LogConfiguration; config != nil && config.LogDriver == types.LogDriverAwslogs {
+			if group := config.Options["awslogs-group"]; group != "" && !seen[group] {
+				seen[group] = true
+				result.LogGroups = append(result.LogGroups, group)
+			}
+		}
+	}
+	return result
+}
+
+// InspectCluster collects the services of the cluster with their task definitions
+func InspectCluster(ctx context.Context, profile, cluster string) (*ClusterInspection, error) {
+	err := makeConfig(ctx, profile)
+	if err != nil {
+		return nil, err
+	}
+	logger := log.WithField("cluster", cluster)
+	svc := ecs.NewFromConfig(localConfig)
+
+	var serviceArns []string
+	paginator := ecs.NewListServicesPaginator(svc, &ecs.ListServicesInput{
+		Cluster: aws.String(cluster),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			logger.WithError(err).Error("Can't list services")
+			return nil, err
+		}
+		serviceArns = append(serviceArns, page.ServiceArns...)
+	}
+	if len(serviceArns) == 0 {
+		return nil, fmt.Errorf("cluster %s has no services", cluster)
+	}
+
+	inspection := &ClusterInspection{Profile: profile, Cluster: cluster}
+	taskDefinitions := make(map[string]*types.TaskDefinition)
+	for len(serviceArns) > 0 {
+		n := len(serviceArns)
+		if n > describeServicesBatch {
+			n = describeServicesBatch
+		}
+		describeResult, err := svc.DescribeServices(ctx, &ecs.DescribeServicesInput{
+			Cluster:  aws.String(cluster),
+			Services: serviceArns[:n],
+		})
+		if err != nil {
+			logger.WithError(err).Error("Can't describe services")
+			return nil, err
+		}
+		serviceArns = serviceArns[n:]
+
+		for _, service := range describeResult.Services {
+			arn := aws.ToString(service.TaskDefinition)
+			taskDefinition, ok := taskDefinitions[arn]
+			if !ok {
+				taskDefinitionResult, err := svc.DescribeTaskDefinition(ctx, &ecs.DescribeTaskDefinitionInput{
+					TaskDefinition: service.TaskDefinition,
+				})
+				if err != nil {
+					logger.WithError(err).WithField("task_definition", arn).Error("Can't get task definition")
+					return nil, err
+				}
+				taskDefinition = taskDefinitionResult.TaskDefinition
+				taskDefinitions[arn] = taskDefinition
+			}
+			inspection.Services = append(inspection.Services, clusterService(service, taskDefinition))
+		}
+	}
+	sort.Slice(inspection.Services, func(i, j int) bool {
+		return inspection.Services[i].Name < inspection.Services[j].Name
+	})
+	return inspection, nil
+}
+
+var initConfigTemplate = template.Must(template.New("init").Funcs(template.FuncMap{
+	"quote": strconv.Quote,
+	"quoteAll": func(values []string) string {
+		quoted := make([]string, len(values))
+		for n, value := range values {
+			quoted[n] = strconv.Quote(value)
+		}
+		return "[" + strings.Join(quoted, ", ") + "]"
+	},
+	"join": strings.Join,
+}).Parse(`# generated by "ecs-tool init" from the {{ .Cluster }} cluster, see example.toml for all the settings
+{{ if .Profile }}profile = {{ quote .Profile }} # AWS profile
+{{ else }}#profile = "default" # AWS profile
+{{ end -}}
+cluster = {{ quote .Cluster }} # name of ECS cluster
+task_definition = {{ quote .Main.TaskDefinition }} # name of the task definition
+container_name = {{ quote .Main.Container }} # name of the container
+{{- with .Main.LogGroups }}
+
+log_group = {{ quote (index . 0) }}
+{{- end }}
+
+[deploy]
+# services in the cluster:
+{{- range .Services }}
+#   {{ .Name }}: {{ .TaskDefinition }} ({{ .LaunchType }}), containers {{ join .Containers ", " }}
+{{- end }}
+services = {{ quoteAll .Deploy }}
+{{ if eq .Main.LaunchType "EC2" }}
+[ssh]
+shell = "sh"
+service = {{ quote .Main.Name }}
+container_name = {{ quote .Main.Container }}
+instance_user = "ec2-user"
+{{ end }}
+[run]
+service = {{ quote .Main.Name }} # Name of service to run one off task in
+launch_type = {{ quote .Main.LaunchType }}
+`))
+
+// RenderInitConfig writes a config for the cluster, with main as the service commands are run in
+func RenderInitConfig(inspection *ClusterInspection, main string) ([]byte, error) {
+	mainService, ok := inspection.Service(main)
+	if !ok {
+		return nil, fmt.Errorf("service %s isn't in the %s cluster", main, inspection.Cluster)
+	}
+	var deploy []string
+	for _, service := range inspection.Services {
+		deploy = append(deploy, service.Name)
+	}
+	out := new(bytes.Buffer)
+	err := initConfigTemplate.Execute(out, struct {
+		*ClusterInspection
+		Main   ClusterService
+		Deploy []string
+	}{inspection, mainService, deploy})
+	return out.Bytes(), err
+}
@@ -0,0 +1,76 @@
+package lib
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+)
+
+func TestClusterService(t *testing.T) {
+	service := clusterService(types.Service{
+		ServiceName: aws.String("web"),
+		CapacityProviderStrategy: []types.CapacityProviderStrategyItem{
+			{CapacityProvider: aws.String("FARGATE_SPOT")},
+		},
+	}, &types.TaskDefinition{
+		Family: aws.String("app-web"),
+		ContainerDefinitions: []types.ContainerDefinition{
+			{Name: aws.String("init"), Essential: aws.Bool(false)},
+			{Name: aws.String("app"), LogConfiguration: &types.LogConfiguration{
+				LogDriver: types.LogDriverAwslogs,
+				Options:   map[string]string{"awslogs-group": "app"},
+			}},
+			{Name: aws.String("nginx"), LogConfiguration: &types.LogConfiguration{
+				LogDriver: types.LogDriverAwslogs,
+				Options:   map[string]string{"awslogs-group": "app"},
+			}},
+		},
+	})
+	if service.LaunchType != "FARGATE" || service.Container != "app" || service.TaskDefinition != "app-web" {
+		t.Fatalf("unexpected %+v", service)
+	}
+	if len(service.Containers) != 3 || len(service.LogGroups) != 1 {
+		t.Fatalf("unexpected %+v", service)
+	}
+}
+
+func TestRenderInitConfig(t *testing.T) {
+	inspection := &ClusterInspection{
+		Cluster: "staging",
+		Services: []ClusterService{
+			{Name: "web", TaskDefinition: "app-web", LaunchType: "EC2", Containers: []string{"app"}, Container: "app", LogGroups: []string{"app"}},
+			{Name: "worker", TaskDefinition: "app-worker", LaunchType: "FARGATE", Containers: []string{"worker"}, Container: "worker"},
+		},
+	}
+	config, err := RenderInitConfig(inspection, "web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{
+		`cluster = "staging"`,
+		`task_definition = "app-web"`,
+		`container_name = "app"`,
+		`log_group = "app"`,
+		`services = ["web", "worker"]`,
+		"[ssh]",
+		`launch_type = "EC2"`,
+	} {
+		if !strings.Contains(string(config), expected) {
+			t.Fatalf("expected %q in\n%s", expected, config)
+		}
+	}
+
+	config, err = RenderInitConfig(inspection, "worker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(config), "[ssh]") || strings.Contains(string(config), "log_group") {
+		t.Fatalf("ssh and log_group shouldn't be set for the worker\n%s", config)
+	}
+
+	if _, err := RenderInitConfig(inspection, "missing"); err == nil {
+		t.Fatal("expected an error for a missing service")
+	}
+}
@@ -0,0 +1,93 @@
+package lib
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// interpolationPattern matches ${name} and the $$ escape
+var interpolationPattern = regexp.MustCompile(`\$\$|\$\{([^}]*)\}`)
+
+// interpolate replaces ${name} with the variables, $$ is a literal $.
+// Undefined variables are errors
+func interpolate(value string, lookup func(name string) (string, bool)) (string, error) {
+	var err error
+	result := interpolationPattern.ReplaceAllStringFunc(value, func(match string) string {
+		if match == "$$" {
+			return "$"
+		}
+		name := strings.TrimSpace(match[2 : len(match)-1])
+		resolved, ok := lookup(name)
+		if !ok && err == nil {
+			err = fmt.Errorf("%s is undefined", match)
+		}
+		return resolved
+	})
+	return result, err
+}
+
+// InterpolateConfig replaces the variables in all the strings of the settings, including lists and tables
+func InterpolateConfig(settings map[string]interface{}, lookup func(name string) (string, bool)) error {
+	var walk func(path string, value interface{}) (interface{}, error)
+	walk = func(path string, value interface{}) (interface{}, error) {
+		switch v := value.(type) {
+		case string:
+			resolved, err := interpolate(v, lookup)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", path, err)
+			}
+			return resolved, nil
+		case map[string]interface{}:
+			for key, item := range v {
+				resolved, err := walk(joinPath(path, key), item)
+				if err != nil {
+					return nil, err
+				}
+				v[key] = resolved
+			}
+		case []interface{}:
+			for n, item := range v {
+				resolved, err := walk(fmt.Sprintf("%s[%d]", path, n), item)
+				if err != nil {
+					return nil, err
+				}
+				v[n] = resolved
+			}
+		case []map[string]interface{}:
+			for n, item := range v {
+				if _, err := walk(fmt.Sprintf("%s[%d]", path, n), item); err != nil {
+					return nil, err
+				}
+			}
+		}
+		return value, nil
+	}
+	_, err := walk("", settings)
+	return err
+}
+
+// ConfigVariables looks up ${env}, ${git.sha}, ${git.branch} and environment variables.
+// git is only run if the config refers to it, and only once
+func ConfigVariables(environment string) func(name string) (string, bool) {
+	git := map[string]func() string{
+		"git.sha":    GitSHA,
+		"git.branch": GitBranch,
+	}
+	resolved := make(map[string]string)
+	return func(name string) (string, bool) {
+		if name == "env" {
+			return environment, environment != ""
+		}
+		if get, ok := git[name]; ok {
+			value, cached := resolved[name]
+			if !cached {
+				value = get()
+				resolved[name] = value
+			}
+			return value, value != ""
+		}
+		return os.LookupEnv(name)
+	}
+}
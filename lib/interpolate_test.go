@@ -0,0 +1,40 @@
+package lib
+
+import "testing"
+
+func TestInterpolateConfig(t *testing.T) {
+	lookup := func(name string) (string, bool) {
+		value, ok := map[string]string{"env": "prod", "git.sha": "abc123", "HOME": "/root"}[name]
+		return value, ok
+	}
+	settings := map[string]interface{}{
+		"image_tag": "${git.sha}",
+		"ejson":     map[string]interface{}{"name": "/app/${env}/ssm"},
+		"deploy":    map[string]interface{}{"tags": []interface{}{"home=${ HOME }", "cost=$$5", "raw=$HOME"}},
+		"notify":    []map[string]interface{}{{"url": "https://example.com/${env}"}},
+		"count":     int64(1),
+	}
+	if err := InterpolateConfig(settings, lookup); err != nil {
+		t.Fatal(err)
+	}
+	if settings["image_tag"] != "abc123" {
+		t.Fatalf("unexpected %v", settings["image_tag"])
+	}
+	if name := settings["ejson"].(map[string]interface{})["name"]; name != "/app/prod/ssm" {
+		t.Fatalf("unexpected %v", name)
+	}
+	tags := settings["deploy"].(map[string]interface{})["tags"].([]interface{})
+	if tags[0] != "home=/root" || tags[1] != "cost=$5" || tags[2] != "raw=$HOME" {
+		t.Fatalf("unexpected %v", tags)
+	}
+	if url := settings["notify"].([]map[string]interface{})[0]["url"]; url != "https://example.com/prod" {
+		t.Fatalf("unexpected %v", url)
+	}
+
+	err := InterpolateConfig(map[string]interface{}{
+		"run": map[string]interface{}{"service": "${MISSING}"},
+	}, lookup)
+	if err == nil || err.Error() != "run.service: ${MISSING} is undefined" {
+		t.Fatalf("expected an undefined variable error, got %v", err)
+	}
+}
@@ -0,0 +1,94 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/apex/log"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+)
+
+// LintFinding is a problem found in a task definition
+type LintFinding struct {
+	Container string
+	Rule      string
+	Message   string
+}
+
+// secretNamePattern matches environment variable names that usually hold secrets
+var secretNamePattern = regexp.MustCompile(`(?i)(SECRET|PASSWORD|PASSWD|TOKEN|PRIVATE_KEY|API_KEY|ACCESS_KEY|CREDENTIALS?)`)
+
+// lintTaskDefinition checks the task definition for common mistakes
+func lintTaskDefinition(input *ecs.RegisterTaskDefinitionInput) []LintFinding {
+	var findings []LintFinding
+	for _, container := range input.ContainerDefinitions {
+		name := aws.ToString(container.Name)
+		add := func(rule, message string, args ...interface{}) {
+			findings = append(findings, LintFinding{Container: name, Rule: rule, Message: fmt.Sprintf(message, args...)})
+		}
+
+		image := aws.ToString(container.Image)
+		// the tag is after the last colon, unless it's a registry port
+		if n := strings.LastIndex(image, ":"); n == -1 || strings.Contains(image[n:], "/") {
+			add("mutable-tag", "image %s has no tag, so it's the mutable latest tag", image)
+		} else if image[n+1:] == "latest" {
+			add("mutable-tag", "image %s uses the mutable latest tag", image)
+		}
+
+		if container.HealthCheck == nil && (container.Essential == nil || aws.ToBool(container.Essential)) {
+			add("health-check", "essential container has no health check")
+		}
+
+		if aws.ToString(input.Memory) == "" && container.Memory == nil && container.MemoryReservation == nil {
+			add("memory-limit", "neither the task nor the container has a memory limit")
+		}
+
+		for _, env := range container.Environment {
+			if secretNamePattern.MatchString(aws.ToString(env.Name)) && aws.ToString(env.Value) != "" {
+				add("plain-secret", "environment variable %s looks like a secret, use secrets instead", aws.ToString(env.Name))
+			}
+		}
+
+		if container.LogConfiguration == nil {
+			add("log-configuration", "container has no log configuration, its output is lost")
+		}
+	}
+	return findings
+}
+
+// LintTaskDefinition checks the live task definition, or the rendered template if templateFile is set.
+// It returns the number of findings
+func LintTaskDefinition(ctx context.Context, profile, family, revision, templateFile string, data TaskDefinitionTemplateData) (int, error) {
+	var input *ecs.RegisterTaskDefinitionInput
+	logger := log.WithField("task_definition", resolveTaskDefinition(family, revision))
+	if templateFile != "" {
+		var err error
+		logger = log.WithField("template", templateFile)
+		if input, _, err = renderTaskDefinition(templateFile, data); err != nil {
+			return 0, err
+		}
+	} else {
+		if err := makeConfig(ctx, profile); err != nil {
+			return 0, err
+		}
+		var err error
+		if input, err = describeTaskDefinitionInput(ctx, ecs.NewFromConfig(localConfig), resolveTaskDefinition(family, revision)); err != nil {
+			return 0, err
+		}
+	}
+
+	findings := lintTaskDefinition(input)
+	for _, finding := range findings {
+		logger.WithFields(log.Fields{
+			"container_name": finding.Container,
+			"rule":           finding.Rule,
+		}).Warn(finding.Message)
+	}
+	if len(findings) == 0 {
+		logger.Info("No problems found")
+	}
+	return len(findings), nil
+}
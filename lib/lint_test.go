@@ -0,0 +1,57 @@
+package lib
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+)
+
+func TestLintTaskDefinition(t *testing.T) {
+	good := types.ContainerDefinition{
+		Name:              aws.String("app"),
+		Image:             aws.String("registry:5000/app:v1.2"),
+		HealthCheck:       &types.HealthCheck{Command: []string{"CMD", "true"}},
+		MemoryReservation: aws.Int32(256),
+		LogConfiguration:  &types.LogConfiguration{LogDriver: types.LogDriverAwslogs},
+		Environment: []types.KeyValuePair{
+			{Name: aws.String("DATABASE_PASSWORD_FILE"), Value: aws.String("")},
+		},
+	}
+	bad := types.ContainerDefinition{
+		Name:  aws.String("sidecar"),
+		Image: aws.String("registry:5000/sidecar"),
+		Environment: []types.KeyValuePair{
+			{Name: aws.String("API_TOKEN"), Value: aws.String("hunter2")},
+		},
+	}
+	findings := lintTaskDefinition(&ecs.RegisterTaskDefinitionInput{
+		ContainerDefinitions: []types.ContainerDefinition{good, bad},
+	})
+
+	rules := make(map[string]bool)
+	for _, finding := range findings {
+		if finding.Container != "sidecar" {
+			t.Fatalf("unexpected finding for %s: %+v", finding.Container, finding)
+		}
+		rules[finding.Rule] = true
+	}
+	for _, rule := range []string{"mutable-tag", "health-check", "memory-limit", "plain-secret", "log-configuration"} {
+		if !rules[rule] {
+			t.Fatalf("expected %s to be flagged, got %+v", rule, findings)
+		}
+	}
+
+	// task level memory is enough
+	bad.Image = aws.String("sidecar:latest")
+	findings = lintTaskDefinition(&ecs.RegisterTaskDefinitionInput{
+		Memory:               aws.String("512"),
+		ContainerDefinitions: []types.ContainerDefinition{bad},
+	})
+	for _, finding := range findings {
+		if finding.Rule == "memory-limit" {
+			t.Fatal("task level memory should satisfy the memory limit rule")
+		}
+	}
+}
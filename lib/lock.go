@@ -0,0 +1,386 @@
+package lib
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"path"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/aws/aws-sdk-go-v2/service/ssm/types"
+)
+
+// DefaultLockPrefix is the SSM parameter path deploy locks are kept under
+const DefaultLockPrefix = "/ecs-tool/locks"
+
+// LockInfo describes who holds a deploy lock and until when
+type LockInfo struct {
+	ID         string    `json:"id"`
+	Owner      string    `json:"owner"`
+	AcquiredAt time.Time `json:"acquired_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+// Expired tells if the lock can be taken over by someone else
+func (l LockInfo) Expired(now time.Time) bool {
+	return !now.Before(l.ExpiresAt)
+}
+
+// LockHeldError is returned when somebody else holds a live lock
+type LockHeldError struct {
+	Key  string
+	Info LockInfo
+}
+
+func (e *LockHeldError) Error() string {
+	return fmt.Sprintf("%s is locked by %s until %s", e.Key, e.Info.Owner, e.Info.ExpiresAt.Format(time.RFC3339))
+}
+
+// Locker stores deploy locks
+type Locker interface {
+	// Acquire takes the lock or returns *LockHeldError if a live lock is held by someone else.
+	// Expired locks are taken over.
+	Acquire(ctx context.Context, key string, lock LockInfo) error
+	// Get returns the current lock or nil if there is none
+	Get(ctx context.Context, key string) (*LockInfo, error)
+	// Release removes the lock if it has the given id. Empty id removes it regardless of the holder
+	Release(ctx context.Context, key, id string) error
+}
+
+// MemoryLocker keeps locks in memory. Meant for tests and single process use
+type MemoryLocker struct {
+	mu    sync.Mutex
+	locks map[string]LockInfo
+	now   func() time.Time
+}
+
+// NewMemoryLocker creates an empty in-memory locker
+func NewMemoryLocker() *MemoryLocker {
+	return &MemoryLocker{
+		locks: make(map[string]LockInfo),
+		now:   time.Now,
+	}
+}
+
+func (m *MemoryLocker) Acquire(ctx context.Context, key string, lock LockInfo) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if current, ok := m.locks[key]; ok && current.ID != lock.ID && !current.Expired(m.now()) {
+		return &LockHeldError{Key: key, Info: current}
+	}
+	m.locks[key] = lock
+	return nil
+}
+
+func (m *MemoryLocker) Get(ctx context.Context, key string) (*LockInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if current, ok := m.locks[key]; ok {
+		return &current, nil
+	}
+	return nil, nil
+}
+
+func (m *MemoryLocker) Release(ctx context.Context, key, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if current, ok := m.locks[key]; ok {
+		if id != "" && current.ID != id {
+			return &LockHeldError{Key: key, Info: current}
+		}
+		delete(m.locks, key)
+	}
+	return nil
+}
+
+// ssmLockAPI is the part of the SSM client the locks need
+type ssmLockAPI interface {
+	PutParameter(ctx context.Context, input *ssm.PutParameterInput, optFns ...func(*ssm.Options)) (*ssm.PutParameterOutput, error)
+	GetParameter(ctx context.Context, input *ssm.GetParameterInput, optFns ...func(*ssm.Options)) (*ssm.GetParameterOutput, error)
+	DeleteParameter(ctx context.Context, input *ssm.DeleteParameterInput, optFns ...func(*ssm.Options)) (*ssm.DeleteParameterOutput, error)
+}
+
+// SSMLocker keeps locks as SSM parameters under a common prefix.
+// A lock is created with a non-overwriting PutParameter, so only one writer can win it.
+// An expired lock is taken over by whoever creates its takeover parameter, named after the expired lock ID,
+// with a non-overwriting PutParameter too, so only one of the deploys that saw the same expired lock wins it.
+type SSMLocker struct {
+	prefix string
+	svc    ssmLockAPI
+
+	mu sync.Mutex
+	// takeovers are the takeover parameters of the locks taken over, removed when the lock is released
+	takeovers map[string]string
+}
+
+// NewSSMLocker creates a locker backed by SSM Parameter Store
+func NewSSMLocker(ctx context.Context, profile, prefix string) (*SSMLocker, error) {
+	if err := makeConfig(ctx, profile); err != nil {
+		return nil, err
+	}
+	return newSSMLocker(ssm.NewFromConfig(localConfig), prefix), nil
+}
+
+func newSSMLocker(svc ssmLockAPI, prefix string) *SSMLocker {
+	if prefix == "" {
+		prefix = DefaultLockPrefix
+	}
+	return &SSMLocker{
+		prefix:    prefix,
+		svc:       svc,
+		takeovers: make(map[string]string),
+	}
+}
+
+func (s *SSMLocker) name(key string) string {
+	return path.Join(s.prefix, key)
+}
+
+func (s *SSMLocker) takeoverName(key, expiredID string) string {
+	return s.name(key) + ".takeover-" + expiredID
+}
+
+func (s *SSMLocker) put(ctx context.Context, name, value string, overwrite bool) error {
+	_, err := s.svc.PutParameter(ctx, &ssm.PutParameterInput{
+		Name:        aws.String(name),
+		Type:        types.ParameterTypeString,
+		Value:       aws.String(value),
+		Overwrite:   aws.Bool(overwrite),
+		Description: aws.String("ecs-tool deploy lock"),
+	})
+	return err
+}
+
+// heldError returns *LockHeldError with the current holder of the lock
+func (s *SSMLocker) heldError(ctx context.Context, key string) error {
+	current, err := s.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+	if current == nil {
+		return fmt.Errorf("lock %s is being taken over by another deploy", key)
+	}
+	return &LockHeldError{Key: key, Info: *current}
+}
+
+func (s *SSMLocker) Acquire(ctx context.Context, key string, lock LockInfo) error {
+	value, err := json.Marshal(lock)
+	if err != nil {
+		return err
+	}
+	var exists *types.ParameterAlreadyExists
+	err = s.put(ctx, s.name(key), string(value), false)
+	if err == nil || !errors.As(err, &exists) {
+		return err
+	}
+
+	current, err := s.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+	if current == nil {
+		// released in the meantime
+		err := s.put(ctx, s.name(key), string(value), false)
+		if errors.As(err, &exists) {
+			return s.heldError(ctx, key)
+		}
+		return err
+	}
+	if current.ID == lock.ID {
+		return nil
+	}
+	if !current.Expired(time.Now()) {
+		return &LockHeldError{Key: key, Info: *current}
+	}
+
+	takeover := s.takeoverName(key, current.ID)
+	log.WithField("lock", s.name(key)).Debug("Taking over an expired lock")
+	if err := s.put(ctx, takeover, lock.ID, false); err != nil {
+		if errors.As(err, &exists) {
+			return s.heldError(ctx, key)
+		}
+		return err
+	}
+	if err := s.put(ctx, s.name(key), string(value), true); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.takeovers[key] = takeover
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *SSMLocker) Get(ctx context.Context, key string) (*LockInfo, error) {
+	result, err := s.svc.GetParameter(ctx, &ssm.GetParameterInput{
+		Name: aws.String(s.name(key)),
+	})
+	if err != nil {
+		var notFound *types.ParameterNotFound
+		if errors.As(err, &notFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var lock LockInfo
+	if err := json.Unmarshal([]byte(aws.ToString(result.Parameter.Value)), &lock); err != nil {
+		return nil, fmt.Errorf("can't parse lock %s: %s", key, err)
+	}
+	return &lock, nil
+}
+
+func (s *SSMLocker) Release(ctx context.Context, key, id string) error {
+	if id != "" {
+		current, err := s.Get(ctx, key)
+		if err != nil {
+			return err
+		}
+		if current == nil {
+			return nil
+		}
+		if current.ID != id {
+			return &LockHeldError{Key: key, Info: *current}
+		}
+	}
+	if err := s.delete(ctx, s.name(key)); err != nil {
+		return err
+	}
+	// the takeover parameter is kept until now, so that a deploy which saw the expired lock late can't take it over again
+	s.mu.Lock()
+	takeover, ok := s.takeovers[key]
+	delete(s.takeovers, key)
+	s.mu.Unlock()
+	if ok {
+		return s.delete(ctx, takeover)
+	}
+	return nil
+}
+
+func (s *SSMLocker) delete(ctx context.Context, name string) error {
+	_, err := s.svc.DeleteParameter(ctx, &ssm.DeleteParameterInput{
+		Name: aws.String(name),
+	})
+	var notFound *types.ParameterNotFound
+	if errors.As(err, &notFound) {
+		return nil
+	}
+	return err
+}
+
+// DefaultLockOwner returns user@hostname of the current process
+func DefaultLockOwner() string {
+	username := "unknown"
+	if u, err := user.Current(); err == nil {
+		username = u.Username
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+	return fmt.Sprintf("%s@%s", username, hostname)
+}
+
+// deployLockKeys returns one lock key per service, sorted so that
+// concurrent deploys of overlapping service sets take locks in the same order
+func deployLockKeys(cluster string, services []string) []string {
+	keys := make([]string, 0, len(services))
+	for _, service := range services {
+		keys = append(keys, path.Join(cluster, service))
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// AcquireDeployLock locks every service in the cluster for the given owner and ttl.
+// Either all locks are taken or none. The returned function releases them.
+func AcquireDeployLock(ctx context.Context, locker Locker, cluster string, services []string, owner string, ttl time.Duration) (release func() error, err error) {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	lock := LockInfo{
+		ID:         hex.EncodeToString(id),
+		Owner:      owner,
+		AcquiredAt: now,
+		ExpiresAt:  now.Add(ttl),
+	}
+
+	var acquired []string
+	release = func() error {
+		// the locks are released even if the deploy has been interrupted
+		ctx := context.WithoutCancel(ctx)
+		var lastErr error
+		for _, key := range acquired {
+			if err := locker.Release(ctx, key, lock.ID); err != nil {
+				log.WithError(err).WithField("lock", key).Error("Can't release the lock")
+				lastErr = err
+			}
+		}
+		return lastErr
+	}
+
+	for _, key := range deployLockKeys(cluster, services) {
+		if err := locker.Acquire(ctx, key, lock); err != nil {
+			release()
+			return nil, err
+		}
+		acquired = append(acquired, key)
+		log.WithFields(log.Fields{
+			"lock":       key,
+			"owner":      owner,
+			"expires_at": lock.ExpiresAt.Format(time.RFC3339),
+		}).Debug("Acquired the lock")
+	}
+
+	return release, nil
+}
+
+// DeployLockStatus prints the lock state of every service in the cluster
+func DeployLockStatus(ctx context.Context, locker Locker, cluster string, services []string) error {
+	now := time.Now()
+	for _, key := range deployLockKeys(cluster, services) {
+		logger := log.WithField("lock", key)
+		lock, err := locker.Get(ctx, key)
+		if err != nil {
+			logger.WithError(err).Error("Can't get the lock")
+			return err
+		}
+		if lock == nil {
+			logger.Info("Unlocked")
+			continue
+		}
+		logger = logger.WithFields(log.Fields{
+			"owner":       lock.Owner,
+			"acquired_at": lock.AcquiredAt.Format(time.RFC3339),
+			"expires_at":  lock.ExpiresAt.Format(time.RFC3339),
+		})
+		if lock.Expired(now) {
+			logger.Info("Expired")
+		} else {
+			logger.Warn("Locked")
+		}
+	}
+	return nil
+}
+
+// ReleaseDeployLock forcibly removes the locks of every service in the cluster
+func ReleaseDeployLock(ctx context.Context, locker Locker, cluster string, services []string) error {
+	for _, key := range deployLockKeys(cluster, services) {
+		if err := locker.Release(ctx, key, ""); err != nil {
+			log.WithError(err).WithField("lock", key).Error("Can't release the lock")
+			return err
+		}
+		log.WithField("lock", key).Info("Released")
+	}
+	return nil
+}
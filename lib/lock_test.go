@@ -0,0 +1,180 @@
+package lib
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/aws/aws-sdk-go-v2/service/ssm/types"
+)
+
+func TestAcquireDeployLock(t *testing.T) {
+	locker := NewMemoryLocker()
+
+	release, err := AcquireDeployLock(context.Background(), locker, "cluster", []string{"app", "worker"}, "first", time.Minute)
+	if err != nil {
+		t.Fatalf("first lock should succeed: %s", err)
+	}
+
+	// overlapping service set must not get the lock, and must not leave partial locks behind
+	if _, err := AcquireDeployLock(context.Background(), locker, "cluster", []string{"web", "worker"}, "second", time.Minute); err == nil {
+		t.Fatal("second lock should fail while the first one is held")
+	} else if held, ok := err.(*LockHeldError); !ok || held.Info.Owner != "first" {
+		t.Fatalf("expected the lock to be held by first, got %v", err)
+	}
+	if lock, _ := locker.Get(context.Background(), "cluster/web"); lock != nil {
+		t.Fatalf("failed acquisition left a lock behind: %+v", lock)
+	}
+
+	// a different cluster is independent
+	if _, err := AcquireDeployLock(context.Background(), locker, "other", []string{"app"}, "second", time.Minute); err != nil {
+		t.Fatalf("lock in another cluster should succeed: %s", err)
+	}
+
+	if err := release(); err != nil {
+		t.Fatalf("release failed: %s", err)
+	}
+	if _, err := AcquireDeployLock(context.Background(), locker, "cluster", []string{"web", "worker"}, "second", time.Minute); err != nil {
+		t.Fatalf("lock should succeed after release: %s", err)
+	}
+}
+
+func TestDeployLockExpiry(t *testing.T) {
+	locker := NewMemoryLocker()
+	now := time.Now()
+	locker.now = func() time.Time { return now }
+
+	if _, err := AcquireDeployLock(context.Background(), locker, "cluster", []string{"app"}, "first", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	locker.now = func() time.Time { return now.Add(2 * time.Minute) }
+	if _, err := AcquireDeployLock(context.Background(), locker, "cluster", []string{"app"}, "second", time.Minute); err != nil {
+		t.Fatalf("expired lock should be taken over: %s", err)
+	}
+	lock, _ := locker.Get(context.Background(), "cluster/app")
+	if lock == nil || lock.Owner != "second" {
+		t.Fatalf("lock should belong to second, got %+v", lock)
+	}
+}
+
+func TestMemoryLockerRelease(t *testing.T) {
+	locker := NewMemoryLocker()
+	if err := locker.Acquire(context.Background(), "key", LockInfo{ID: "a", Owner: "first", ExpiresAt: time.Now().Add(time.Minute)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := locker.Release(context.Background(), "key", "b"); err == nil {
+		t.Fatal("release with a wrong id should fail")
+	}
+	if err := locker.Release(context.Background(), "key", ""); err != nil {
+		t.Fatalf("forced release should succeed: %s", err)
+	}
+	if lock, _ := locker.Get(context.Background(), "key"); lock != nil {
+		t.Fatalf("lock should be gone, got %+v", lock)
+	}
+}
+
+// fakeSSM keeps the parameters in memory. The first readers wait for each other,
+// so that they all see the same expired lock before anybody takes it over
+type fakeSSM struct {
+	mu         sync.Mutex
+	parameters map[string]string
+	readers    int
+	ready      chan struct{}
+}
+
+func (f *fakeSSM) PutParameter(ctx context.Context, input *ssm.PutParameterInput, optFns ...func(*ssm.Options)) (*ssm.PutParameterOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	name := aws.ToString(input.Name)
+	if _, ok := f.parameters[name]; ok && !aws.ToBool(input.Overwrite) {
+		return nil, &types.ParameterAlreadyExists{}
+	}
+	f.parameters[name] = aws.ToString(input.Value)
+	return &ssm.PutParameterOutput{}, nil
+}
+
+func (f *fakeSSM) GetParameter(ctx context.Context, input *ssm.GetParameterInput, optFns ...func(*ssm.Options)) (*ssm.GetParameterOutput, error) {
+	f.mu.Lock()
+	value, ok := f.parameters[aws.ToString(input.Name)]
+	if f.readers > 0 {
+		f.readers--
+		if f.readers == 0 {
+			close(f.ready)
+		}
+	}
+	f.mu.Unlock()
+	<-f.ready
+	if !ok {
+		return nil, &types.ParameterNotFound{}
+	}
+	return &ssm.GetParameterOutput{Parameter: &types.Parameter{Value: aws.String(value)}}, nil
+}
+
+func (f *fakeSSM) DeleteParameter(ctx context.Context, input *ssm.DeleteParameterInput, optFns ...func(*ssm.Options)) (*ssm.DeleteParameterOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.parameters, aws.ToString(input.Name))
+	return &ssm.DeleteParameterOutput{}, nil
+}
+
+func TestSSMLockerTakeover(t *testing.T) {
+	const deploys = 5
+	expired, _ := json.Marshal(LockInfo{ID: "old", Owner: "old", ExpiresAt: time.Now().Add(-time.Minute)})
+	fake := &fakeSSM{
+		parameters: map[string]string{"/locks/cluster/app": string(expired)},
+		readers:    deploys,
+		ready:      make(chan struct{}),
+	}
+
+	var wg sync.WaitGroup
+	results := make(chan error, deploys)
+	lockers := make([]*SSMLocker, deploys)
+	for n := range lockers {
+		lockers[n] = newSSMLocker(fake, "/locks")
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			results <- lockers[n].Acquire(context.Background(), "cluster/app", LockInfo{
+				ID:        fmt.Sprint(n),
+				Owner:     fmt.Sprint(n),
+				ExpiresAt: time.Now().Add(time.Minute),
+			})
+		}(n)
+	}
+	wg.Wait()
+	close(results)
+
+	won := 0
+	for err := range results {
+		var held *LockHeldError
+		switch {
+		case err == nil:
+			won++
+		case !errors.As(err, &held):
+			t.Fatalf("expected the lock to be held, got %v", err)
+		}
+	}
+	if won != 1 {
+		t.Fatalf("exactly one deploy should take over the expired lock, %d did", won)
+	}
+
+	// releasing the lock removes the takeover parameter too
+	lock, err := lockers[0].Get(context.Background(), "cluster/app")
+	if err != nil || lock == nil {
+		t.Fatalf("expected the lock, got %v", err)
+	}
+	var winner int
+	fmt.Sscan(lock.ID, &winner)
+	if err := lockers[winner].Release(context.Background(), "cluster/app", lock.ID); err != nil {
+		t.Fatal(err)
+	}
+	if len(fake.parameters) != 0 {
+		t.Fatalf("expected no parameters left, got %v", fake.parameters)
+	}
+}
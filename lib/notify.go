@@ -0,0 +1,218 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/apex/log"
+)
+
+// Events that can be sent to the webhooks
+const (
+	EventDeployStarted    = "deploy_started"
+	EventServiceStable    = "service_stable"
+	EventDeployFailed     = "deploy_failed"
+	EventRollbackStarted  = "rollback_started"
+	EventRollbackFinished = "rollback_finished"
+	EventTaskExited       = "task_exited"
+)
+
+// Notifier is a webhook that gets deploy and task events posted to it
+type Notifier struct {
+	URL string `mapstructure:"url"`
+	// Events to send. All events are sent if empty
+	Events []string `mapstructure:"events"`
+	// Template renders the request body from NotifyEvent.
+	// If empty, the event itself is sent as JSON
+	Template string `mapstructure:"template"`
+	// Timeout of a single attempt, 5s by default
+	Timeout time.Duration `mapstructure:"timeout"`
+	// Retries after the first failed attempt, 2 by default. Negative disables retries
+	Retries int `mapstructure:"retries"`
+}
+
+// NotifyEvent is the payload sent to the webhooks
+type NotifyEvent struct {
+	Event          string    `json:"event"`
+	Cluster        string    `json:"cluster"`
+	Service        string    `json:"service,omitempty"`
+	TaskDefinition string    `json:"task_definition,omitempty"`
+	ImageTag       string    `json:"image_tag,omitempty"`
+	ExitCode       *int      `json:"exit_code,omitempty"`
+	Error          string    `json:"error,omitempty"`
+	Time           time.Time `json:"time"`
+	// Text is a human readable summary, so that the payload works with Slack incoming webhooks as is
+	Text string `json:"text"`
+}
+
+const (
+	defaultNotifyTimeout = 5 * time.Second
+	defaultNotifyRetries = 2
+)
+
+var notifiers []Notifier
+
+var (
+	notifyMu sync.Mutex
+	// notifySent is closed when the last queued event has been sent
+	notifySent = closedChan()
+)
+
+func closedChan() chan struct{} {
+	c := make(chan struct{})
+	close(c)
+	return c
+}
+
+// ConfigureNotifications sets the webhooks events are posted to
+func ConfigureNotifications(n []Notifier) {
+	notifiers = n
+}
+
+func (n Notifier) wants(event string) bool {
+	if len(n.Events) == 0 {
+		return true
+	}
+	for _, e := range n.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+func (n Notifier) body(event NotifyEvent) ([]byte, error) {
+	if n.Template == "" {
+		return json.Marshal(event)
+	}
+	tmpl, err := template.New("notify").Parse(n.Template)
+	if err != nil {
+		return nil, fmt.Errorf("can't parse the template: %s", err)
+	}
+	body := new(bytes.Buffer)
+	if err := tmpl.Execute(body, event); err != nil {
+		return nil, fmt.Errorf("can't render the template: %s", err)
+	}
+	return body.Bytes(), nil
+}
+
+func (n Notifier) post(body []byte) error {
+	timeout := n.Timeout
+	if timeout == 0 {
+		timeout = defaultNotifyTimeout
+	}
+	client := &http.Client{Timeout: timeout}
+	resp, err := client.Post(n.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded with %s", resp.Status)
+	}
+	return nil
+}
+
+// send posts the event, retrying with a linear backoff
+func (n Notifier) send(event NotifyEvent) error {
+	body, err := n.body(event)
+	if err != nil {
+		return err
+	}
+	retries := n.Retries
+	if retries == 0 {
+		retries = defaultNotifyRetries
+	}
+	for attempt := 0; ; attempt++ {
+		err = n.post(body)
+		if err == nil || attempt >= retries {
+			return err
+		}
+		time.Sleep(time.Duration(attempt+1) * time.Second)
+	}
+}
+
+// notify queues the event to be sent to every interested webhook in the background, in order.
+// Failures are only logged, a broken webhook should never fail or slow down a deploy
+func notify(event NotifyEvent) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	if event.Text == "" {
+		event.Text = notifyText(event)
+	}
+	notifyMu.Lock()
+	previous, sent := notifySent, make(chan struct{})
+	notifySent = sent
+	notifyMu.Unlock()
+
+	go func(notifiers []Notifier) {
+		defer close(sent)
+		<-previous
+		for _, n := range notifiers {
+			if !n.wants(event.Event) {
+				continue
+			}
+			if err := n.send(event); err != nil {
+				log.WithError(err).WithFields(log.Fields{
+					"event":   event.Event,
+					"webhook": n.URL,
+				}).Warn("Can't send the notification")
+			}
+		}
+	}(notifiers)
+}
+
+// FlushNotifications waits up to the timeout for the queued events to be sent, it's meant to be called before exiting
+func FlushNotifications(timeout time.Duration) {
+	notifyMu.Lock()
+	sent := notifySent
+	notifyMu.Unlock()
+	select {
+	case <-sent:
+	case <-time.After(timeout):
+		log.Warn("Gave up waiting for the notifications to be sent")
+	}
+}
+
+func notifyText(event NotifyEvent) string {
+	target := event.Service
+	if target == "" {
+		target = event.TaskDefinition
+	}
+	target = fmt.Sprintf("%s in %s", target, event.Cluster)
+	if event.ImageTag != "" {
+		target = fmt.Sprintf("%s (%s)", target, event.ImageTag)
+	}
+
+	var text string
+	switch event.Event {
+	case EventDeployStarted:
+		text = fmt.Sprintf("Deploying %s", target)
+	case EventServiceStable:
+		text = fmt.Sprintf("Deployed %s", target)
+	case EventDeployFailed:
+		text = fmt.Sprintf("Failed to deploy %s", target)
+	case EventRollbackStarted:
+		text = fmt.Sprintf("Rolling back %s", target)
+	case EventRollbackFinished:
+		text = fmt.Sprintf("Rolled back %s", target)
+	case EventTaskExited:
+		text = fmt.Sprintf("Task %s exited", target)
+		if event.ExitCode != nil {
+			text = fmt.Sprintf("%s with code %d", text, *event.ExitCode)
+		}
+	default:
+		text = strings.Join([]string{event.Event, target}, " ")
+	}
+	if event.Error != "" {
+		text = fmt.Sprintf("%s: %s", text, event.Error)
+	}
+	return text
+}
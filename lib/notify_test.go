@@ -0,0 +1,90 @@
+package lib
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestNotify(t *testing.T) {
+	var bodies []string
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+	}))
+	defer server.Close()
+
+	ConfigureNotifications([]Notifier{
+		{URL: server.URL, Events: []string{EventDeployFailed}, Retries: 1},
+		{URL: server.URL, Events: []string{EventServiceStable}, Template: `{"text": "{{ .Service }} is {{ .Event }}"}`},
+	})
+	defer ConfigureNotifications(nil)
+
+	notify(NotifyEvent{Event: EventDeployFailed, Cluster: "cluster", Service: "app", Error: "boom"})
+	notify(NotifyEvent{Event: EventServiceStable, Cluster: "cluster", Service: "app"})
+	notify(NotifyEvent{Event: EventDeployStarted, Cluster: "cluster", Service: "app"})
+	FlushNotifications(time.Minute)
+
+	if len(bodies) != 2 {
+		t.Fatalf("expected 2 notifications, got %d: %v", len(bodies), bodies)
+	}
+	var event NotifyEvent
+	if err := json.Unmarshal([]byte(bodies[0]), &event); err != nil {
+		t.Fatal(err)
+	}
+	if event.Event != EventDeployFailed || event.Text != "Failed to deploy app in cluster: boom" {
+		t.Fatalf("unexpected event %+v", event)
+	}
+	if bodies[1] != `{"text": "app is service_stable"}` {
+		t.Fatalf("unexpected templated body %s", bodies[1])
+	}
+}
+
+func TestNotifyBrokenWebhook(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+	}))
+	defer server.Close()
+
+	ConfigureNotifications([]Notifier{
+		{URL: server.URL, Timeout: 10 * time.Millisecond, Retries: -1},
+	})
+	defer ConfigureNotifications(nil)
+
+	release := make(chan struct{})
+	hung := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer hung.Close()
+	defer close(release)
+
+	start := time.Now()
+	notify(NotifyEvent{Event: EventDeployStarted, Cluster: "cluster", Service: "app"})
+	FlushNotifications(time.Minute)
+	if time.Since(start) > time.Second {
+		t.Fatal("a broken webhook should not block for long")
+	}
+
+	// a webhook that never answers only holds up the exit until the flush gives up
+	ConfigureNotifications([]Notifier{
+		{URL: hung.URL, Timeout: time.Minute, Retries: -1},
+	})
+	start = time.Now()
+	notify(NotifyEvent{Event: EventDeployStarted, Cluster: "cluster", Service: "app"})
+	if time.Since(start) > 100*time.Millisecond {
+		t.Fatal("notify should not wait for the webhook")
+	}
+	FlushNotifications(50 * time.Millisecond)
+	if time.Since(start) > time.Second {
+		t.Fatal("the flush should give up after its timeout")
+	}
+}
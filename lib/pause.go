@@ -0,0 +1,268 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/apex/log"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/applicationautoscaling"
+	scalingtypes "github.com/aws/aws-sdk-go-v2/service/applicationautoscaling/types"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+)
+
+// Tags keeping the state of paused services, so that they can be resumed
+const (
+	TagPausedDesiredCount = "ecs-tool:paused-desired-count"
+	TagPausedMinCapacity  = "ecs-tool:paused-min-capacity"
+	TagPausedMaxCapacity  = "ecs-tool:paused-max-capacity"
+)
+
+// pausedService is the state of a service before it was paused
+type pausedService struct {
+	desiredCount int32
+	// scalable is set if the service has an Application Auto Scaling target
+	scalable    bool
+	minCapacity int32
+	maxCapacity int32
+}
+
+func (p pausedService) tags() []types.Tag {
+	tags := []types.Tag{{
+		Key:   aws.String(TagPausedDesiredCount),
+		Value: aws.String(strconv.FormatInt(int64(p.desiredCount), 10)),
+	}}
+	if p.scalable {
+		tags = append(tags,
+			types.Tag{Key: aws.String(TagPausedMinCapacity), Value: aws.String(strconv.FormatInt(int64(p.minCapacity), 10))},
+			types.Tag{Key: aws.String(TagPausedMaxCapacity), Value: aws.String(strconv.FormatInt(int64(p.maxCapacity), 10))},
+		)
+	}
+	return tags
+}
+
+// parsePausedService reads the paused state from the service tags. ok is false if the service isn't paused
+func parsePausedService(tags []types.Tag) (paused pausedService, ok bool, err error) {
+	values := make(map[string]string)
+	for _, tag := range tags {
+		values[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
+	}
+	count, ok := values[TagPausedDesiredCount]
+	if !ok {
+		return paused, false, nil
+	}
+	if paused.desiredCount, err = parseInt32(count); err != nil {
+		return paused, true, fmt.Errorf("can't parse %s tag: %w", TagPausedDesiredCount, err)
+	}
+	minCapacity, hasMin := values[TagPausedMinCapacity]
+	maxCapacity, hasMax := values[TagPausedMaxCapacity]
+	if hasMin && hasMax {
+		paused.scalable = true
+		if paused.minCapacity, err = parseInt32(minCapacity); err != nil {
+			return paused, true, fmt.Errorf("can't parse %s tag: %w", TagPausedMinCapacity, err)
+		}
+		if paused.maxCapacity, err = parseInt32(maxCapacity); err != nil {
+			return paused, true, fmt.Errorf("can't parse %s tag: %w", TagPausedMaxCapacity, err)
+		}
+	}
+	return paused, true, nil
+}
+
+func parseInt32(s string) (int32, error) {
+	n, err := strconv.ParseInt(s, 10, 32)
+	return int32(n), err
+}
+
+func scalableResourceID(cluster, service string) string {
+	return fmt.Sprintf("service/%s/%s", cluster, service)
+}
+
+// setScalableCapacity changes the min and max capacity of the service's auto scaling target
+func setScalableCapacity(ctx context.Context, svc *applicationautoscaling.Client, cluster, service string, minCapacity, maxCapacity int32) error {
+	_, err := svc.RegisterScalableTarget(ctx, &applicationautoscaling.RegisterScalableTargetInput{
+		ServiceNamespace:  scalingtypes.ServiceNamespaceEcs,
+		ScalableDimension: scalingtypes.ScalableDimensionECSServiceDesiredCount,
+		ResourceId:        aws.String(scalableResourceID(cluster, service)),
+		MinCapacity:       aws.Int32(minCapacity),
+		MaxCapacity:       aws.Int32(maxCapacity),
+	})
+	return err
+}
+
+// describeServicesWithTags describes the services, failing if any of them can't be found
+func describeServicesWithTags(ctx context.Context, svc servicesDescriber, cluster string, services []string) ([]types.Service, error) {
+	var described []types.Service
+	for start := 0; start < len(services); start += describeServicesBatch {
+		describeResult, err := svc.DescribeServices(ctx, &ecs.DescribeServicesInput{
+			Cluster:  aws.String(cluster),
+			Services: services[start:min(start+describeServicesBatch, len(services))],
+			Include:  []types.ServiceField{types.ServiceFieldTags},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if len(describeResult.Failures) > 0 {
+			failure := describeResult.Failures[0]
+			return nil, fmt.Errorf("can't describe service %s: %s", aws.ToString(failure.Arn), aws.ToString(failure.Reason))
+		}
+		described = append(described, describeResult.Services...)
+	}
+	return described, nil
+}
+
+// PauseServices scales the services down to zero, remembering their desired counts
+// and auto scaling capacity in the service tags
+func PauseServices(ctx context.Context, profile, cluster string, services []string) error {
+	err := makeConfig(ctx, profile)
+	if err != nil {
+		return err
+	}
+	logger := log.WithField("cluster", cluster)
+	svc := ecs.NewFromConfig(localConfig)
+	scalingSvc := applicationautoscaling.NewFromConfig(localConfig)
+
+	described, err := describeServicesWithTags(ctx, svc, cluster, services)
+	if err != nil {
+		logger.WithError(err).Error("Can't describe services")
+		return err
+	}
+
+	var toPause []string
+	for _, service := range described {
+		name := aws.ToString(service.ServiceName)
+		logger := logger.WithField("service", name)
+		saved, paused, err := parsePausedService(service.Tags)
+		if err != nil {
+			logger.WithError(err).Error("Can't read the paused state")
+			return err
+		}
+		if paused {
+			// the state is saved before scaling down, so a pause that failed half way is finished
+			// with the saved state instead of being skipped
+			if service.DesiredCount == 0 {
+				logger.Info("Already paused")
+				continue
+			}
+			if saved.scalable {
+				if err := setScalableCapacity(ctx, scalingSvc, cluster, name, 0, 0); err != nil {
+					logger.WithError(err).Error("Can't change auto scaling capacity")
+					return err
+				}
+			}
+			logger.WithField("desired_count", saved.desiredCount).Info("Finishing the interrupted pause")
+			toPause = append(toPause, name)
+			continue
+		}
+
+		state := pausedService{desiredCount: service.DesiredCount}
+		targets, err := scalingSvc.DescribeScalableTargets(ctx, &applicationautoscaling.DescribeScalableTargetsInput{
+			ServiceNamespace:  scalingtypes.ServiceNamespaceEcs,
+			ScalableDimension: scalingtypes.ScalableDimensionECSServiceDesiredCount,
+			ResourceIds:       []string{scalableResourceID(cluster, name)},
+		})
+		if err != nil {
+			logger.WithError(err).Error("Can't describe auto scaling target")
+			return err
+		}
+		if len(targets.ScalableTargets) > 0 {
+			state.scalable = true
+			state.minCapacity = aws.ToInt32(targets.ScalableTargets[0].MinCapacity)
+			state.maxCapacity = aws.ToInt32(targets.ScalableTargets[0].MaxCapacity)
+		}
+
+		// save the state first, so that the service can be resumed even if pausing fails half way
+		if _, err := svc.TagResource(ctx, &ecs.TagResourceInput{
+			ResourceArn: service.ServiceArn,
+			Tags:        state.tags(),
+		}); err != nil {
+			logger.WithError(err).Error("Can't tag the service")
+			return err
+		}
+		if state.scalable {
+			if err := setScalableCapacity(ctx, scalingSvc, cluster, name, 0, 0); err != nil {
+				logger.WithError(err).Error("Can't change auto scaling capacity")
+				return err
+			}
+		}
+		logger.WithField("desired_count", state.desiredCount).Info("Pausing")
+		toPause = append(toPause, name)
+	}
+	if len(toPause) == 0 {
+		return nil
+	}
+
+	return updateServices(ctx, logger, cluster, toPause, func(service string) *ecs.UpdateServiceInput {
+		return &ecs.UpdateServiceInput{
+			DesiredCount: aws.Int32(0),
+		}
+	})
+}
+
+// ResumeServices restores the desired counts and auto scaling capacity of the paused services
+func ResumeServices(ctx context.Context, profile, cluster string, services []string) error {
+	err := makeConfig(ctx, profile)
+	if err != nil {
+		return err
+	}
+	logger := log.WithField("cluster", cluster)
+	svc := ecs.NewFromConfig(localConfig)
+	scalingSvc := applicationautoscaling.NewFromConfig(localConfig)
+
+	described, err := describeServicesWithTags(ctx, svc, cluster, services)
+	if err != nil {
+		logger.WithError(err).Error("Can't describe services")
+		return err
+	}
+
+	desiredCounts := make(map[string]int32)
+	serviceArns := make(map[string]*string)
+	var toResume []string
+	for _, service := range described {
+		name := aws.ToString(service.ServiceName)
+		logger := logger.WithField("service", name)
+		state, paused, err := parsePausedService(service.Tags)
+		if err != nil {
+			logger.WithError(err).Error("Can't read the paused state")
+			return err
+		}
+		if !paused {
+			logger.Info("Not paused")
+			continue
+		}
+		if state.scalable {
+			if err := setScalableCapacity(ctx, scalingSvc, cluster, name, state.minCapacity, state.maxCapacity); err != nil {
+				logger.WithError(err).Error("Can't change auto scaling capacity")
+				return err
+			}
+		}
+		logger.WithField("desired_count", state.desiredCount).Info("Resuming")
+		desiredCounts[name] = state.desiredCount
+		serviceArns[name] = service.ServiceArn
+		toResume = append(toResume, name)
+	}
+	if len(toResume) == 0 {
+		return nil
+	}
+
+	err = updateServices(ctx, logger, cluster, toResume, func(service string) *ecs.UpdateServiceInput {
+		return &ecs.UpdateServiceInput{
+			DesiredCount: aws.Int32(desiredCounts[service]),
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, name := range toResume {
+		if _, err := svc.UntagResource(ctx, &ecs.UntagResourceInput{
+			ResourceArn: serviceArns[name],
+			TagKeys:     []string{TagPausedDesiredCount, TagPausedMinCapacity, TagPausedMaxCapacity},
+		}); err != nil {
+			logger.WithError(err).WithField("service", name).Error("Can't remove the paused state")
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+)
+
+func TestPausedServiceTags(t *testing.T) {
+	if _, paused, err := parsePausedService([]types.Tag{{Key: aws.String("env"), Value: aws.String("preview")}}); paused || err != nil {
+		t.Fatalf("service without the paused tags should not be paused, got %v %v", paused, err)
+	}
+
+	for _, state := range []pausedService{
+		{desiredCount: 3},
+		{desiredCount: 2, scalable: true, minCapacity: 1, maxCapacity: 10},
+	} {
+		parsed, paused, err := parsePausedService(state.tags())
+		if err != nil || !paused {
+			t.Fatalf("can't parse the paused state back: %v %v", paused, err)
+		}
+		if parsed != state {
+			t.Fatalf("%+v != %+v", parsed, state)
+		}
+	}
+
+	if _, _, err := parsePausedService([]types.Tag{{Key: aws.String(TagPausedDesiredCount), Value: aws.String("many")}}); err == nil {
+		t.Fatal("invalid desired count should be an error")
+	}
+}
+
+func TestDescribeServicesWithTagsBatches(t *testing.T) {
+	fake := &fakeServicesDescriber{deployments: make(map[string]int)}
+	var services []string
+	for n := 0; n < 25; n++ {
+		name := fmt.Sprintf("service-%d", n)
+		fake.deployments[name] = 1
+		services = append(services, name)
+	}
+	described, err := describeServicesWithTags(context.Background(), fake, "cluster", services)
+	if err != nil || len(described) != 25 || fake.calls != 3 {
+		t.Fatalf("expected 25 services in 3 calls, got %d in %d: %v", len(described), fake.calls, err)
+	}
+	if _, err := describeServicesWithTags(context.Background(), fake, "cluster", append(services, "gone")); err == nil {
+		t.Fatal("a missing service should be an error")
+	}
+}
@@ -0,0 +1,185 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+)
+
+// servicePollInterval is how often the watched services are described
+const servicePollInterval = 10 * time.Second
+
+type servicesDescriber interface {
+	DescribeServices(ctx context.Context, input *ecs.DescribeServicesInput, optFns ...func(*ecs.Options)) (*ecs.DescribeServicesOutput, error)
+}
+
+// serviceUpdate is the state of a watched service after a poll
+type serviceUpdate struct {
+	service types.Service
+	err     error
+}
+
+type serviceWatcher struct {
+	service string
+	updates chan serviceUpdate
+}
+
+// servicePoller describes the services watched in a cluster in batches and hands every watcher
+// the state of its service, so that the services updated in parallel share the DescribeServices calls
+type servicePoller struct {
+	svc      servicesDescriber
+	cluster  string
+	interval time.Duration
+
+	mu       sync.Mutex
+	watchers []*serviceWatcher
+}
+
+func newServicePoller(svc servicesDescriber, cluster string) *servicePoller {
+	return &servicePoller{svc: svc, cluster: cluster, interval: servicePollInterval}
+}
+
+// serviceName returns the name of the service, which can be given by its ARN too
+func serviceName(service string) string {
+	return service[strings.LastIndex(service, "/")+1:]
+}
+
+// start polls the services until the returned function is called
+func (p *servicePoller) start(ctx context.Context) (stop func()) {
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(p.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				p.poll(ctx)
+			}
+		}
+	}()
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+// watch hands the service state of every poll to the returned channel until unwatch is called.
+// Only the latest state is kept if the watcher falls behind
+func (p *servicePoller) watch(service string) (updates <-chan serviceUpdate, unwatch func()) {
+	watcher := &serviceWatcher{service: serviceName(service), updates: make(chan serviceUpdate, 1)}
+	p.mu.Lock()
+	p.watchers = append(p.watchers, watcher)
+	p.mu.Unlock()
+
+	return watcher.updates, func() {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		for n, w := range p.watchers {
+			if w == watcher {
+				p.watchers = append(p.watchers[:n], p.watchers[n+1:]...)
+				break
+			}
+		}
+	}
+}
+
+// poll describes the watched services and updates their watchers.
+// Only the watchers that were there before the describe calls are updated,
+// so that none of them gets a state older than itself
+func (p *servicePoller) poll(ctx context.Context) {
+	p.mu.Lock()
+	watchers := append([]*serviceWatcher(nil), p.watchers...)
+	p.mu.Unlock()
+
+	var services []string
+	seen := make(map[string]bool)
+	for _, watcher := range watchers {
+		if !seen[watcher.service] {
+			seen[watcher.service] = true
+			services = append(services, watcher.service)
+		}
+	}
+
+	updates := make(map[string]serviceUpdate)
+	for start := 0; start < len(services); start += describeServicesBatch {
+		batch := services[start:min(start+describeServicesBatch, len(services))]
+		describeResult, err := p.svc.DescribeServices(ctx, &ecs.DescribeServicesInput{
+			Cluster:  aws.String(p.cluster),
+			Services: batch,
+		})
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			// throttled polls are skipped, the next one will do. Other errors won't go away, so the watchers fail
+			if isThrottleError(err) {
+				log.WithError(err).WithField("services", strings.Join(batch, ", ")).Warn("Can't describe services")
+				continue
+			}
+			for _, service := range batch {
+				updates[service] = serviceUpdate{err: fmt.Errorf("can't describe service %s: %w", service, err)}
+			}
+			continue
+		}
+		for _, service := range describeResult.Services {
+			updates[aws.ToString(service.ServiceName)] = serviceUpdate{service: service}
+		}
+		for _, failure := range describeResult.Failures {
+			name := serviceName(aws.ToString(failure.Arn))
+			updates[name] = serviceUpdate{err: fmt.Errorf("can't describe service %s: %s", name, aws.ToString(failure.Reason))}
+		}
+	}
+
+	for _, watcher := range watchers {
+		update, ok := updates[watcher.service]
+		if !ok {
+			continue
+		}
+		select {
+		case <-watcher.updates:
+		default:
+		}
+		watcher.updates <- update
+	}
+}
+
+// serviceStable tells if the service finished the deployment, the same way the SDK waiter does
+func serviceStable(service types.Service) (bool, error) {
+	switch status := aws.ToString(service.Status); status {
+	case "DRAINING", "INACTIVE":
+		return false, fmt.Errorf("service %s is %s", aws.ToString(service.ServiceName), status)
+	}
+	return len(service.Deployments) == 1 && service.RunningCount == service.DesiredCount, nil
+}
+
+// waitStable waits for the service to become stable
+func (p *servicePoller) waitStable(ctx context.Context, service string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	updates, unwatch := p.watch(service)
+	defer unwatch()
+	for {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("service %s didn't become stable: %w", serviceName(service), ctx.Err())
+		case update := <-updates:
+			if update.err != nil {
+				return update.err
+			}
+			if stable, err := serviceStable(update.service); err != nil || stable {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/aws/smithy-go"
+)
+
+type fakeServicesDescriber struct {
+	mu    sync.Mutex
+	calls int
+	// deployments of the services, they're missing if they aren't there
+	deployments map[string]int
+	// err fails the calls
+	err error
+}
+
+func (f *fakeServicesDescriber) DescribeServices(ctx context.Context, input *ecs.DescribeServicesInput, optFns ...func(*ecs.Options)) (*ecs.DescribeServicesOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls++
+	if f.err != nil {
+		return nil, f.err
+	}
+	if len(input.Services) > describeServicesBatch {
+		return nil, fmt.Errorf("too many services: %d", len(input.Services))
+	}
+	output := &ecs.DescribeServicesOutput{}
+	for _, name := range input.Services {
+		deployments, ok := f.deployments[name]
+		if !ok {
+			output.Failures = append(output.Failures, types.Failure{
+				Arn:    aws.String("arn:aws:ecs:us-east-1:1:service/cluster/" + name),
+				Reason: aws.String("MISSING"),
+			})
+			continue
+		}
+		output.Services = append(output.Services, types.Service{
+			ServiceName: aws.String(name),
+			Status:      aws.String("ACTIVE"),
+			Deployments: make([]types.Deployment, deployments),
+		})
+	}
+	return output, nil
+}
+
+func TestServicePollerBatches(t *testing.T) {
+	fake := &fakeServicesDescriber{deployments: make(map[string]int)}
+	poller := newServicePoller(fake, "cluster")
+	var updates []<-chan serviceUpdate
+	for n := 0; n < 25; n++ {
+		name := fmt.Sprintf("service-%d", n)
+		fake.deployments[name] = 1
+		watched, _ := poller.watch("arn:aws:ecs:us-east-1:1:service/cluster/" + name)
+		updates = append(updates, watched)
+	}
+	// the same service can be watched twice, i.e. for the events and the stability
+	events, _ := poller.watch("service-0")
+	missing, _ := poller.watch("gone")
+
+	poller.poll(context.Background())
+	if fake.calls != 3 {
+		t.Fatalf("26 services should be described in 3 calls, got %d", fake.calls)
+	}
+	for n, watched := range append(updates, events) {
+		update := <-watched
+		if update.err != nil || aws.ToString(update.service.ServiceName) != fmt.Sprintf("service-%d", n%25) {
+			t.Fatalf("unexpected update %+v", update)
+		}
+	}
+	if update := <-missing; update.err == nil {
+		t.Fatal("a missing service should be an error")
+	}
+}
+
+func TestServicePollerWaitStable(t *testing.T) {
+	fake := &fakeServicesDescriber{deployments: map[string]int{"app": 2}}
+	poller := newServicePoller(fake, "cluster")
+	poller.interval = time.Millisecond
+	defer poller.start(context.Background())()
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		fake.mu.Lock()
+		fake.deployments["app"] = 1
+		fake.mu.Unlock()
+	}()
+	if err := poller.waitStable(context.Background(), "app", time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if err := poller.waitStable(context.Background(), "gone", time.Second); err == nil {
+		t.Fatal("a missing service should fail the wait")
+	}
+}
+
+func TestServicePollerErrors(t *testing.T) {
+	accessDenied := &smithy.GenericAPIError{Code: "AccessDeniedException", Message: "not allowed"}
+	fake := &fakeServicesDescriber{deployments: map[string]int{"app": 2}, err: accessDenied}
+	poller := newServicePoller(fake, "cluster")
+	poller.interval = time.Millisecond
+	defer poller.start(context.Background())()
+
+	// the wait fails with the cause right away instead of timing out
+	started := time.Now()
+	if err := poller.waitStable(context.Background(), "app", time.Minute); !errors.Is(err, accessDenied) {
+		t.Fatalf("expected the access denied error, got %v", err)
+	}
+	if elapsed := time.Since(started); elapsed > time.Second {
+		t.Fatalf("the wait should fail at once, it took %s", elapsed)
+	}
+
+	// throttled polls are skipped
+	fake.mu.Lock()
+	fake.err = &smithy.GenericAPIError{Code: "ThrottlingException"}
+	fake.mu.Unlock()
+	if err := poller.waitStable(context.Background(), "app", 50*time.Millisecond); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected throttling to be waited out, got %v", err)
+	}
+}
@@ -0,0 +1,250 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/apex/log"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+)
+
+// TagPreviewOf marks preview services with the name of the service they were cloned from
+const TagPreviewOf = "ecs-tool:preview-of"
+
+// PreviewOptions controls what is cloned into a preview service
+type PreviewOptions struct {
+	DesiredCount int32
+	// LoadBalancer registers the preview tasks in the target groups of the original service
+	LoadBalancer bool
+	// ServiceDiscovery registers the preview tasks in the service registries of the original service
+	ServiceDiscovery bool
+}
+
+// createServiceInput clones the service configuration into a new service with the task definition
+func createServiceInput(from *types.Service, name, taskDefinitionArn string, tags []types.Tag, opts PreviewOptions) *ecs.CreateServiceInput {
+	input := &ecs.CreateServiceInput{
+		Cluster:                  from.ClusterArn,
+		ServiceName:              aws.String(name),
+		TaskDefinition:           aws.String(taskDefinitionArn),
+		DesiredCount:             aws.Int32(opts.DesiredCount),
+		CapacityProviderStrategy: from.CapacityProviderStrategy,
+		DeploymentConfiguration:  from.DeploymentConfiguration,
+		EnableECSManagedTags:     from.EnableECSManagedTags,
+		EnableExecuteCommand:     from.EnableExecuteCommand,
+		NetworkConfiguration:     from.NetworkConfiguration,
+		PlacementConstraints:     from.PlacementConstraints,
+		PlacementStrategy:        from.PlacementStrategy,
+		PlatformVersion:          from.PlatformVersion,
+		SchedulingStrategy:       from.SchedulingStrategy,
+		Tags:                     nilIfEmpty(tags),
+		PropagateTags:            types.PropagateTagsService,
+	}
+	// launch type and capacity providers can't be set at the same time
+	if len(from.CapacityProviderStrategy) == 0 {
+		input.LaunchType = from.LaunchType
+	}
+	if opts.LoadBalancer && len(from.LoadBalancers) > 0 {
+		input.LoadBalancers = from.LoadBalancers
+		input.HealthCheckGracePeriodSeconds = from.HealthCheckGracePeriodSeconds
+	}
+	if opts.ServiceDiscovery {
+		input.ServiceRegistries = from.ServiceRegistries
+	}
+	return input
+}
+
+// CreatePreview clones the service into a new one in the same cluster, with the image tags replaced.
+// The task definition is registered under a new family named after the preview service.
+func CreatePreview(ctx context.Context, profile, cluster, from, name, imageTag string, imageTags []string, workDir string, opts PreviewOptions) error {
+	err := makeConfig(ctx, profile)
+	if err != nil {
+		return err
+	}
+	logger := log.WithFields(log.Fields{
+		"cluster": cluster,
+		"from":    from,
+		"service": name,
+	})
+	svc := ecs.NewFromConfig(localConfig)
+
+	described, err := describeServicesWithTags(ctx, svc, cluster, []string{from})
+	if err != nil {
+		logger.WithError(err).Error("Can't describe service")
+		return err
+	}
+	service := described[0]
+
+	describeTaskResult, err := svc.DescribeTaskDefinition(ctx, &ecs.DescribeTaskDefinitionInput{
+		TaskDefinition: service.TaskDefinition,
+		Include:        []types.TaskDefinitionField{types.TaskDefinitionFieldTags},
+	})
+	if err != nil {
+		logger.WithError(err).Error("Can't get task definition")
+		return err
+	}
+	taskDefinition := describeTaskResult.TaskDefinition
+	if err := modifyContainerDefinitionImages(imageTag, imageTags, workDir, taskDefinition.ContainerDefinitions, logger); err != nil {
+		logger.WithError(err).Error("Can't modify container definition images")
+		return err
+	}
+	taskDefinition.Family = aws.String(name)
+
+	// the deploy tags of the source revision name another deploy
+	tags, err := deployTags(ctx, nil)
+	if err != nil {
+		return err
+	}
+	previewTag := types.Tag{Key: aws.String(TagPreviewOf), Value: aws.String(from)}
+	registerResult, err := svc.RegisterTaskDefinition(ctx, registerTaskDefinitionInput(
+		taskDefinition,
+		mergeTags(
+			mergeTags(withoutToolTags(describeTaskResult.Tags), tags...),
+			previewTag, imageTagsTag(taskDefinition.ContainerDefinitions),
+		),
+	))
+	if err != nil {
+		logger.WithError(err).Error("Can't register task definition")
+		return err
+	}
+	taskDefinitionArn := aws.ToString(registerResult.TaskDefinition.TaskDefinitionArn)
+	logger.WithField("task_definition_arn", taskDefinitionArn).Debug("Registered the task definition")
+
+	createResult, err := svc.CreateService(ctx, createServiceInput(
+		&service,
+		name,
+		taskDefinitionArn,
+		// i.e. the paused state of the source service isn't the state of the preview
+		mergeTags(withoutToolTags(service.Tags), previewTag),
+		opts,
+	))
+	if err != nil {
+		logger.WithError(err).Error("Can't create the service")
+		// DestroyPreview can't find the family without the service, so it's cleaned up here
+		logger := logger.WithField("task_definition_arn", taskDefinitionArn)
+		if _, deregisterErr := svc.DeregisterTaskDefinition(context.WithoutCancel(ctx), &ecs.DeregisterTaskDefinitionInput{
+			TaskDefinition: aws.String(taskDefinitionArn),
+		}); deregisterErr != nil {
+			logger.WithError(deregisterErr).Error("Can't deregister task definition")
+		} else {
+			logger.Debug("Deregistered the task definition")
+		}
+		return err
+	}
+	logger.Info("Created the service")
+
+	var wg sync.WaitGroup
+	poller := newServicePoller(svc, cluster)
+	stopPolling := poller.start(ctx)
+	stop := watchServiceEvents(logger, poller, name, &wg)
+	err = poller.waitStable(ctx, aws.ToString(createResult.Service.ServiceArn), servicesStableTimeout)
+	stop()
+	wg.Wait()
+	stopPolling()
+	if err != nil {
+		logger.WithError(err).Error("The waiter has been finished with an error")
+		return err
+	}
+	logger.Info("Service has been deployed")
+	return nil
+}
+
+// DestroyPreview scales the preview service down, deletes it and deregisters its task definitions.
+// Only services created by CreatePreview can be destroyed.
+func DestroyPreview(ctx context.Context, profile, cluster, name string) error {
+	err := makeConfig(ctx, profile)
+	if err != nil {
+		return err
+	}
+	logger := log.WithFields(log.Fields{
+		"cluster": cluster,
+		"service": name,
+	})
+	svc := ecs.NewFromConfig(localConfig)
+
+	described, err := describeServicesWithTags(ctx, svc, cluster, []string{name})
+	if err != nil {
+		logger.WithError(err).Error("Can't describe service")
+		return err
+	}
+	service := described[0]
+	isPreview := false
+	for _, tag := range service.Tags {
+		if aws.ToString(tag.Key) == TagPreviewOf {
+			isPreview = true
+		}
+	}
+	if !isPreview {
+		err := fmt.Errorf("service %s isn't a preview, it doesn't have the %s tag", name, TagPreviewOf)
+		logger.Error(err.Error())
+		return err
+	}
+
+	if aws.ToString(service.Status) == "ACTIVE" {
+		poller := newServicePoller(svc, cluster)
+		stopPolling := poller.start(ctx)
+		err := updateServiceWith(ctx, logger, poller, &ecs.UpdateServiceInput{
+			Cluster:      aws.String(cluster),
+			Service:      aws.String(name),
+			DesiredCount: aws.Int32(0),
+		})
+		stopPolling()
+		if err != nil {
+			return err
+		}
+		if _, err := svc.DeleteService(ctx, &ecs.DeleteServiceInput{
+			Cluster: aws.String(cluster),
+			Service: aws.String(name),
+		}); err != nil {
+			logger.WithError(err).Error("Can't delete the service")
+			return err
+		}
+		logger.Info("Deleted the service")
+		if err := ecs.NewServicesInactiveWaiter(svc, func(o *ecs.ServicesInactiveWaiterOptions) {
+			o.Retryable = tolerateThrottling(o.Retryable)
+		}).Wait(ctx, &ecs.DescribeServicesInput{
+			Cluster:  aws.String(cluster),
+			Services: []string{name},
+		}, servicesStableTimeout); err != nil {
+			logger.WithError(err).Error("The waiter has been finished with an error")
+			return err
+		}
+	}
+
+	// the preview has its own task definition family, so all of its revisions can go
+	family := taskDefinitionFamily(aws.ToString(service.TaskDefinition))
+	if family != name {
+		logger.WithField("family", family).Warn("Task definition family isn't named after the preview, won't deregister it")
+		return nil
+	}
+	var revisions []string
+	paginator := ecs.NewListTaskDefinitionsPaginator(svc, &ecs.ListTaskDefinitionsInput{
+		FamilyPrefix: aws.String(family),
+		Status:       types.TaskDefinitionStatusActive,
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			logger.WithError(err).Error("Can't list task definitions")
+			return err
+		}
+		revisions = append(revisions, page.TaskDefinitionArns...)
+	}
+	for _, revision := range revisions {
+		// never touch revisions of any other family
+		if taskDefinitionFamily(revision) != family {
+			continue
+		}
+		logger := logger.WithField("task_definition_arn", revision)
+		if _, err := svc.DeregisterTaskDefinition(ctx, &ecs.DeregisterTaskDefinitionInput{
+			TaskDefinition: aws.String(revision),
+		}); err != nil {
+			logger.WithError(err).Error("Can't deregister task definition")
+			return err
+		}
+		logger.Debug("Deregistered the task definition")
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+)
+
+// describeTasksBatch is the maximum number of tasks DescribeTasks accepts
+const describeTasksBatch = 100
+
+// listClusterTasks describes the tasks of the cluster, optionally limited to a service,
+// with the given desired status (RUNNING or STOPPED)
+func listClusterTasks(ctx context.Context, svc *ecs.Client, cluster, service string, desiredStatus types.DesiredStatus) ([]types.Task, error) {
+	input := &ecs.ListTasksInput{
+		Cluster:       aws.String(cluster),
+		DesiredStatus: desiredStatus,
+	}
+	if service != "" {
+		input.ServiceName = aws.String(service)
+	}
+	var taskArns []string
+	paginator := ecs.NewListTasksPaginator(svc, input)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("can't list tasks: %w", err)
+		}
+		taskArns = append(taskArns, page.TaskArns...)
+	}
+
+	var tasks []types.Task
+	for len(taskArns) > 0 {
+		n := len(taskArns)
+		if n > describeTasksBatch {
+			n = describeTasksBatch
+		}
+		describeResult, err := svc.DescribeTasks(ctx, &ecs.DescribeTasksInput{
+			Cluster: aws.String(cluster),
+			Tasks:   taskArns[:n],
+		})
+		if err != nil {
+			return nil, fmt.Errorf("can't describe tasks: %w", err)
+		}
+		tasks = append(tasks, describeResult.Tasks...)
+		taskArns = taskArns[n:]
+	}
+	return tasks, nil
+}
+
+// containerExitCodes formats the exit codes of stopped containers, i.e. "app=0 nginx=137"
+func containerExitCodes(task types.Task) string {
+	var codes []string
+	for _, container := range task.Containers {
+		if container.ExitCode != nil {
+			codes = append(codes, fmt.Sprintf("%s=%d", aws.ToString(container.Name), aws.ToInt32(container.ExitCode)))
+		}
+	}
+	return strings.Join(codes, " ")
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return "-"
+	}
+	return t.Local().Format("2006-01-02 15:04:05")
+}
+
+func dashIfEmpty(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// PrintTasks prints running tasks of the cluster or the service, and the recently stopped ones if asked
+func PrintTasks(ctx context.Context, profile, cluster, service string, stopped bool) error {
+	err := makeConfig(ctx, profile)
+	if err != nil {
+		return err
+	}
+	svc := ecs.NewFromConfig(localConfig)
+
+	tasks, err := listClusterTasks(ctx, svc, cluster, service, types.DesiredStatusRunning)
+	if err != nil {
+		return err
+	}
+	if stopped {
+		stoppedTasks, err := listClusterTasks(ctx, svc, cluster, service, types.DesiredStatusStopped)
+		if err != nil {
+			return err
+		}
+		tasks = append(tasks, stoppedTasks...)
+	}
+	sort.SliceStable(tasks, func(i, j int) bool {
+		return aws.ToTime(tasks[i].CreatedAt).After(aws.ToTime(tasks[j].CreatedAt))
+	})
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "TASK ID\tTASK DEFINITION\tLAUNCH TYPE\tSTATUS\tHEALTH\tSTARTED\tSTOPPED\tEXIT CODES\tREASON")
+	for _, task := range tasks {
+		taskID, err := parseTaskUUID(task.TaskArn)
+		if err != nil {
+			taskID = aws.ToString(task.TaskArn)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			taskID,
+			taskDefinitionName(aws.ToString(task.TaskDefinitionArn)),
+			task.LaunchType,
+			aws.ToString(task.LastStatus),
+			task.HealthStatus,
+			formatTime(task.StartedAt),
+			formatTime(task.StoppedAt),
+			dashIfEmpty(containerExitCodes(task)),
+			dashIfEmpty(aws.ToString(task.StoppedReason)),
+		)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,42 @@
+package lib
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/ratelimit"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+)
+
+// DefaultMaxAttempts is how many times an AWS call is attempted before giving up
+const DefaultMaxAttempts = 10
+
+// newRetryer makes the retryer shared by all the clients, so that they slow down together when AWS
+// throttles any of them. The retries back off exponentially with jitter
+func newRetryer(maxAttempts int) aws.Retryer {
+	if maxAttempts < 1 {
+		maxAttempts = DefaultMaxAttempts
+	}
+	return retry.NewAdaptiveMode(func(o *retry.AdaptiveModeOptions) {
+		o.StandardOptions = append(o.StandardOptions, func(o *retry.StandardOptions) {
+			o.MaxAttempts = maxAttempts
+			// the adaptive rate limit takes care of the throttles, the retry quota would fail the calls instead
+			o.RateLimiter = ratelimit.None
+		})
+	})
+}
+
+// isThrottleError tells if AWS throttled the call, which isn't a reason to roll anything back
+func isThrottleError(err error) bool {
+	return err != nil && retry.IsErrorThrottles(retry.DefaultThrottles).IsErrorThrottle(err) == aws.TrueTernary
+}
+
+// tolerateThrottling keeps a waiter waiting when a poll is throttled instead of failing the wait
+func tolerateThrottling[I, O any](retryable func(context.Context, I, O, error) (bool, error)) func(context.Context, I, O, error) (bool, error) {
+	return func(ctx context.Context, input I, output O, err error) (bool, error) {
+		if isThrottleError(err) {
+			return true, nil
+		}
+		return retryable(ctx, input, output, err)
+	}
+}
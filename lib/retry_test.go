@@ -0,0 +1,40 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/aws/smithy-go"
+)
+
+func TestIsThrottleError(t *testing.T) {
+	throttle := &smithy.GenericAPIError{Code: "ThrottlingException", Message: "Rate exceeded"}
+	if !isThrottleError(&retry.MaxAttemptsError{Attempt: 10, Err: throttle}) {
+		t.Fatal("a throttle should be found through the retry errors")
+	}
+	if isThrottleError(&smithy.GenericAPIError{Code: "ServiceNotFoundException"}) || isThrottleError(nil) {
+		t.Fatal("only throttles are throttles")
+	}
+
+	failed := errors.New("failed")
+	retryable := tolerateThrottling(func(ctx context.Context, input, output *struct{}, err error) (bool, error) {
+		return false, err
+	})
+	if retry, err := retryable(context.Background(), nil, nil, throttle); !retry || err != nil {
+		t.Fatalf("a throttled poll should be retried, got %v, %v", retry, err)
+	}
+	if _, err := retryable(context.Background(), nil, nil, failed); err != failed {
+		t.Fatalf("other errors should stop the waiter, got %v", err)
+	}
+}
+
+func TestNewRetryer(t *testing.T) {
+	if attempts := newRetryer(0).MaxAttempts(); attempts != DefaultMaxAttempts {
+		t.Fatalf("expected the default attempts, got %d", attempts)
+	}
+	if attempts := newRetryer(3).MaxAttempts(); attempts != 3 {
+		t.Fatalf("expected 3 attempts, got %d", attempts)
+	}
+}
@@ -1,190 +1,246 @@
 package lib
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/apex/log"
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
 )
 
-// RunTask runs the specified one-off task in the cluster using the task definition
-func RunTask(profile, cluster, service, taskDefinitionName, imageTag string, imageTags []string, workDir, containerName, awslogGroup, launchType string, args []string) (exitCode int, err error) {
-	ctx := log.WithFields(log.Fields{
-		"task_definition": taskDefinitionName,
-		"launch_type":     launchType,
-	})
-	err = makeSession(profile)
-	if err != nil {
-		return 1, err
+// RunOptions are the one-off task to run with RunTask or RunFargate
+type RunOptions struct {
+	Profile string
+	Cluster string
+	// Service to copy the network configuration from, RunTask only
+	Service        string
+	TaskDefinition string
+	// ImageTag replaces the image tags of all the containers, ImageTags the ones of the containers in order
+	ImageTag  string
+	ImageTags []string
+	WorkDir   string
+	// ContainerName is the container the command runs in
+	ContainerName string
+	// LogGroup catches the output of the command, which is returned in RunResult.Logs. Nothing is caught if it's empty
+	LogGroup   string
+	LaunchType string
+	// SecurityGroupFilter picks the security groups by name, RunFargate only
+	SecurityGroupFilter string
+	Command             []string
+}
+
+// ContainerResult is how a container of the task exited
+type ContainerResult struct {
+	Name     string `json:"name"`
+	ExitCode int    `json:"exit_code"`
+	// Reason is set instead of the exit code if the container didn't run
+	Reason string `json:"reason,omitempty"`
+}
+
+// RunResult is what the one-off task did
+type RunResult struct {
+	// TaskDefinitionArn is the revision registered for the task, it's deregistered afterwards
+	TaskDefinitionArn string            `json:"task_definition_arn,omitempty"`
+	TaskArns          []string          `json:"task_arns,omitempty"`
+	Containers        []ContainerResult `json:"containers,omitempty"`
+	// ExitCode is the exit code of the container the command ran in
+	ExitCode int `json:"-"`
+	// Logs are the output of the command, if RunOptions.LogGroup is set
+	Logs []string `json:"logs,omitempty"`
+}
+
+// RunTask runs the command as a one-off task using a copy of the task definition, and waits for it to stop.
+// A non-zero exit code of the command isn't an error, it's in the result
+func RunTask(ctx context.Context, opts RunOptions) (*RunResult, error) {
+	if err := makeConfig(ctx, opts.Profile); err != nil {
+		return nil, err
 	}
+	return runTask(ctx, opts, opts.Command, nil)
+}
 
-	svc := ecs.New(localSession)
+// runTask runs the task with the command, in the network of the service if there's no network configuration
+func runTask(ctx context.Context, opts RunOptions, command []string, networkConfiguration *types.NetworkConfiguration) (*RunResult, error) {
+	logger := log.WithFields(log.Fields{
+		"task_definition": opts.TaskDefinition,
+		"launch_type":     opts.LaunchType,
+	})
+	svc := ecs.NewFromConfig(localConfig)
 
-	describeResult, err := svc.DescribeTaskDefinition(&ecs.DescribeTaskDefinitionInput{
-		TaskDefinition: aws.String(taskDefinitionName),
-		Include:        aws.StringSlice([]string{"TAGS"}),
+	describeResult, err := svc.DescribeTaskDefinition(ctx, &ecs.DescribeTaskDefinitionInput{
+		TaskDefinition: aws.String(opts.TaskDefinition),
+		Include:        []types.TaskDefinitionField{types.TaskDefinitionFieldTags},
 	})
 	if err != nil {
-		ctx.WithError(err).Error("Can't get task definition")
-		return 1, err
+		logger.WithError(err).Error("Can't get task definition")
+		return nil, err
 	}
 	taskDefinition := describeResult.TaskDefinition
 
 	var foundContainerName bool
-	if err := modifyContainerDefinitionImages(imageTag, imageTags, workDir, taskDefinition.ContainerDefinitions, ctx); err != nil {
-		return 1, err
+	if err := modifyContainerDefinitionImages(opts.ImageTag, opts.ImageTags, opts.WorkDir, taskDefinition.ContainerDefinitions, logger); err != nil {
+		return nil, err
 	}
 	for n, containerDefinition := range taskDefinition.ContainerDefinitions {
-		if aws.StringValue(containerDefinition.Name) == containerName {
+		if aws.ToString(containerDefinition.Name) == opts.ContainerName {
 			foundContainerName = true
-			taskDefinition.ContainerDefinitions[n].Command = aws.StringSlice(args)
-			if awslogGroup != "" {
+			taskDefinition.ContainerDefinitions[n].Command = command
+			if opts.LogGroup != "" {
 				// modify log output driver to capture output to a predefined CloudWatch log
-				taskDefinition.ContainerDefinitions[n].LogConfiguration = &ecs.LogConfiguration{
-					LogDriver: aws.String("awslogs"),
-					Options: map[string]*string{
-						"awslogs-region":        localSession.Config.Region,
-						"awslogs-group":         aws.String(awslogGroup),
-						"awslogs-stream-prefix": aws.String(cluster),
+				taskDefinition.ContainerDefinitions[n].LogConfiguration = &types.LogConfiguration{
+					LogDriver: types.LogDriverAwslogs,
+					Options: map[string]string{
+						"awslogs-region":        localConfig.Region,
+						"awslogs-group":         opts.LogGroup,
+						"awslogs-stream-prefix": opts.Cluster,
 					},
 				}
 			}
 		}
 	}
 	if !foundContainerName {
-		err := fmt.Errorf("Can't find container with specified name in the task definition")
-		ctx.WithFields(log.Fields{"container_name": containerName}).Error(err.Error())
-		return 1, err
+		err := fmt.Errorf("%w: %s", ErrContainerNotFound, opts.ContainerName)
+		logger.WithFields(log.Fields{"container_name": opts.ContainerName}).Error(err.Error())
+		return nil, err
 	}
-	registerResult, err := svc.RegisterTaskDefinition(&ecs.RegisterTaskDefinitionInput{
-		ContainerDefinitions:    taskDefinition.ContainerDefinitions,
-		Cpu:                     taskDefinition.Cpu,
-		ExecutionRoleArn:        taskDefinition.ExecutionRoleArn,
-		Family:                  taskDefinition.Family,
-		Memory:                  taskDefinition.Memory,
-		NetworkMode:             taskDefinition.NetworkMode,
-		PlacementConstraints:    taskDefinition.PlacementConstraints,
-		RequiresCompatibilities: taskDefinition.Compatibilities,
-		TaskRoleArn:             taskDefinition.TaskRoleArn,
-		Volumes:                 taskDefinition.Volumes,
-		Tags:                    nilIfEmpty(describeResult.Tags),
-	})
+	registerResult, err := svc.RegisterTaskDefinition(ctx, registerTaskDefinitionInput(taskDefinition, describeResult.Tags))
 	if err != nil {
-		ctx.WithError(err).Error("Can't register task definition")
-		return 1, err
+		logger.WithError(err).Error("Can't register task definition")
+		return nil, err
 	}
-	ctx.WithField(
-		"task_definition_arn",
-		aws.StringValue(registerResult.TaskDefinition.TaskDefinitionArn),
-	).Debug("Registered the task definition")
+	result := &RunResult{TaskDefinitionArn: aws.ToString(registerResult.TaskDefinition.TaskDefinitionArn)}
+	logger.WithField("task_definition_arn", result.TaskDefinitionArn).Debug("Registered the task definition")
 
 	// deregister the task definition
 	defer func() {
-		ctx = ctx.WithFields(log.Fields{"task_definition_arn": aws.StringValue(registerResult.TaskDefinition.TaskDefinitionArn)})
-		ctx.Debug("Deregistered the task definition")
-		_, err = svc.DeregisterTaskDefinition(&ecs.DeregisterTaskDefinitionInput{
+		logger := logger.WithFields(log.Fields{"task_definition_arn": result.TaskDefinitionArn})
+		_, err := svc.DeregisterTaskDefinition(context.WithoutCancel(ctx), &ecs.DeregisterTaskDefinitionInput{
 			TaskDefinition: registerResult.TaskDefinition.TaskDefinitionArn,
 		})
 		if err != nil {
-			ctx.WithError(err).Error("Can't deregister task definition")
+			logger.WithError(err).Error("Can't deregister task definition")
+			return
 		}
+		logger.Debug("Deregistered the task definition")
 	}()
 
-	runTaskInput := ecs.RunTaskInput{
-		Cluster:        aws.String(cluster),
-		TaskDefinition: registerResult.TaskDefinition.TaskDefinitionArn,
-		Count:          aws.Int64(1),
-		StartedBy:      aws.String("go-deploy"),
-		LaunchType:     aws.String(launchType),
-	}
-
-	if service != "" {
-		services, err := svc.DescribeServices(&ecs.DescribeServicesInput{
-			Cluster:  aws.String(cluster),
-			Services: []*string{aws.String(service)},
+	if networkConfiguration == nil && opts.Service != "" {
+		services, err := svc.DescribeServices(ctx, &ecs.DescribeServicesInput{
+			Cluster:  aws.String(opts.Cluster),
+			Services: []string{opts.Service},
 		})
 		if err != nil {
-			ctx.WithError(err).Error("Can't get service")
-			return 1, err
+			logger.WithError(err).Error("Can't get service")
+			return result, err
 		}
-
-		runTaskInput.NetworkConfiguration = services.Services[0].NetworkConfiguration
+		if len(services.Services) == 0 {
+			return result, fmt.Errorf("can't find service %s", opts.Service)
+		}
+		networkConfiguration = services.Services[0].NetworkConfiguration
 	}
 
-	runResult, err := svc.RunTask(&runTaskInput)
+	runResult, err := svc.RunTask(ctx, &ecs.RunTaskInput{
+		Cluster:              aws.String(opts.Cluster),
+		TaskDefinition:       registerResult.TaskDefinition.TaskDefinitionArn,
+		Count:                aws.Int32(1),
+		StartedBy:            aws.String("go-deploy"),
+		LaunchType:           types.LaunchType(opts.LaunchType),
+		NetworkConfiguration: networkConfiguration,
+	})
 	if err != nil {
-		ctx.WithError(err).Error("Can't run specified task")
-		return 1, err
+		logger.WithError(err).Error("Can't run specified task")
+		return result, err
 	}
 
 	// if there are no running/pending tasks, then it failed to start
 	if len(runResult.Tasks) == 0 {
-		ctx.Error("No tasks could be run. Please check if the ECS cluster has enough resources")
-		return 1, err
+		err := ErrNoTaskStarted
+		for _, failure := range runResult.Failures {
+			err = fmt.Errorf("%w: %s", ErrNoTaskStarted, aws.ToString(failure.Reason))
+		}
+		logger.WithError(err).Error("No tasks could be run. Please check if the ECS cluster has enough resources")
+		return result, err
 	}
 	// the task should be in PENDING state at this point
 
-	ctx.Info("Waiting for the task to finish")
-	var tasks []*string
+	logger.Info("Waiting for the task to finish")
 	for _, task := range runResult.Tasks {
-		tasks = append(tasks, task.TaskArn)
-		ctx.WithField("task_arn", aws.StringValue(task.TaskArn)).Debug("Started task")
+		result.TaskArns = append(result.TaskArns, aws.ToString(task.TaskArn))
+		logger.WithField("task_arn", aws.ToString(task.TaskArn)).Debug("Started task")
 	}
 	tasksInput := &ecs.DescribeTasksInput{
-		Cluster: aws.String(cluster),
-		Tasks:   tasks,
+		Cluster: aws.String(opts.Cluster),
+		Tasks:   result.TaskArns,
 	}
-	err = svc.WaitUntilTasksStopped(tasksInput)
+	err = ecs.NewTasksStoppedWaiter(svc, func(o *ecs.TasksStoppedWaiterOptions) {
+		o.Retryable = tolerateThrottling(o.Retryable)
+	}).Wait(ctx, tasksInput, tasksStoppedTimeout)
 	if err != nil {
-		ctx.WithError(err).Error("The waiter has been finished with an error")
-		exitCode = 3
+		logger.WithError(err).Error("The waiter has been finished with an error")
+		return result, fmt.Errorf("%w: %w", ErrTaskNotStopped, err)
 	}
-	tasksOutput, err := svc.DescribeTasks(tasksInput)
+	tasksOutput, err := svc.DescribeTasks(ctx, tasksInput)
 	if err != nil {
-		ctx.WithError(err).Error("Can't describe stopped tasks")
-		return 1, err
+		logger.WithError(err).Error("Can't describe stopped tasks")
+		return result, err
 	}
+
 	for _, task := range tasksOutput.Tasks {
 		for _, container := range task.Containers {
-			ctx := log.WithFields(log.Fields{
-				"container_name": aws.StringValue(container.Name),
+			containerResult := ContainerResult{
+				Name:     aws.ToString(container.Name),
+				ExitCode: int(aws.ToInt32(container.ExitCode)),
+				Reason:   aws.ToString(container.Reason),
+			}
+			result.Containers = append(result.Containers, containerResult)
+
+			logger := log.WithFields(log.Fields{
+				"container_name": containerResult.Name,
 			})
-			reason := aws.StringValue(container.Reason)
-			if len(reason) != 0 {
-				exitCode = 11
-				ctx = ctx.WithField("reason", reason)
+			if containerResult.Reason != "" {
+				logger = logger.WithField("reason", containerResult.Reason)
 			} else {
-				ctx = ctx.WithField("exit_code", aws.Int64Value(container.ExitCode))
-
+				logger = logger.WithField("exit_code", containerResult.ExitCode)
 			}
-			if aws.Int64Value(container.ExitCode) == 0 && len(reason) == 0 {
-				ctx.Info("Container exited")
+			if containerResult.ExitCode == 0 && containerResult.Reason == "" {
+				logger.Info("Container exited")
 			} else {
-				ctx.Error("Container exited")
+				logger.Error("Container exited")
+			}
+
+			if containerResult.Name != opts.ContainerName {
+				continue
 			}
-			if aws.StringValue(container.Name) == containerName {
-				if len(reason) == 0 {
-					exitCode = int(aws.Int64Value(container.ExitCode))
-					if awslogGroup != "" {
-						// get log output
-						taskUUID, err := parseTaskUUID(container.TaskArn)
-						if err != nil {
-							log.WithFields(log.Fields{"task_arn": aws.StringValue(container.TaskArn)}).WithError(err).Error("Can't parse task uuid")
-							exitCode = 10
-							continue
-						}
-						err = fetchCloudWatchLog(cluster, containerName, awslogGroup, taskUUID, false, ctx)
-						if err != nil {
-							log.WithError(err).Error("Can't fetch the logs")
-							exitCode = 10
-						}
-					}
+			if containerResult.Reason != "" {
+				err = fmt.Errorf("%w: %s", ErrContainerNotRun, containerResult.Reason)
+				continue
+			}
+			result.ExitCode = containerResult.ExitCode
+			if opts.LogGroup != "" {
+				// get log output
+				taskUUID, parseErr := parseTaskUUID(container.TaskArn)
+				if parseErr != nil {
+					log.WithFields(log.Fields{"task_arn": aws.ToString(container.TaskArn)}).WithError(parseErr).Error("Can't parse task uuid")
+					err = fmt.Errorf("%w: %w", ErrLogsUnavailable, parseErr)
+					continue
+				}
+				result.Logs, parseErr = fetchCloudWatchLog(ctx, opts.Cluster, opts.ContainerName, opts.LogGroup, taskUUID, false, logger)
+				if parseErr != nil {
+					log.WithError(parseErr).Error("Can't fetch the logs")
+					err = fmt.Errorf("%w: %w", ErrLogsUnavailable, parseErr)
 				}
 			}
 		}
 	}
 
-	return
+	notify(NotifyEvent{
+		Event:          EventTaskExited,
+		Cluster:        opts.Cluster,
+		TaskDefinition: opts.TaskDefinition,
+		ImageTag:       opts.ImageTag,
+		ExitCode:       &result.ExitCode,
+	})
 
+	return result, err
 }
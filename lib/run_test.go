@@ -0,0 +1,72 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// fakeECS answers the ECS calls of a task that can't be placed and remembers which ones it got
+func fakeECS(t *testing.T, targets *[]string) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		target := strings.TrimPrefix(r.Header.Get("X-Amz-Target"), "AmazonEC2ContainerServiceV20141113.")
+		*targets = append(*targets, target)
+		w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+		switch target {
+		case "DescribeTaskDefinition", "RegisterTaskDefinition":
+			fmt.Fprint(w, `{"taskDefinition": {
+				"taskDefinitionArn": "arn:aws:ecs:us-east-1:1:task-definition/app:2",
+				"family": "app",
+				"containerDefinitions": [{"name": "app", "image": "app:latest"}]
+			}}`)
+		case "RunTask":
+			fmt.Fprint(w, `{"tasks": [], "failures": [{"reason": "RESOURCE:MEMORY"}]}`)
+		default:
+			fmt.Fprint(w, "{}")
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestRunTaskErrors(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(dir, "config"))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(dir, "credentials"))
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKID")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	var targets []string
+	defer ConfigureAWS(awsConfig)
+	ConfigureAWS(AWSConfig{Region: "us-east-1", EndpointURL: fakeECS(t, &targets).URL})
+	cfg, err := newConfig(context.Background(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(cfg aws.Config, loaded bool) { localConfig, localConfigLoaded = cfg, loaded }(localConfig, localConfigLoaded)
+	localConfig, localConfigLoaded = cfg, true
+
+	opts := RunOptions{Cluster: "cluster", TaskDefinition: "app", ContainerName: "web", Command: []string{"true"}}
+	if _, err := RunTask(context.Background(), opts); !errors.Is(err, ErrContainerNotFound) {
+		t.Fatalf("expected ErrContainerNotFound, got %v", err)
+	}
+
+	targets = nil
+	opts.ContainerName = "app"
+	result, err := RunTask(context.Background(), opts)
+	if !errors.Is(err, ErrNoTaskStarted) || !strings.Contains(err.Error(), "RESOURCE:MEMORY") {
+		t.Fatalf("expected ErrNoTaskStarted with the failure reason, got %v", err)
+	}
+	if result == nil || result.TaskDefinitionArn != "arn:aws:ecs:us-east-1:1:task-definition/app:2" {
+		t.Fatalf("the registered task definition should be in the result, got %+v", result)
+	}
+	if last := targets[len(targets)-1]; last != "DeregisterTaskDefinition" {
+		t.Fatalf("the task definition should be deregistered, got %v", targets)
+	}
+}
@@ -0,0 +1,226 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/apex/log"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge"
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge/types"
+)
+
+// ScheduledTasks selects the EventBridge rules whose ECS targets are updated by deploy
+type ScheduledTasks struct {
+	Rules []string `mapstructure:"rules"`
+	// All picks every rule targeting the cluster with the same task definition family as the service
+	All bool `mapstructure:"all"`
+	// EventBus is the name or ARN of the event bus the rules are on, the default one if it's empty
+	EventBus string `mapstructure:"event_bus"`
+}
+
+// Enabled tells if deploy should touch scheduled tasks at all
+func (s ScheduledTasks) Enabled() bool {
+	return s.All || len(s.Rules) > 0
+}
+
+// scheduledTarget is an ECS target of an EventBridge rule
+type scheduledTarget struct {
+	rule     string
+	eventBus string
+	target   types.Target
+	// taskDefinitionArn is the revision the target pointed at before deploy
+	taskDefinitionArn string
+}
+
+// eventBusName is nil for the default event bus
+func eventBusName(eventBus string) *string {
+	if eventBus == "" {
+		return nil
+	}
+	return aws.String(eventBus)
+}
+
+// rulesTargetingCluster lists the names of rules on the event bus having the cluster as a target
+func rulesTargetingCluster(ctx context.Context, svc *eventbridge.Client, eventBus, clusterArn string) ([]string, error) {
+	var rules []string
+	input := &eventbridge.ListRuleNamesByTargetInput{
+		TargetArn:    aws.String(clusterArn),
+		EventBusName: eventBusName(eventBus),
+	}
+	for {
+		result, err := svc.ListRuleNamesByTarget(ctx, input)
+		if err != nil {
+			return nil, fmt.Errorf("can't list rules targeting %s: %w", clusterArn, err)
+		}
+		rules = append(rules, result.RuleNames...)
+		if result.NextToken == nil {
+			break
+		}
+		input.NextToken = result.NextToken
+	}
+	return rules, nil
+}
+
+// ecsTargets lists the ECS targets of the rule that run tasks in the cluster
+func ecsTargets(ctx context.Context, svc *eventbridge.Client, eventBus, rule, clusterArn string) ([]types.Target, error) {
+	var targets []types.Target
+	input := &eventbridge.ListTargetsByRuleInput{
+		Rule:         aws.String(rule),
+		EventBusName: eventBusName(eventBus),
+	}
+	for {
+		result, err := svc.ListTargetsByRule(ctx, input)
+		if err != nil {
+			return nil, fmt.Errorf("can't list targets of rule %s: %w", rule, err)
+		}
+		for _, target := range result.Targets {
+			if target.EcsParameters != nil && aws.ToString(target.Arn) == clusterArn {
+				targets = append(targets, target)
+			}
+		}
+		if result.NextToken == nil {
+			break
+		}
+		input.NextToken = result.NextToken
+	}
+	return targets, nil
+}
+
+// findScheduledTargets finds the targets running the task definition family in the cluster
+func findScheduledTargets(ctx context.Context, svc *eventbridge.Client, clusterArn, family string, scheduled ScheduledTasks) ([]scheduledTarget, error) {
+	rules := scheduled.Rules
+	if scheduled.All {
+		all, err := rulesTargetingCluster(ctx, svc, scheduled.EventBus, clusterArn)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(append([]string{}, rules...), all...)
+	}
+
+	var found []scheduledTarget
+	seen := make(map[string]bool)
+	for _, rule := range rules {
+		if seen[rule] {
+			continue
+		}
+		seen[rule] = true
+		targets, err := ecsTargets(ctx, svc, scheduled.EventBus, rule, clusterArn)
+		if err != nil {
+			return nil, err
+		}
+		for _, target := range targets {
+			taskDefinitionArn := aws.ToString(target.EcsParameters.TaskDefinitionArn)
+			if taskDefinitionFamily(taskDefinitionArn) == family {
+				found = append(found, scheduledTarget{
+					rule:              rule,
+					eventBus:          scheduled.EventBus,
+					target:            target,
+					taskDefinitionArn: taskDefinitionArn,
+				})
+			}
+		}
+	}
+	return found, nil
+}
+
+// updateScheduledTargets points the targets at the task definition.
+// If taskDefinitionArn is empty, the targets are restored to their previous revisions
+func updateScheduledTargets(ctx context.Context, logger log.Interface, svc *eventbridge.Client, targets []scheduledTarget, taskDefinitionArn string) error {
+	byRule := make(map[string][]types.Target)
+	eventBuses := make(map[string]string)
+	var rules []string
+	for _, t := range targets {
+		arn := taskDefinitionArn
+		if arn == "" {
+			arn = t.taskDefinitionArn
+		}
+		target := t.target
+		ecsParameters := *t.target.EcsParameters
+		ecsParameters.TaskDefinitionArn = aws.String(arn)
+		target.EcsParameters = &ecsParameters
+
+		if _, ok := byRule[t.rule]; !ok {
+			rules = append(rules, t.rule)
+		}
+		byRule[t.rule] = append(byRule[t.rule], target)
+		eventBuses[t.rule] = t.eventBus
+	}
+
+	for _, rule := range rules {
+		logger := logger.WithField("rule", rule)
+		result, err := svc.PutTargets(ctx, &eventbridge.PutTargetsInput{
+			Rule:         aws.String(rule),
+			EventBusName: eventBusName(eventBuses[rule]),
+			Targets:      byRule[rule],
+		})
+		if err != nil {
+			logger.WithError(err).Error("Can't update the scheduled task")
+			return err
+		}
+		if result.FailedEntryCount > 0 {
+			err := fmt.Errorf("%s", aws.ToString(result.FailedEntries[0].ErrorMessage))
+			logger.WithError(err).Error("Can't update the scheduled task")
+			return err
+		}
+		logger.Info("Updated the scheduled task")
+	}
+	return nil
+}
+
+// ListSchedules prints the rules on the event bus targeting the cluster, their schedules and task definitions.
+// The default event bus is used if eventBus is empty
+func ListSchedules(ctx context.Context, profile, cluster, eventBus string) error {
+	err := makeConfig(ctx, profile)
+	if err != nil {
+		return err
+	}
+
+	clusters, err := ecs.NewFromConfig(localConfig).DescribeClusters(ctx, &ecs.DescribeClustersInput{
+		Clusters: []string{cluster},
+	})
+	if err != nil {
+		return err
+	}
+	if len(clusters.Clusters) == 0 {
+		return fmt.Errorf("can't find cluster %s", cluster)
+	}
+	clusterArn := aws.ToString(clusters.Clusters[0].ClusterArn)
+
+	svc := eventbridge.NewFromConfig(localConfig)
+	rules, err := rulesTargetingCluster(ctx, svc, eventBus, clusterArn)
+	if err != nil {
+		return err
+	}
+	sort.Strings(rules)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "RULE\tSTATE\tSCHEDULE\tTASK DEFINITION\tCOUNT")
+	for _, rule := range rules {
+		describeResult, err := svc.DescribeRule(ctx, &eventbridge.DescribeRuleInput{
+			Name:         aws.String(rule),
+			EventBusName: eventBusName(eventBus),
+		})
+		if err != nil {
+			return fmt.Errorf("can't describe rule %s: %w", rule, err)
+		}
+		targets, err := ecsTargets(ctx, svc, eventBus, rule, clusterArn)
+		if err != nil {
+			return err
+		}
+		for _, target := range targets {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\n",
+				rule,
+				describeResult.State,
+				aws.ToString(describeResult.ScheduleExpression),
+				taskDefinitionName(aws.ToString(target.EcsParameters.TaskDefinitionArn)),
+				aws.ToInt32(target.EcsParameters.TaskCount),
+			)
+		}
+	}
+	return w.Flush()
+}
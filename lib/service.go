@@ -0,0 +1,118 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+)
+
+// tasksStoppedTimeout is how long to wait for tasks to stop
+const tasksStoppedTimeout = 10 * time.Minute
+
+// updateServices updates the services in parallel, streaming their events and waiting for them to become stable
+func updateServices(ctx context.Context, logger log.Interface, cluster string, services []string, makeInput func(service string) *ecs.UpdateServiceInput) error {
+	errs := make(chan error, len(services))
+	poller := newServicePoller(ecs.NewFromConfig(localConfig), cluster)
+	defer poller.start(ctx)()
+
+	var wg sync.WaitGroup
+	for _, service := range services {
+		service := service // go catch
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			logger := logger.WithField("service", service)
+			stop := watchServiceEvents(logger, poller, service, &wg)
+			defer stop()
+
+			input := makeInput(service)
+			input.Cluster = aws.String(cluster)
+			input.Service = aws.String(service)
+			errs <- updateServiceWith(ctx, logger, poller, input)
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			return fmt.Errorf("one of the services failed to update: %w", err)
+		}
+	}
+	return nil
+}
+
+// ScaleServices sets the desired count of the services and waits for them to become stable
+func ScaleServices(ctx context.Context, profile, cluster string, services []string, count int32) error {
+	err := makeConfig(ctx, profile)
+	if err != nil {
+		return err
+	}
+	logger := log.WithFields(log.Fields{
+		"cluster": cluster,
+		"count":   count,
+	})
+	logger.Info("Scaling")
+	return updateServices(ctx, logger, cluster, services, func(service string) *ecs.UpdateServiceInput {
+		return &ecs.UpdateServiceInput{
+			DesiredCount: aws.Int32(count),
+		}
+	})
+}
+
+// RestartServices forces a new deployment of the services with their current task definitions
+func RestartServices(ctx context.Context, profile, cluster string, services []string) error {
+	err := makeConfig(ctx, profile)
+	if err != nil {
+		return err
+	}
+	logger := log.WithField("cluster", cluster)
+	logger.Info("Restarting")
+	return updateServices(ctx, logger, cluster, services, func(service string) *ecs.UpdateServiceInput {
+		return &ecs.UpdateServiceInput{
+			ForceNewDeployment: true,
+		}
+	})
+}
+
+// StopTask stops the task and waits until it's stopped
+func StopTask(ctx context.Context, profile, cluster, taskID, reason string) error {
+	err := makeConfig(ctx, profile)
+	if err != nil {
+		return err
+	}
+	logger := log.WithFields(log.Fields{
+		"cluster": cluster,
+		"task_id": taskID,
+	})
+	svc := ecs.NewFromConfig(localConfig)
+
+	result, err := svc.StopTask(ctx, &ecs.StopTaskInput{
+		Cluster: aws.String(cluster),
+		Task:    aws.String(taskID),
+		Reason:  aws.String(reason),
+	})
+	if err != nil {
+		logger.WithError(err).Error("Can't stop the task")
+		return err
+	}
+	logger.Info("Stopping the task")
+
+	err = ecs.NewTasksStoppedWaiter(svc, func(o *ecs.TasksStoppedWaiterOptions) {
+		o.Retryable = tolerateThrottling(o.Retryable)
+	}).Wait(ctx, &ecs.DescribeTasksInput{
+		Cluster: aws.String(cluster),
+		Tasks:   []string{aws.ToString(result.Task.TaskArn)},
+	}, tasksStoppedTimeout)
+	if err != nil {
+		logger.WithError(err).Error("The waiter has been finished with an error")
+		return err
+	}
+	logger.Info("Task has been stopped")
+	return nil
+}
@@ -1,136 +1,141 @@
 package lib
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net"
 	"os"
-	"strings"
 	"syscall"
 
 	"github.com/apex/log"
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/service/ec2"
-	"github.com/aws/aws-sdk-go/service/ec2instanceconnect"
-	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2instanceconnect"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
 	"golang.org/x/crypto/ssh/agent"
 )
 
-// ConnectSSH runs ssh with some magic parameters to connect to running containers on AWS ECS
-func ConnectSSH(profile, cluster, taskDefinitionName, containerName, shell, service, instanceUser string, pushSSHKey bool) (exitCode int, err error) {
-	err = makeSession(profile)
-	if err != nil {
-		return 1, err
-	}
-	ctx := log.WithFields(&log.Fields{"task_definition": taskDefinitionName})
-
-	svc := ecs.New(localSession)
-
-	ctx.Info("Looking for ECS Task...")
+// SSHOptions are the container to connect to with ConnectSSH
+type SSHOptions struct {
+	Profile string
+	Cluster string
+	// TaskDefinition picks the task of the service by a part of its task definition ARN
+	TaskDefinition string
+	// TaskID picks the task by its ID or its prefix
+	TaskID string
+	// ContainerName is the container to connect to, it can be left empty if the task runs one
+	ContainerName string
+	Shell         string
+	Service       string
+	// InstanceUser is the user ssh logs into the container instance as
+	InstanceUser string
+	// PushSSHKey sends the public key of the ssh agent with EC2 Instance Connect
+	PushSSHKey bool
+	// Choose picks the task and the container if more than one matches, see TargetOptions
+	Choose Chooser
+}
 
-	listResult, err := svc.ListTasks(&ecs.ListTasksInput{
-		Cluster:     aws.String(cluster),
-		ServiceName: aws.String(service),
-	})
-	if err != nil {
-		ctx.WithError(err).Error("Can't get task list")
-		return 1, err
+// ConnectSSH runs ssh with some magic parameters to connect to running containers on AWS ECS.
+// It replaces the current process with ssh, so it only returns if it fails
+func ConnectSSH(ctx context.Context, opts SSHOptions) error {
+	if err := makeConfig(ctx, opts.Profile); err != nil {
+		return err
 	}
-
-	describeResult, err := svc.DescribeTasks(&ecs.DescribeTasksInput{
-		Cluster: aws.String(cluster),
-		Tasks:   listResult.TaskArns,
+	logger := log.WithFields(&log.Fields{"task_definition": opts.TaskDefinition})
+
+	logger.Info("Looking for ECS Task...")
+
+	target, err := ResolveTarget(ctx, TargetOptions{
+		Profile:        opts.Profile,
+		Cluster:        opts.Cluster,
+		Service:        opts.Service,
+		TaskID:         opts.TaskID,
+		TaskDefinition: opts.TaskDefinition,
+		Container:      opts.ContainerName,
+		Choose:         opts.Choose,
 	})
 	if err != nil {
-		ctx.WithError(err).Error("Can't describe tasks")
-		return 1, err
-	}
-
-	tasks := describeResult.Tasks
-	var foundTask *ecs.Task
-	for _, task := range tasks {
-		if strings.Contains(aws.StringValue(task.TaskDefinitionArn), taskDefinitionName) {
-			foundTask = task
-		}
+		logger.WithError(err).Error("Can't find the container")
+		return err
 	}
 
-	if foundTask == nil {
-		err := fmt.Errorf("Can't find matching task")
-		ctx.WithFields(log.Fields{"task_definition": taskDefinitionName}).Error(err.Error())
-		return 1, err
+	if target.ContainerInstanceArn == "" {
+		return fmt.Errorf("task %s doesn't run on EC2, use exec to connect to it", target.TaskID)
 	}
+	logger.WithField("task_arn", target.TaskArn).Info("Looking for EC2 Instance...")
 
-	ctx.WithField("task_arn", aws.StringValue(foundTask.TaskArn)).Info("Looking for EC2 Instance...")
-
-	contInstanceResult, err := svc.DescribeContainerInstances(&ecs.DescribeContainerInstancesInput{
-		ContainerInstances: []*string{foundTask.ContainerInstanceArn},
-		Cluster:            aws.String(cluster),
+	svc := ecs.NewFromConfig(localConfig)
+	contInstanceResult, err := svc.DescribeContainerInstances(ctx, &ecs.DescribeContainerInstancesInput{
+		ContainerInstances: []string{target.ContainerInstanceArn},
+		Cluster:            aws.String(opts.Cluster),
 	})
 	if err != nil {
-		ctx.WithError(err).Error("Can't get container instance")
-		return 1, err
+		logger.WithError(err).Error("Can't get container instance")
+		return err
 	}
 
 	instance := contInstanceResult.ContainerInstances[0]
 	instanceID := instance.Ec2InstanceId
 
-	ec2Svc := ec2.New(localSession)
-	ec2Result, err := ec2Svc.DescribeInstances(&ec2.DescribeInstancesInput{
-		InstanceIds: []*string{instanceID},
+	ec2Svc := ec2.NewFromConfig(localConfig)
+	ec2Result, err := ec2Svc.DescribeInstances(ctx, &ec2.DescribeInstancesInput{
+		InstanceIds: []string{aws.ToString(instanceID)},
 	})
 	if err != nil {
-		ctx.WithError(err).Error("Can't get ec2 instance")
-		return 1, err
+		logger.WithError(err).Error("Can't get ec2 instance")
+		return err
 	}
 
 	ec2Instance := ec2Result.Reservations[0].Instances[0]
 
-	if pushSSHKey {
-		ec2ICSvc := ec2instanceconnect.New(localSession)
+	if opts.PushSSHKey {
+		ec2ICSvc := ec2instanceconnect.NewFromConfig(localConfig)
 
-		ctx.WithField("instance_id", aws.StringValue(ec2Instance.InstanceId)).Info("Pushing SSH key...")
+		logger.WithField("instance_id", aws.ToString(ec2Instance.InstanceId)).Info("Pushing SSH key...")
 
 		sshAgent, err := net.Dial("unix", os.Getenv("SSH_AUTH_SOCK"))
 		if err != nil {
-			ctx.WithError(err).Error("Can't connect to the ssh agent")
-			return 1, err
+			logger.WithError(err).Error("Can't connect to the ssh agent")
+			return err
 		}
 
 		keys, err := agent.NewClient(sshAgent).List()
 		if err != nil {
-			ctx.WithError(err).Error("Can't get public keys from ssh agent. Please ensure you have the ssh-agent running")
-			return 1, err
+			logger.WithError(err).Error("Can't get public keys from ssh agent. Please ensure you have the ssh-agent running")
+			return err
 		}
 		if len(keys) < 1 {
-			ctx.Error("Can't get public keys from ssh agent. Please ensure you have at least one identity added (with ssh-add)")
-			return 1, err
+			logger.Error("Can't get public keys from ssh agent. Please ensure you have at least one identity added (with ssh-add)")
+			return errors.New("no identities in the ssh agent")
 		}
 		pubkey := keys[0].String()
 
-		_, err = ec2ICSvc.SendSSHPublicKey(&ec2instanceconnect.SendSSHPublicKeyInput{
+		_, err = ec2ICSvc.SendSSHPublicKey(ctx, &ec2instanceconnect.SendSSHPublicKeyInput{
 			InstanceId:       ec2Instance.InstanceId,
-			InstanceOSUser:   aws.String(instanceUser),
+			InstanceOSUser:   aws.String(opts.InstanceUser),
 			AvailabilityZone: ec2Instance.Placement.AvailabilityZone,
 			SSHPublicKey:     aws.String(pubkey),
 		})
 		if err != nil {
-			ctx.WithError(err).Error("Can't push SSH key")
-			return 1, err
+			logger.WithError(err).Error("Can't push SSH key")
+			return err
 		}
 	}
 
-	ctx.WithField("instance_id", aws.StringValue(ec2Instance.InstanceId)).Info("Connecting to container...")
+	logger.WithField("instance_id", aws.ToString(ec2Instance.InstanceId)).Info("Connecting to container...")
 
 	params := []string{
 		"ssh",
 		"-tt",
-		fmt.Sprintf("%s@%s.%s", instanceUser, aws.StringValue(ec2Instance.PrivateIpAddress), profile),
+		fmt.Sprintf("%s@%s.%s", opts.InstanceUser, aws.ToString(ec2Instance.PrivateIpAddress), opts.Profile),
 		"docker-exec",
-		aws.StringValue(foundTask.TaskArn),
-		containerName,
-		shell,
+		target.TaskArn,
+		target.Container,
+		opts.Shell,
 	}
 
 	env := os.Environ()
 
-	return 0, syscall.Exec("/usr/bin/ssh", params, env)
+	return syscall.Exec("/usr/bin/ssh", params, env)
 }
@@ -2,6 +2,7 @@ package lib
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -10,19 +11,20 @@ import (
 	"strings"
 
 	"github.com/apex/log"
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/aws/aws-sdk-go-v2/service/ssm/types"
 	"github.com/imdario/mergo"
 )
 
-func WriteSSMParameter(profile, parameterName, kmsKey, value string, processor []string, pickJsonKeys []string) error {
-	err := makeSession(profile)
+func WriteSSMParameter(ctx context.Context, profile, parameterName, kmsKey, value string, processor []string, pickJsonKeys []string) error {
+	err := makeConfig(ctx, profile)
 	if err != nil {
 		return err
 	}
 	// run the processor command, feed the value to its input and get the response
 	if len(processor) > 0 {
-		value, err = runProcessor(value, processor)
+		value, err = runProcessor(ctx, value, processor)
 		if err != nil {
 			return fmt.Errorf("processor command exited with an error: %s", err)
 		}
@@ -35,10 +37,10 @@ func WriteSSMParameter(profile, parameterName, kmsKey, value string, processor [
 		}
 	}
 
-	svc := ssm.New(localSession)
-	resp, err := svc.PutParameter(&ssm.PutParameterInput{
+	svc := ssm.NewFromConfig(localConfig)
+	resp, err := svc.PutParameter(ctx, &ssm.PutParameterInput{
 		Name:      aws.String(parameterName),
-		Type:      aws.String(ssm.ParameterTypeSecureString),
+		Type:      types.ParameterTypeSecureString,
 		KeyId:     aws.String(kmsKey),
 		Value:     aws.String(value),
 		Overwrite: aws.Bool(true),
@@ -46,16 +48,16 @@ func WriteSSMParameter(profile, parameterName, kmsKey, value string, processor [
 	if err != nil {
 		return err
 	}
-	log.Infof("wrote parameter '%s' version %d", parameterName, aws.Int64Value(resp.Version))
+	log.Infof("wrote parameter '%s' version %d", parameterName, resp.Version)
 
 	return nil
 }
 
-func runProcessor(value string, command []string) (string, error) {
+func runProcessor(ctx context.Context, value string, command []string) (string, error) {
 	log.Debugf("running command '%s'", strings.Join(command, " "))
 	output := new(bytes.Buffer)
 
-	cmd := exec.Command(command[0], command[1:]...)
+	cmd := exec.CommandContext(ctx, command[0], command[1:]...)
 
 	// get the stdin to write to
 	stdin, err := cmd.StdinPipe()
@@ -0,0 +1,127 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+// Tags added to the task definitions registered by deploy
+const (
+	TagDeployedBy = "ecs-tool:deployed-by"
+	TagDeployedAt = "ecs-tool:deployed-at"
+	TagGitSHA     = "ecs-tool:git-sha"
+	TagImageTags  = "ecs-tool:image-tags"
+)
+
+// toolTagPrefix is the prefix of the tags ecs-tool manages
+const toolTagPrefix = "ecs-tool:"
+
+// maxTagValueLength is the ECS limit on tag values
+const maxTagValueLength = 256
+
+// parseTags turns "key=value" strings into ECS tags
+func parseTags(tags []string) ([]types.Tag, error) {
+	var result []types.Tag
+	for _, tag := range tags {
+		kv := strings.SplitN(tag, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf("tag %q should be in key=value format", tag)
+		}
+		result = append(result, types.Tag{
+			Key:   aws.String(kv[0]),
+			Value: aws.String(kv[1]),
+		})
+	}
+	return result, nil
+}
+
+// deployTags collects the metadata about who deploys what and when.
+// Metadata that can't be found is skipped, it should never fail a deploy
+func deployTags(ctx context.Context, extraTags []string) ([]types.Tag, error) {
+	extra, err := parseTags(extraTags)
+	if err != nil {
+		return nil, err
+	}
+
+	tags := []types.Tag{{
+		Key:   aws.String(TagDeployedAt),
+		Value: aws.String(time.Now().UTC().Format(time.RFC3339)),
+	}}
+
+	identity, err := sts.NewFromConfig(localConfig).GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{})
+	if err != nil {
+		log.WithError(err).Warn("Can't get the caller identity, won't tag who deployed")
+	} else {
+		tags = append(tags, types.Tag{
+			Key:   aws.String(TagDeployedBy),
+			Value: identity.Arn,
+		})
+	}
+
+	if sha := GitSHA(); sha != "" {
+		tags = append(tags, types.Tag{
+			Key:   aws.String(TagGitSHA),
+			Value: aws.String(sha),
+		})
+	} else {
+		log.Debug("Can't find out the git commit, won't tag it")
+	}
+
+	return append(tags, extra...), nil
+}
+
+// imageTagsTag lists the image tags of all containers, i.e. "app=v1.2 nginx=latest"
+func imageTagsTag(containerDefinitions []types.ContainerDefinition) types.Tag {
+	var images []string
+	for _, containerDefinition := range containerDefinitions {
+		imageWithTag := strings.SplitN(aws.ToString(containerDefinition.Image), ":", 2)
+		if len(imageWithTag) == 2 {
+			images = append(images, fmt.Sprintf("%s=%s", aws.ToString(containerDefinition.Name), imageWithTag[1]))
+		}
+	}
+	value := strings.Join(images, " ")
+	if len(value) > maxTagValueLength {
+		value = value[:maxTagValueLength]
+	}
+	return types.Tag{
+		Key:   aws.String(TagImageTags),
+		Value: aws.String(value),
+	}
+}
+
+// mergeTags replaces tags in base with the ones with the same key from overrides and appends the rest
+func mergeTags(base []types.Tag, overrides ...types.Tag) []types.Tag {
+	index := make(map[string]int)
+	result := make([]types.Tag, 0, len(base)+len(overrides))
+	for _, tags := range [][]types.Tag{base, overrides} {
+		for _, tag := range tags {
+			key := aws.ToString(tag.Key)
+			if n, ok := index[key]; ok {
+				result[n] = tag
+				continue
+			}
+			index[key] = len(result)
+			result = append(result, tag)
+		}
+	}
+	return result
+}
+
+// withoutToolTags drops the ecs-tool tags of the revision a new one is based on, so that
+// the metadata deployTags couldn't find isn't carried over from another deploy
+func withoutToolTags(tags []types.Tag) []types.Tag {
+	var result []types.Tag
+	for _, tag := range tags {
+		if !strings.HasPrefix(aws.ToString(tag.Key), toolTagPrefix) {
+			result = append(result, tag)
+		}
+	}
+	return result
+}
@@ -0,0 +1,75 @@
+package lib
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+)
+
+func TestParseTags(t *testing.T) {
+	tags, err := parseTags([]string{"team=web", "note=a=b", "empty="})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{"team": "web", "note": "a=b", "empty": ""}
+	for _, tag := range tags {
+		if expected[aws.ToString(tag.Key)] != aws.ToString(tag.Value) {
+			t.Fatalf("unexpected tag %s=%s", aws.ToString(tag.Key), aws.ToString(tag.Value))
+		}
+	}
+	for _, invalid := range []string{"team", "=web"} {
+		if _, err := parseTags([]string{invalid}); err == nil {
+			t.Fatalf("%q should be rejected", invalid)
+		}
+	}
+}
+
+func TestMergeTags(t *testing.T) {
+	base := []types.Tag{
+		{Key: aws.String("env"), Value: aws.String("prod")},
+		{Key: aws.String(TagGitSHA), Value: aws.String("old")},
+	}
+	merged := mergeTags(base,
+		types.Tag{Key: aws.String(TagGitSHA), Value: aws.String("new")},
+		types.Tag{Key: aws.String(TagDeployedBy), Value: aws.String("me")},
+	)
+	if len(merged) != 3 {
+		t.Fatalf("expected 3 tags, got %v", merged)
+	}
+	if aws.ToString(merged[1].Value) != "new" || aws.ToString(merged[2].Value) != "me" {
+		t.Fatalf("unexpected merge result %v", merged)
+	}
+	if aws.ToString(base[1].Value) != "old" {
+		t.Fatal("base tags should not be modified")
+	}
+}
+
+func TestImageTagsTag(t *testing.T) {
+	tag := imageTagsTag([]types.ContainerDefinition{
+		{Name: aws.String("app"), Image: aws.String("repo/app:v1.2")},
+		{Name: aws.String("sidecar"), Image: aws.String("sidecar")},
+		{Name: aws.String("nginx"), Image: aws.String("nginx:latest")},
+	})
+	if value := aws.ToString(tag.Value); value != "app=v1.2 nginx=latest" {
+		t.Fatalf("unexpected image tags %q", value)
+	}
+}
+
+func TestWithoutToolTags(t *testing.T) {
+	base := []types.Tag{
+		{Key: aws.String("env"), Value: aws.String("prod")},
+		{Key: aws.String(TagGitSHA), Value: aws.String("stale")},
+		{Key: aws.String(TagDeployedBy), Value: aws.String("somebody else")},
+	}
+	// git and the caller identity couldn't be found, so there are no such overrides
+	merged := mergeTags(withoutToolTags(base), types.Tag{Key: aws.String(TagDeployedAt), Value: aws.String("now")})
+	for _, tag := range merged {
+		if key := aws.ToString(tag.Key); key == TagGitSHA || key == TagDeployedBy {
+			t.Fatalf("%s of the previous revision should not be carried over, got %v", key, merged)
+		}
+	}
+	if len(merged) != 2 || aws.ToString(merged[0].Key) != "env" {
+		t.Fatalf("the other tags should be kept, got %v", merged)
+	}
+}
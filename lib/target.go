@@ -0,0 +1,191 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+)
+
+// Chooser asks which of the options to pick and returns its index
+type Chooser func(question string, options []string) (int, error)
+
+// TargetOptions narrow down the running container to connect to. Empty ones don't narrow anything
+type TargetOptions struct {
+	Profile string
+	Cluster string
+	Service string
+	// TaskID is the ID of the task or its prefix
+	TaskID string
+	// TaskDefinition is a part of the task definition ARN, i.e. the family
+	TaskDefinition string
+	Container      string
+	// ContainerHint is picked without asking if the task runs a container with that name and Container isn't set,
+	// otherwise it's ignored
+	ContainerHint string
+	// Choose is asked to pick the service, the task and the container when there is more than one.
+	// Without it the newest task is picked and more than one container is an error
+	Choose Chooser
+}
+
+// Target is the running container picked by ResolveTarget
+type Target struct {
+	Service           string
+	TaskArn           string
+	TaskID            string
+	TaskDefinitionArn string
+	Container         string
+	// ContainerInstanceArn is set for the tasks running on EC2
+	ContainerInstanceArn string
+}
+
+// ResolveTarget finds the running container matching the options, asking to choose if there is more than one
+func ResolveTarget(ctx context.Context, opts TargetOptions) (*Target, error) {
+	if err := makeConfig(ctx, opts.Profile); err != nil {
+		return nil, err
+	}
+	tasks, err := listClusterTasks(ctx, ecs.NewFromConfig(localConfig), opts.Cluster, opts.Service, types.DesiredStatusRunning)
+	if err != nil {
+		return nil, err
+	}
+	return resolveTarget(tasks, opts, time.Now())
+}
+
+// taskService returns the name of the service that started the task, if any
+func taskService(task types.Task) string {
+	return strings.TrimPrefix(aws.ToString(task.Group), "service:")
+}
+
+func resolveTarget(tasks []types.Task, opts TargetOptions, now time.Time) (*Target, error) {
+	var matching []types.Task
+	for _, task := range tasks {
+		taskID, _ := parseTaskUUID(task.TaskArn)
+		switch {
+		case opts.TaskID != "" && !strings.HasPrefix(taskID, opts.TaskID):
+		case opts.Service != "" && taskService(task) != opts.Service:
+		case opts.TaskDefinition != "" && !strings.Contains(aws.ToString(task.TaskDefinitionArn), opts.TaskDefinition):
+		default:
+			matching = append(matching, task)
+		}
+	}
+	if len(matching) == 0 {
+		return nil, fmt.Errorf("%w in cluster %s", ErrTaskNotFound, opts.Cluster)
+	}
+	// the newest first, that's what is picked without asking
+	sort.SliceStable(matching, func(i, j int) bool {
+		return aws.ToTime(matching[i].StartedAt).After(aws.ToTime(matching[j].StartedAt))
+	})
+
+	if opts.Choose != nil {
+		var services []string
+		count := make(map[string]int)
+		for _, task := range matching {
+			service := taskService(task)
+			if count[service] == 0 {
+				services = append(services, service)
+			}
+			count[service]++
+		}
+		if len(services) > 1 {
+			var options []string
+			for _, service := range services {
+				options = append(options, fmt.Sprintf("%s (%d tasks)", dashIfEmpty(service), count[service]))
+			}
+			n, err := opts.Choose("Which service?", options)
+			if err != nil {
+				return nil, err
+			}
+			var picked []types.Task
+			for _, task := range matching {
+				if taskService(task) == services[n] {
+					picked = append(picked, task)
+				}
+			}
+			matching = picked
+		}
+	}
+
+	task := matching[0]
+	if len(matching) > 1 {
+		if opts.Choose == nil {
+			log.WithField("task_arn", aws.ToString(task.TaskArn)).Infof("Picked the newest of %d tasks, use --task_id to pick another one", len(matching))
+		} else {
+			var options []string
+			for _, task := range matching {
+				options = append(options, describeTargetTask(task, now))
+			}
+			n, err := opts.Choose("Which task?", options)
+			if err != nil {
+				return nil, err
+			}
+			task = matching[n]
+		}
+	}
+
+	taskID, _ := parseTaskUUID(task.TaskArn)
+	target := &Target{
+		Service:              taskService(task),
+		TaskArn:              aws.ToString(task.TaskArn),
+		TaskID:               taskID,
+		TaskDefinitionArn:    aws.ToString(task.TaskDefinitionArn),
+		ContainerInstanceArn: aws.ToString(task.ContainerInstanceArn),
+	}
+	name := opts.Container
+	if name == "" && opts.ContainerHint != "" {
+		for _, container := range task.Containers {
+			if aws.ToString(container.Name) == opts.ContainerHint {
+				name = opts.ContainerHint
+			}
+		}
+	}
+	var containers []string
+	for _, container := range task.Containers {
+		if name == "" || aws.ToString(container.Name) == name {
+			containers = append(containers, aws.ToString(container.Name))
+		}
+	}
+	switch {
+	case len(containers) == 0:
+		return nil, fmt.Errorf("%w: %s in task %s", ErrContainerNotFound, name, taskID)
+	case len(containers) == 1:
+		target.Container = containers[0]
+	case opts.Choose == nil:
+		return nil, fmt.Errorf("%w in task %s: %s, use --container to pick one", ErrAmbiguousTarget, taskID, strings.Join(containers, ", "))
+	default:
+		var options []string
+		for _, container := range task.Containers {
+			options = append(options, fmt.Sprintf("%s  %s  health %s",
+				aws.ToString(container.Name),
+				strings.ToLower(dashIfEmpty(aws.ToString(container.LastStatus))),
+				strings.ToLower(dashIfEmpty(string(container.HealthStatus))),
+			))
+		}
+		n, err := opts.Choose("Which container?", options)
+		if err != nil {
+			return nil, err
+		}
+		target.Container = containers[n]
+	}
+	return target, nil
+}
+
+// describeTargetTask is how the task is listed to choose from: its ID, revision, age and health
+func describeTargetTask(task types.Task, now time.Time) string {
+	taskID, _ := parseTaskUUID(task.TaskArn)
+	age := "-"
+	if task.StartedAt != nil {
+		age = now.Sub(*task.StartedAt).Round(time.Second).String()
+	}
+	return fmt.Sprintf("%s  %s  up %s  health %s",
+		taskID,
+		taskDefinitionName(aws.ToString(task.TaskDefinitionArn)),
+		age,
+		strings.ToLower(dashIfEmpty(string(task.HealthStatus))),
+	)
+}
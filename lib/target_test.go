@@ -0,0 +1,85 @@
+package lib
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+)
+
+func targetTask(id, service, revision string, started time.Time, containers ...string) types.Task {
+	task := types.Task{
+		TaskArn:           aws.String("arn:aws:ecs:us-east-1:1:task/cluster/" + id),
+		Group:             aws.String("service:" + service),
+		TaskDefinitionArn: aws.String("arn:aws:ecs:us-east-1:1:task-definition/" + service + ":" + revision),
+		StartedAt:         aws.Time(started),
+		HealthStatus:      types.HealthStatusHealthy,
+	}
+	for _, name := range containers {
+		task.Containers = append(task.Containers, types.Container{Name: aws.String(name), LastStatus: aws.String("RUNNING")})
+	}
+	return task
+}
+
+func TestResolveTarget(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	tasks := []types.Task{
+		targetTask("aaa111", "app", "3", now.Add(-2*time.Hour), "app", "nginx"),
+		targetTask("bbb222", "app", "4", now.Add(-time.Minute), "app", "nginx"),
+		targetTask("ccc333", "worker", "7", now.Add(-time.Hour), "worker"),
+	}
+
+	// without asking, the newest task is picked
+	target, err := resolveTarget(tasks, TargetOptions{Container: "app"}, now)
+	if err != nil || target.TaskID != "bbb222" || target.Container != "app" {
+		t.Fatalf("expected the newest task, got %+v, %v", target, err)
+	}
+	target, err = resolveTarget(tasks, TargetOptions{TaskID: "ccc"}, now)
+	if err != nil || target.TaskID != "ccc333" || target.Container != "worker" || target.Service != "worker" {
+		t.Fatalf("expected the task by its ID prefix and its only container, got %+v, %v", target, err)
+	}
+	if _, err := resolveTarget(tasks, TargetOptions{Service: "app"}, now); !errors.Is(err, ErrAmbiguousTarget) {
+		t.Fatalf("expected ErrAmbiguousTarget for two containers, got %v", err)
+	}
+	if _, err := resolveTarget(tasks, TargetOptions{Service: "app", Container: "db"}, now); !errors.Is(err, ErrContainerNotFound) {
+		t.Fatalf("expected ErrContainerNotFound, got %v", err)
+	}
+	if _, err := resolveTarget(tasks, TargetOptions{TaskDefinition: "api"}, now); !errors.Is(err, ErrTaskNotFound) {
+		t.Fatalf("expected ErrTaskNotFound, got %v", err)
+	}
+
+	// the hint is only taken if the task runs such a container
+	target, err = resolveTarget(tasks, TargetOptions{Service: "app", ContainerHint: "nginx"}, now)
+	if err != nil || target.Container != "nginx" {
+		t.Fatalf("expected the hinted container, got %+v, %v", target, err)
+	}
+	target, err = resolveTarget(tasks, TargetOptions{Service: "worker", ContainerHint: "ls"}, now)
+	if err != nil || target.Container != "worker" {
+		t.Fatalf("expected the hint to be ignored, got %+v, %v", target, err)
+	}
+
+	// services, then tasks, then containers are asked for
+	var questions [][]string
+	answers := []int{0, 1, 1}
+	choose := func(question string, options []string) (int, error) {
+		questions = append(questions, append([]string{question}, options...))
+		answer := answers[0]
+		answers = answers[1:]
+		return answer, nil
+	}
+	target, err = resolveTarget(tasks, TargetOptions{Choose: choose}, now)
+	if err != nil || target.TaskID != "aaa111" || target.Container != "nginx" {
+		t.Fatalf("expected the chosen container, got %+v, %v", target, err)
+	}
+	expected := [][]string{
+		{"Which service?", "app (2 tasks)", "worker (1 tasks)"},
+		{"Which task?", "bbb222  app:4  up 1m0s  health healthy", "aaa111  app:3  up 2h0m0s  health healthy"},
+		{"Which container?", "app  running  health -", "nginx  running  health -"},
+	}
+	if !reflect.DeepEqual(questions, expected) {
+		t.Fatalf("expected questions %q, got %q", expected, questions)
+	}
+}
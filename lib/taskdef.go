@@ -0,0 +1,396 @@
+package lib
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/apex/log"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+)
+
+// TaskDefinitionTemplateData is what task definition templates are rendered with
+type TaskDefinitionTemplateData struct {
+	// Vars come from the config and --var flags
+	Vars     map[string]string
+	Env      map[string]string
+	GitSHA   string
+	ImageTag string
+	Cluster  string
+}
+
+// NewTaskDefinitionTemplateData fills in the environment and git commit
+func NewTaskDefinitionTemplateData(vars map[string]string, imageTag, cluster string) TaskDefinitionTemplateData {
+	env := make(map[string]string)
+	for _, kv := range os.Environ() {
+		if pair := strings.SplitN(kv, "=", 2); len(pair) == 2 {
+			env[pair[0]] = pair[1]
+		}
+	}
+	return TaskDefinitionTemplateData{
+		Vars:     vars,
+		Env:      env,
+		GitSHA:   GitSHA(),
+		ImageTag: imageTag,
+		Cluster:  cluster,
+	}
+}
+
+var taskDefinitionTemplateFuncs = template.FuncMap{
+	// json quotes a value, i.e. "command": {{ json .Vars.command }}
+	"json": func(v interface{}) (string, error) {
+		out, err := json.Marshal(v)
+		return string(out), err
+	},
+}
+
+// renderTaskDefinition renders the template and parses the result as RegisterTaskDefinition input,
+// in the same JSON format as "aws ecs register-task-definition --cli-input-json"
+func renderTaskDefinition(templateFile string, data TaskDefinitionTemplateData) (*ecs.RegisterTaskDefinitionInput, []byte, error) {
+	content, err := os.ReadFile(templateFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	tmpl, err := template.New(filepath.Base(templateFile)).
+		Option("missingkey=error").
+		Funcs(taskDefinitionTemplateFuncs).
+		Parse(string(content))
+	if err != nil {
+		return nil, nil, fmt.Errorf("can't parse the template: %w", err)
+	}
+	rendered := new(bytes.Buffer)
+	if err := tmpl.Execute(rendered, data); err != nil {
+		return nil, nil, fmt.Errorf("can't render the template: %w", err)
+	}
+
+	var input ecs.RegisterTaskDefinitionInput
+	decoder := json.NewDecoder(bytes.NewReader(rendered.Bytes()))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&input); err != nil {
+		return nil, rendered.Bytes(), fmt.Errorf("rendered template isn't a valid task definition: %w", err)
+	}
+	if aws.ToString(input.Family) == "" {
+		return nil, rendered.Bytes(), fmt.Errorf("rendered template has no family")
+	}
+	if len(input.ContainerDefinitions) == 0 {
+		return nil, rendered.Bytes(), fmt.Errorf("rendered template has no container definitions")
+	}
+	return &input, rendered.Bytes(), nil
+}
+
+// RegisterTaskDefinitionTemplate renders the task definition template and registers it.
+// With dryRun the rendered task definition is printed instead.
+func RegisterTaskDefinitionTemplate(ctx context.Context, profile, templateFile string, data TaskDefinitionTemplateData, extraTags []string, dryRun bool) (taskDefinitionArn string, err error) {
+	logger := log.WithField("template", templateFile)
+	input, rendered, err := renderTaskDefinition(templateFile, data)
+	if err != nil {
+		if rendered != nil {
+			logger.Debugf("Rendered template:\n%s", rendered)
+		}
+		return "", err
+	}
+	logger = logger.WithField("family", aws.ToString(input.Family))
+	if dryRun {
+		fmt.Println(string(rendered))
+		return "", nil
+	}
+
+	err = makeConfig(ctx, profile)
+	if err != nil {
+		return "", err
+	}
+	tags, err := deployTags(ctx, extraTags)
+	if err != nil {
+		return "", err
+	}
+	input.Tags = nilIfEmpty(mergeTags(
+		mergeTags(withoutToolTags(input.Tags), tags...),
+		imageTagsTag(input.ContainerDefinitions),
+	))
+
+	registerResult, err := ecs.NewFromConfig(localConfig).RegisterTaskDefinition(ctx, input)
+	if err != nil {
+		logger.WithError(err).Error("Can't register task definition")
+		return "", err
+	}
+	taskDefinitionArn = aws.ToString(registerResult.TaskDefinition.TaskDefinitionArn)
+	logger.WithField("task_definition_arn", taskDefinitionArn).Info("Registered the task definition")
+	return taskDefinitionArn, nil
+}
+
+// exportTaskDefinitionInput copies the task definition without the read-only fields like revision and status
+func exportTaskDefinitionInput(taskDefinition *types.TaskDefinition, tags []types.Tag) *ecs.RegisterTaskDefinitionInput {
+	return &ecs.RegisterTaskDefinitionInput{
+		ContainerDefinitions:    taskDefinition.ContainerDefinitions,
+		Cpu:                     taskDefinition.Cpu,
+		EphemeralStorage:        taskDefinition.EphemeralStorage,
+		ExecutionRoleArn:        taskDefinition.ExecutionRoleArn,
+		Family:                  taskDefinition.Family,
+		InferenceAccelerators:   taskDefinition.InferenceAccelerators,
+		IpcMode:                 taskDefinition.IpcMode,
+		Memory:                  taskDefinition.Memory,
+		NetworkMode:             taskDefinition.NetworkMode,
+		PidMode:                 taskDefinition.PidMode,
+		PlacementConstraints:    taskDefinition.PlacementConstraints,
+		ProxyConfiguration:      taskDefinition.ProxyConfiguration,
+		RequiresCompatibilities: taskDefinition.RequiresCompatibilities,
+		RuntimePlatform:         taskDefinition.RuntimePlatform,
+		TaskRoleArn:             taskDefinition.TaskRoleArn,
+		Volumes:                 taskDefinition.Volumes,
+		Tags:                    nilIfEmpty(tags),
+	}
+}
+
+// taskDefinitionJSON serialises the input in the AWS API format, i.e. with camelCase keys
+func taskDefinitionJSON(input *ecs.RegisterTaskDefinitionInput) ([]byte, error) {
+	return json.MarshalIndent(apiDocument(reflect.ValueOf(input)), "", "  ")
+}
+
+// apiDocument converts an SDK value into the AWS API JSON document.
+// The SDK types have no JSON tags, so the field names are lower cased the way the API names them,
+// and the unset fields are left out
+func apiDocument(v reflect.Value) interface{} {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		return apiDocument(v.Elem())
+	case reflect.Struct:
+		doc := make(map[string]interface{})
+		for n := 0; n < v.NumField(); n++ {
+			field := v.Type().Field(n)
+			if field.PkgPath != "" {
+				continue
+			}
+			// the zero numbers and booleans that aren't pointers are unset too
+			if kind := field.Type.Kind(); kind >= reflect.Bool && kind <= reflect.Float64 && v.Field(n).IsZero() {
+				continue
+			}
+			if value := apiDocument(v.Field(n)); value != nil {
+				first, size := utf8.DecodeRuneInString(field.Name)
+				doc[string(unicode.ToLower(first))+field.Name[size:]] = value
+			}
+		}
+		if len(doc) == 0 {
+			return nil
+		}
+		return doc
+	case reflect.Map:
+		if v.Len() == 0 {
+			return nil
+		}
+		doc := make(map[string]interface{})
+		iter := v.MapRange()
+		for iter.Next() {
+			doc[iter.Key().String()] = apiDocument(iter.Value())
+		}
+		return doc
+	case reflect.Slice:
+		if v.Len() == 0 {
+			return nil
+		}
+		doc := make([]interface{}, v.Len())
+		for n := range doc {
+			doc[n] = apiDocument(v.Index(n))
+		}
+		return doc
+	case reflect.String:
+		if v.Len() == 0 {
+			return nil
+		}
+		return v.String()
+	}
+	return v.Interface()
+}
+
+// resolveTaskDefinition turns a revision number into family:revision. Anything else is used as is
+func resolveTaskDefinition(family, revision string) string {
+	if revision == "" {
+		return family
+	}
+	if _, err := strconv.Atoi(revision); err == nil {
+		return fmt.Sprintf("%s:%s", taskDefinitionFamily(family), revision)
+	}
+	return revision
+}
+
+// describeTaskDefinitionInput describes the task definition and returns it without the read-only fields
+func describeTaskDefinitionInput(ctx context.Context, svc *ecs.Client, taskDefinition string) (*ecs.RegisterTaskDefinitionInput, error) {
+	describeResult, err := svc.DescribeTaskDefinition(ctx, &ecs.DescribeTaskDefinitionInput{
+		TaskDefinition: aws.String(taskDefinition),
+		Include:        []types.TaskDefinitionField{types.TaskDefinitionFieldTags},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("can't get task definition %s: %w", taskDefinition, err)
+	}
+	return exportTaskDefinitionInput(describeResult.TaskDefinition, describeResult.Tags), nil
+}
+
+// ExportTaskDefinition prints the task definition as JSON that can be registered again,
+// i.e. with "ecs-tool taskdef register" or "aws ecs register-task-definition --cli-input-json"
+func ExportTaskDefinition(ctx context.Context, profile, family, revision string) error {
+	err := makeConfig(ctx, profile)
+	if err != nil {
+		return err
+	}
+	input, err := describeTaskDefinitionInput(ctx, ecs.NewFromConfig(localConfig), resolveTaskDefinition(family, revision))
+	if err != nil {
+		return err
+	}
+	out, err := taskDefinitionJSON(input)
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(out))
+	return nil
+}
+
+// DiffTaskDefinitions prints the fields that differ between two revisions
+func DiffTaskDefinitions(ctx context.Context, profile, family, from, to string) error {
+	err := makeConfig(ctx, profile)
+	if err != nil {
+		return err
+	}
+	svc := ecs.NewFromConfig(localConfig)
+
+	var docs [2]interface{}
+	for n, revision := range []string{from, to} {
+		input, err := describeTaskDefinitionInput(ctx, svc, resolveTaskDefinition(family, revision))
+		if err != nil {
+			return err
+		}
+		// diffValues compares plain JSON values, so numbers have to be float64 like in decoded JSON
+		out, err := json.Marshal(apiDocument(reflect.ValueOf(input)))
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(out, &docs[n]); err != nil {
+			return err
+		}
+	}
+
+	changes := diffValues("", docs[0], docs[1])
+	if len(changes) == 0 {
+		log.Info("Task definitions are the same")
+		return nil
+	}
+	for _, change := range changes {
+		fmt.Println(change)
+	}
+	return nil
+}
+
+// diffValues compares two JSON documents and returns one line per changed field.
+// Lists of objects with a "name", like containers or environment variables, are compared by name.
+func diffValues(path string, a, b interface{}) []string {
+	if reflect.DeepEqual(a, b) {
+		return nil
+	}
+	switch {
+	case a == nil:
+		return []string{fmt.Sprintf("+ %s: %s", path, jsonValue(b))}
+	case b == nil:
+		return []string{fmt.Sprintf("- %s: %s", path, jsonValue(a))}
+	}
+
+	switch av := a.(type) {
+	case map[string]interface{}:
+		bv, ok := b.(map[string]interface{})
+		if !ok {
+			break
+		}
+		var changes []string
+		for _, key := range unionKeys(av, bv) {
+			changes = append(changes, diffValues(joinPath(path, key), av[key], bv[key])...)
+		}
+		return changes
+	case []interface{}:
+		bv, ok := b.([]interface{})
+		if !ok {
+			break
+		}
+		if an, bn, ok := byName(av, bv); ok {
+			var changes []string
+			for _, key := range unionKeys(an, bn) {
+				changes = append(changes, diffValues(fmt.Sprintf("%s[%s]", path, key), an[key], bn[key])...)
+			}
+			return changes
+		}
+		if len(av) == len(bv) {
+			var changes []string
+			for n := range av {
+				changes = append(changes, diffValues(fmt.Sprintf("%s[%d]", path, n), av[n], bv[n])...)
+			}
+			return changes
+		}
+	}
+	return []string{fmt.Sprintf("~ %s: %s => %s", path, jsonValue(a), jsonValue(b))}
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func jsonValue(v interface{}) string {
+	out, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(out)
+}
+
+func unionKeys(a, b map[string]interface{}) []string {
+	seen := make(map[string]bool)
+	var keys []string
+	for _, m := range []map[string]interface{}{a, b} {
+		for key := range m {
+			if !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// byName indexes both lists by the "name" field, if every element has a unique one
+func byName(a, b []interface{}) (map[string]interface{}, map[string]interface{}, bool) {
+	index := func(list []interface{}) (map[string]interface{}, bool) {
+		result := make(map[string]interface{})
+		for _, item := range list {
+			object, ok := item.(map[string]interface{})
+			if !ok {
+				return nil, false
+			}
+			name, ok := object["name"].(string)
+			if !ok {
+				return nil, false
+			}
+			if _, duplicate := result[name]; duplicate {
+				return nil, false
+			}
+			result[name] = object
+		}
+		return result, true
+	}
+	an, aok := index(a)
+	bn, bok := index(b)
+	return an, bn, aok && bok && len(a)+len(b) > 0
+}
@@ -0,0 +1,158 @@
+package lib
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+)
+
+func writeTemplate(t *testing.T, content string) string {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "taskdef.json.tmpl")
+	if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestRenderTaskDefinition(t *testing.T) {
+	file := writeTemplate(t, `{
+  "family": "app-{{ .Vars.env }}",
+  "networkMode": "awsvpc",
+  "containerDefinitions": [{
+    "name": "app",
+    "image": "repo/app:{{ .ImageTag }}",
+    "memory": 512,
+    "command": ["sh", "-c", {{ json .Vars.command }}],
+    "environment": [{"name": "HOME", "value": "{{ .Env.HOME }}"}],
+    "logConfiguration": {"logDriver": "awslogs", "options": {"awslogs-group": "app"}}
+  }]
+}`)
+	data := TaskDefinitionTemplateData{
+		Vars:     map[string]string{"env": "prod", "command": `echo "hi"`},
+		Env:      map[string]string{"HOME": "/app"},
+		ImageTag: "v1.2",
+	}
+	input, _, err := renderTaskDefinition(file, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	container := input.ContainerDefinitions[0]
+	if aws.ToString(input.Family) != "app-prod" || aws.ToString(container.Image) != "repo/app:v1.2" {
+		t.Fatalf("unexpected task definition %+v", input)
+	}
+	if aws.ToInt32(container.Memory) != 512 || aws.ToString(container.Environment[0].Value) != "/app" {
+		t.Fatalf("unexpected container definition %+v", container)
+	}
+	if container.LogConfiguration.Options["awslogs-group"] != "app" {
+		t.Fatalf("unexpected log configuration %+v", container.LogConfiguration)
+	}
+
+	delete(data.Vars, "env")
+	if _, _, err := renderTaskDefinition(file, data); err == nil || !strings.Contains(err.Error(), "env") {
+		t.Fatalf("undefined variables should be an error, got %v", err)
+	}
+}
+
+func TestRenderTaskDefinitionValidation(t *testing.T) {
+	for name, content := range map[string]string{
+		"unknown field":  `{"family": "app", "revision": 41, "containerDefinitions": [{"name": "app"}]}`,
+		"missing family": `{"containerDefinitions": [{"name": "app"}]}`,
+		"no containers":  `{"family": "app", "containerDefinitions": []}`,
+		"not even json":  `family: app`,
+	} {
+		if _, _, err := renderTaskDefinition(writeTemplate(t, content), TaskDefinitionTemplateData{}); err == nil {
+			t.Fatalf("%s should be rejected", name)
+		}
+	}
+}
+
+func TestTaskDefinitionJSON(t *testing.T) {
+	content, err := taskDefinitionJSON(&ecs.RegisterTaskDefinitionInput{
+		Family: aws.String("app"),
+		ContainerDefinitions: []types.ContainerDefinition{{
+			Name:             aws.String("app"),
+			Memory:           aws.Int32(512),
+			LogConfiguration: &types.LogConfiguration{LogDriver: types.LogDriverAwslogs, Options: map[string]string{"awslogs-group": "app"}},
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{
+  "containerDefinitions": [
+    {
+      "logConfiguration": {
+        "logDriver": "awslogs",
+        "options": {
+          "awslogs-group": "app"
+        }
+      },
+      "memory": 512,
+      "name": "app"
+    }
+  ],
+  "family": "app"
+}`
+	if string(content) != expected {
+		t.Fatalf("unexpected document %s", content)
+	}
+}
+
+func TestDiffValues(t *testing.T) {
+	from := map[string]interface{}{
+		"family": "app",
+		"cpu":    "256",
+		"containerDefinitions": []interface{}{
+			map[string]interface{}{"name": "app", "image": "app:1", "environment": []interface{}{
+				map[string]interface{}{"name": "A", "value": "1"},
+				map[string]interface{}{"name": "B", "value": "2"},
+			}},
+			map[string]interface{}{"name": "nginx", "image": "nginx:1"},
+		},
+	}
+	to := map[string]interface{}{
+		"family": "app",
+		"memory": "512",
+		"containerDefinitions": []interface{}{
+			map[string]interface{}{"name": "nginx", "image": "nginx:1"},
+			map[string]interface{}{"name": "app", "image": "app:2", "environment": []interface{}{
+				map[string]interface{}{"name": "A", "value": "1"},
+				map[string]interface{}{"name": "B", "value": "3"},
+			}},
+		},
+	}
+	expected := []string{
+		`~ containerDefinitions[app].environment[B].value: "2" => "3"`,
+		`~ containerDefinitions[app].image: "app:1" => "app:2"`,
+		`- cpu: "256"`,
+		`+ memory: "512"`,
+	}
+	changes := diffValues("", from, to)
+	if len(changes) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, changes)
+	}
+	for n := range expected {
+		if changes[n] != expected[n] {
+			t.Fatalf("%s != %s", changes[n], expected[n])
+		}
+	}
+}
+
+func TestResolveTaskDefinition(t *testing.T) {
+	for _, c := range [][3]string{
+		{"app", "", "app"},
+		{"app", "41", "app:41"},
+		{"app:40", "41", "app:41"},
+		{"app", "other:3", "other:3"},
+	} {
+		if resolved := resolveTaskDefinition(c[0], c[1]); resolved != c[2] {
+			t.Fatalf("%s %s: %s != %s", c[0], c[1], resolved, c[2])
+		}
+	}
+}
@@ -1,40 +1,22 @@
 package lib
 
 import (
+	"context"
 	"fmt"
 	"strings"
 
 	"github.com/apex/log"
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/arn"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
-	"github.com/aws/aws-sdk-go/service/ec2"
-	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
 )
 
-var localSession *session.Session
-
-func makeSession(profile string) error {
-	if localSession == nil {
-		log.Debug("Creating session")
-		var err error
-		// create AWS session
-		localSession, err = session.NewSessionWithOptions(session.Options{
-			Config: aws.Config{},
-
-			SharedConfigState: session.SharedConfigEnable,
-			Profile:           profile,
-		})
-		if err != nil {
-			return fmt.Errorf("can't get aws session")
-		}
-	}
-	return nil
-}
-
 func parseTaskUUID(containerArn *string) (string, error) {
-	resourceArn, err := arn.Parse(aws.StringValue(containerArn))
+	resourceArn, err := arn.Parse(aws.ToString(containerArn))
 	if err != nil {
 		return "", err
 	}
@@ -49,28 +31,66 @@ func parseTaskUUID(containerArn *string) (string, error) {
 	return "", fmt.Errorf("Weird task arn, can't get resource UUID")
 }
 
-func printCloudWatchLogs(logGroup, streamName string) error {
-	logs := cloudwatchlogs.New(localSession)
-	err := logs.GetLogEventsPages(
-		&cloudwatchlogs.GetLogEventsInput{
-			LogGroupName: aws.String(logGroup),
-			// prefix-name/container-name/ecs-task-id
-			LogStreamName: aws.String(streamName),
-		},
-		func(page *cloudwatchlogs.GetLogEventsOutput, lastPage bool) bool {
-			if len(page.Events) > 0 {
-				for _, event := range page.Events {
-					fmt.Println(aws.StringValue(event.Message))
-				}
-			}
-			return true
-		})
-	return err
+// taskDefinitionName returns family:revision from a task definition ARN
+func taskDefinitionName(taskDefinitionArn string) string {
+	if parsed, err := arn.Parse(taskDefinitionArn); err == nil {
+		return strings.TrimPrefix(parsed.Resource, "task-definition/")
+	}
+	return taskDefinitionArn
+}
+
+// taskDefinitionFamily returns the family from a task definition ARN or family:revision
+func taskDefinitionFamily(taskDefinitionArn string) string {
+	return strings.SplitN(taskDefinitionName(taskDefinitionArn), ":", 2)[0]
+}
+
+func readCloudWatchLogs(ctx context.Context, logGroup, streamName string) ([]string, error) {
+	logs := cloudwatchlogs.NewFromConfig(localConfig)
+	input := &cloudwatchlogs.GetLogEventsInput{
+		LogGroupName: aws.String(logGroup),
+		// prefix-name/container-name/ecs-task-id
+		LogStreamName: aws.String(streamName),
+		StartFromHead: aws.Bool(true),
+	}
+	var messages []string
+	for {
+		page, err := logs.GetLogEvents(ctx, input)
+		if err != nil {
+			return messages, err
+		}
+		for _, event := range page.Events {
+			messages = append(messages, aws.ToString(event.Message))
+		}
+		// the forward token stays the same at the end of the stream
+		if page.NextForwardToken == nil || aws.ToString(page.NextForwardToken) == aws.ToString(input.NextToken) {
+			return messages, nil
+		}
+		input.NextToken = page.NextForwardToken
+	}
+}
 
+// tailCloudWatchLogs returns the last lines of the log stream
+func tailCloudWatchLogs(ctx context.Context, logGroup, streamName string, lines int32) ([]string, error) {
+	logs := cloudwatchlogs.NewFromConfig(localConfig)
+	result, err := logs.GetLogEvents(ctx, &cloudwatchlogs.GetLogEventsInput{
+		LogGroupName:  aws.String(logGroup),
+		LogStreamName: aws.String(streamName),
+		StartFromHead: aws.Bool(false),
+		Limit:         aws.Int32(lines),
+	})
+	if err != nil {
+		return nil, err
+	}
+	var messages []string
+	for _, event := range result.Events {
+		messages = append(messages, aws.ToString(event.Message))
+	}
+	return messages, nil
 }
-func deleteCloudWatchStream(logGroup, streamName string) error {
-	logs := cloudwatchlogs.New(localSession)
-	_, err := logs.DeleteLogStream(&cloudwatchlogs.DeleteLogStreamInput{
+
+func deleteCloudWatchStream(ctx context.Context, logGroup, streamName string) error {
+	logs := cloudwatchlogs.NewFromConfig(localConfig)
+	_, err := logs.DeleteLogStream(ctx, &cloudwatchlogs.DeleteLogStreamInput{
 		LogGroupName:  aws.String(logGroup),
 		LogStreamName: aws.String(streamName),
 	})
@@ -78,28 +98,29 @@ func deleteCloudWatchStream(logGroup, streamName string) error {
 	return err
 }
 
-func fetchCloudWatchLog(cluster, containerName, awslogGroup, taskUUID string, delete bool, ctx *log.Entry) error {
+func fetchCloudWatchLog(ctx context.Context, cluster, containerName, awslogGroup, taskUUID string, delete bool, logger *log.Entry) ([]string, error) {
 	streamName := strings.Join([]string{cluster, containerName, taskUUID}, "/")
 
 	defer func() {
-		ctx := ctx.WithFields(log.Fields{
+		logger := logger.WithFields(log.Fields{
 			"log_group":  awslogGroup,
 			"log_stream": streamName,
 		})
-		if err := deleteCloudWatchStream(awslogGroup, streamName); err != nil {
-			ctx.WithError(err).Error("Can't delete the log stream")
+		// the stream is deleted even if the context is cancelled
+		if err := deleteCloudWatchStream(context.WithoutCancel(ctx), awslogGroup, streamName); err != nil {
+			logger.WithError(err).Error("Can't delete the log stream")
 		} else {
-			ctx.Debug("Deleted log stream")
+			logger.Debug("Deleted log stream")
 		}
 	}()
-	return printCloudWatchLogs(awslogGroup, streamName)
+	return readCloudWatchLogs(ctx, awslogGroup, streamName)
 }
 
-func modifyContainerDefinitionImages(imageTag string, imageTags []string, workDir string, containerDefinitions []*ecs.ContainerDefinition, ctx log.Interface) error {
+func modifyContainerDefinitionImages(imageTag string, imageTags []string, workDir string, containerDefinitions []types.ContainerDefinition, logger log.Interface) error {
 
 	for n, containerDefinition := range containerDefinitions {
-		ctx := ctx.WithField("container_name", aws.StringValue(containerDefinition.Name))
-		imageWithTag := strings.SplitN(aws.StringValue(containerDefinition.Image), ":", 2)
+		logger := logger.WithField("container_name", aws.ToString(containerDefinition.Name))
+		imageWithTag := strings.SplitN(aws.ToString(containerDefinition.Image), ":", 2)
 
 		if len(imageWithTag) == 2 { // successfully split into 2 parts: repo and tag
 			var newTag string // if set we'll change the definition
@@ -111,24 +132,24 @@ func modifyContainerDefinitionImages(imageTag string, imageTags []string, workDi
 
 			if newTag != "" {
 				// replace some [arams
-				newTag = strings.Replace(newTag, "{container_name}", aws.StringValue(containerDefinition.Name), -1)
+				newTag = strings.Replace(newTag, "{container_name}", aws.ToString(containerDefinition.Name), -1)
 				image := strings.Join([]string{
 					imageWithTag[0],
 					newTag,
 				}, ":")
 				containerDefinitions[n].Image = aws.String(image)
-				ctx.WithFields(log.Fields{
+				logger.WithFields(log.Fields{
 					"image":   image,
 					"new_tag": newTag,
 					"old_tag": imageWithTag[1],
 				}).Debug("Image tag changed")
 			}
 		} else {
-			ctx.Debug("Container doesn't seem to have a tag in the image. It's safer to not do anything.")
+			logger.Debug("Container doesn't seem to have a tag in the image. It's safer to not do anything.")
 		}
 		if workDir != "" {
 			containerDefinitions[n].WorkingDirectory = aws.String(workDir)
-			ctx.WithField("workdir", workDir).Debug("Workdir changed")
+			logger.WithField("workdir", workDir).Debug("Workdir changed")
 		}
 
 	}
@@ -136,43 +157,43 @@ func modifyContainerDefinitionImages(imageTag string, imageTags []string, workDi
 }
 
 // fetchSubnetsByTag fetches subnet IDs by a specific tag name and value
-func fetchSubnetsByTag(svc *ec2.EC2, tagKey, tagValue string) ([]*string, error) {
+func fetchSubnetsByTag(ctx context.Context, svc *ec2.Client, tagKey, tagValue string) ([]string, error) {
 	input := &ec2.DescribeSubnetsInput{
-		Filters: []*ec2.Filter{
+		Filters: []ec2types.Filter{
 			{
 				Name:   aws.String(fmt.Sprintf("tag:%s", tagKey)),
-				Values: []*string{aws.String(tagValue)},
+				Values: []string{tagValue},
 			},
 		},
 	}
 
-	result, err := svc.DescribeSubnets(input)
+	result, err := svc.DescribeSubnets(ctx, input)
 	if err != nil {
 		return nil, fmt.Errorf("error describing subnets: %w", err)
 	}
 
-	var subnets []*string
+	var subnets []string
 	for _, subnet := range result.Subnets {
-		subnets = append(subnets, subnet.SubnetId)
+		subnets = append(subnets, aws.ToString(subnet.SubnetId))
 	}
 
 	return subnets, nil
 }
 
-func fetchSecurityGroupsByName(svc *ec2.EC2, securityGroupFilter string) ([]*string, error) {
+func fetchSecurityGroupsByName(ctx context.Context, svc *ec2.Client, securityGroupFilter string) ([]string, error) {
 	// Describe all security groups
 	input := &ec2.DescribeSecurityGroupsInput{}
 
-	result, err := svc.DescribeSecurityGroups(input)
+	result, err := svc.DescribeSecurityGroups(ctx, input)
 	if err != nil {
 		return nil, fmt.Errorf("error describing security groups: %w", err)
 	}
 
-	var securityGroups []*string
+	var securityGroups []string
 	// Loop through the security groups and add those that contain the filter in their name
 	for _, sg := range result.SecurityGroups {
-		if strings.Contains(*sg.GroupName, securityGroupFilter) {
-			securityGroups = append(securityGroups, sg.GroupId)
+		if strings.Contains(aws.ToString(sg.GroupName), securityGroupFilter) {
+			securityGroups = append(securityGroups, aws.ToString(sg.GroupId))
 		}
 	}
 
@@ -180,10 +201,27 @@ func fetchSecurityGroupsByName(svc *ec2.EC2, securityGroupFilter string) ([]*str
 	return securityGroups, nil
 }
 
+// registerTaskDefinitionInput makes a copy of the task definition ready to be registered as a new revision
+func registerTaskDefinitionInput(taskDefinition *types.TaskDefinition, tags []types.Tag) *ecs.RegisterTaskDefinitionInput {
+	return &ecs.RegisterTaskDefinitionInput{
+		ContainerDefinitions:    taskDefinition.ContainerDefinitions,
+		Cpu:                     taskDefinition.Cpu,
+		ExecutionRoleArn:        taskDefinition.ExecutionRoleArn,
+		Family:                  taskDefinition.Family,
+		Memory:                  taskDefinition.Memory,
+		NetworkMode:             taskDefinition.NetworkMode,
+		PlacementConstraints:    taskDefinition.PlacementConstraints,
+		RequiresCompatibilities: taskDefinition.Compatibilities,
+		TaskRoleArn:             taskDefinition.TaskRoleArn,
+		Volumes:                 taskDefinition.Volumes,
+		Tags:                    nilIfEmpty(tags),
+	}
+}
+
 // nilIfEmpty returns nil when tags is empty so AWS doesn't reject the call with
 // "Tags can not be empty" — the AWS API rejects an empty Tags list at the wire level;
 // passing nil omits the field entirely.
-func nilIfEmpty(tags []*ecs.Tag) []*ecs.Tag {
+func nilIfEmpty(tags []types.Tag) []types.Tag {
 	if len(tags) == 0 {
 		return nil
 	}
@@ -0,0 +1,201 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/apex/log"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+)
+
+// DefaultDiagnosisLogLines is how many log lines of each failing container are shown
+const DefaultDiagnosisLogLines = 20
+
+// stoppedTaskGroup is a set of stopped tasks that failed the same way
+type stoppedTaskGroup struct {
+	stopCode      string
+	stoppedReason string
+	// containers holds "name: exit code or reason" of the failed containers
+	containers []string
+	health     string
+	tasks      []types.Task
+}
+
+func (g stoppedTaskGroup) key() string {
+	return strings.Join([]string{g.stopCode, g.stoppedReason, strings.Join(g.containers, ","), g.health}, "|")
+}
+
+// failedContainers returns the containers that exited with a non-zero code or didn't start
+func failedContainers(task types.Task) []types.Container {
+	var failed []types.Container
+	for _, container := range task.Containers {
+		if aws.ToString(container.Reason) != "" || aws.ToInt32(container.ExitCode) != 0 {
+			failed = append(failed, container)
+		}
+	}
+	return failed
+}
+
+// groupStoppedTasks groups tasks by the way they failed, biggest groups first
+func groupStoppedTasks(tasks []types.Task) []stoppedTaskGroup {
+	index := make(map[string]int)
+	var groups []stoppedTaskGroup
+	for _, task := range tasks {
+		group := stoppedTaskGroup{
+			stopCode:      string(task.StopCode),
+			stoppedReason: aws.ToString(task.StoppedReason),
+			health:        string(task.HealthStatus),
+		}
+		for _, container := range failedContainers(task) {
+			state := aws.ToString(container.Reason)
+			if state == "" {
+				state = fmt.Sprintf("exit code %d", aws.ToInt32(container.ExitCode))
+			}
+			group.containers = append(group.containers, fmt.Sprintf("%s: %s", aws.ToString(container.Name), state))
+		}
+		sort.Strings(group.containers)
+
+		if n, ok := index[group.key()]; ok {
+			groups[n].tasks = append(groups[n].tasks, task)
+			continue
+		}
+		group.tasks = []types.Task{task}
+		index[group.key()] = len(groups)
+		groups = append(groups, group)
+	}
+	sort.SliceStable(groups, func(i, j int) bool {
+		return len(groups[i].tasks) > len(groups[j].tasks)
+	})
+	return groups
+}
+
+// printContainerLogs prints the last log lines of the failed containers of the task
+// if they log to CloudWatch with the awslogs driver
+func printContainerLogs(ctx context.Context, logger log.Interface, svc *ecs.Client, task types.Task, logLines int64) {
+	failed := failedContainers(task)
+	if len(failed) == 0 || logLines <= 0 {
+		return
+	}
+	describeResult, err := svc.DescribeTaskDefinition(ctx, &ecs.DescribeTaskDefinitionInput{
+		TaskDefinition: task.TaskDefinitionArn,
+	})
+	if err != nil {
+		logger.WithError(err).Warn("Can't get task definition to find the logs")
+		return
+	}
+	taskUUID, err := parseTaskUUID(task.TaskArn)
+	if err != nil {
+		logger.WithError(err).Warn("Can't parse task uuid")
+		return
+	}
+
+	for _, container := range failed {
+		logger := logger.WithField("container_name", aws.ToString(container.Name))
+		for _, containerDefinition := range describeResult.TaskDefinition.ContainerDefinitions {
+			if aws.ToString(containerDefinition.Name) != aws.ToString(container.Name) {
+				continue
+			}
+			logConfiguration := containerDefinition.LogConfiguration
+			if logConfiguration == nil || logConfiguration.LogDriver != types.LogDriverAwslogs {
+				logger.Debug("Container doesn't log to CloudWatch")
+				continue
+			}
+			logGroup := logConfiguration.Options["awslogs-group"]
+			streamName := awslogsStreamName(logConfiguration.Options["awslogs-stream-prefix"], container, taskUUID)
+			if streamName == "" {
+				logger.Debug("Can't tell the log stream of the container")
+				continue
+			}
+			lines, err := tailCloudWatchLogs(ctx, logGroup, streamName, int32(logLines))
+			if err != nil {
+				logger.WithError(err).WithField("log_stream", streamName).Warn("Can't fetch the logs")
+				continue
+			}
+			for _, line := range lines {
+				logger.Info(line)
+			}
+		}
+	}
+}
+
+// awslogsStreamName returns the log stream of the container. Without a prefix the awslogs driver
+// names the stream after the container ID, there is no prefix/container/task stream then
+func awslogsStreamName(prefix string, container types.Container, taskUUID string) string {
+	if prefix == "" {
+		return aws.ToString(container.RuntimeId)
+	}
+	return strings.Join([]string{prefix, aws.ToString(container.Name), taskUUID}, "/")
+}
+
+// diagnoseService explains why the tasks of the service stopped.
+// If taskDefinitionArn is set, only the tasks of that revision are looked at.
+func diagnoseService(ctx context.Context, logger log.Interface, svc *ecs.Client, cluster, service, taskDefinitionArn string, logLines int64) error {
+	tasks, err := listClusterTasks(ctx, svc, cluster, service, types.DesiredStatusStopped)
+	if err != nil {
+		return err
+	}
+	var stopped []types.Task
+	for _, task := range tasks {
+		if taskDefinitionArn == "" || aws.ToString(task.TaskDefinitionArn) == taskDefinitionArn {
+			stopped = append(stopped, task)
+		}
+	}
+	if len(stopped) == 0 {
+		logger.Info("No recently stopped tasks")
+		return nil
+	}
+
+	for _, group := range groupStoppedTasks(stopped) {
+		// the most recently stopped task is the most relevant one
+		sort.SliceStable(group.tasks, func(i, j int) bool {
+			return aws.ToTime(group.tasks[i].StoppedAt).After(aws.ToTime(group.tasks[j].StoppedAt))
+		})
+		task := group.tasks[0]
+		taskID, _ := parseTaskUUID(task.TaskArn)
+
+		logger := logger.WithFields(log.Fields{
+			"stop_code":       dashIfEmpty(group.stopCode),
+			"task_definition": taskDefinitionName(aws.ToString(task.TaskDefinitionArn)),
+			"last_task_id":    taskID,
+		})
+		if group.health != "" && group.health != string(types.HealthStatusUnknown) {
+			logger = logger.WithField("health", group.health)
+		}
+		if len(group.containers) > 0 {
+			logger = logger.WithField("containers", strings.Join(group.containers, ", "))
+		}
+		logger.Errorf("%d task(s) stopped: %s", len(group.tasks), dashIfEmpty(group.stoppedReason))
+
+		printContainerLogs(ctx, logger, svc, task, logLines)
+	}
+	return nil
+}
+
+// Diagnose explains why the recently stopped tasks of the services have failed.
+// If no services are given, all stopped tasks in the cluster are looked at.
+func Diagnose(ctx context.Context, profile, cluster string, services []string, logLines int64) error {
+	err := makeConfig(ctx, profile)
+	if err != nil {
+		return err
+	}
+	svc := ecs.NewFromConfig(localConfig)
+
+	if len(services) == 0 {
+		services = []string{""}
+	}
+	for _, service := range services {
+		logger := log.WithField("cluster", cluster)
+		if service != "" {
+			logger = logger.WithField("service", service)
+		}
+		if err := diagnoseService(ctx, logger, svc, cluster, service, "", logLines); err != nil {
+			logger.WithError(err).Error("Can't diagnose the service")
+			return err
+		}
+	}
+	return nil
+}
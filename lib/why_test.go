@@ -0,0 +1,52 @@
+package lib
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+)
+
+func TestGroupStoppedTasks(t *testing.T) {
+	crashed := func() types.Task {
+		return types.Task{
+			StopCode:      types.TaskStopCodeEssentialContainerExited,
+			StoppedReason: aws.String("Essential container in task exited"),
+			Containers: []types.Container{
+				{Name: aws.String("app"), ExitCode: aws.Int32(1)},
+				{Name: aws.String("nginx"), ExitCode: aws.Int32(0)},
+			},
+		}
+	}
+	pullFailed := types.Task{
+		StopCode:      types.TaskStopCodeTaskFailedToStart,
+		StoppedReason: aws.String("CannotPullContainerError"),
+		Containers: []types.Container{
+			{Name: aws.String("app"), Reason: aws.String("CannotPullContainerError: not found")},
+		},
+	}
+
+	groups := groupStoppedTasks([]types.Task{pullFailed, crashed(), crashed()})
+	if len(groups) != 2 {
+		t.Fatalf("expected 2 groups, got %d", len(groups))
+	}
+	if len(groups[0].tasks) != 2 || groups[0].stopCode != string(types.TaskStopCodeEssentialContainerExited) {
+		t.Fatalf("the biggest group should come first, got %+v", groups[0])
+	}
+	if len(groups[0].containers) != 1 || groups[0].containers[0] != "app: exit code 1" {
+		t.Fatalf("only the failed containers should be listed, got %v", groups[0].containers)
+	}
+	if groups[1].containers[0] != "app: CannotPullContainerError: not found" {
+		t.Fatalf("unexpected containers %v", groups[1].containers)
+	}
+}
+
+func TestAwslogsStreamName(t *testing.T) {
+	container := types.Container{Name: aws.String("app"), RuntimeId: aws.String("abc123")}
+	if name := awslogsStreamName("web", container, "task1"); name != "web/app/task1" {
+		t.Fatalf("unexpected stream %q", name)
+	}
+	if name := awslogsStreamName("", container, "task1"); name != "abc123" {
+		t.Fatalf("expected the container ID without a prefix, got %q", name)
+	}
+}